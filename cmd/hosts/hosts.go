@@ -0,0 +1,110 @@
+package hosts
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pmaojo/goploy/internal/deployment"
+	"github.com/spf13/cobra"
+)
+
+func New() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "hosts",
+		Short: "Manages the known host keys of servers",
+		Long: `Manages the known host keys of servers
+
+	Hosts are given as [user@]host[:port] or an ssh_config alias,
+	keys are kept in the known_hosts files of ssh_config
+	(~/.ssh/known_hosts by default).`,
+	}
+
+	cmd.AddCommand(newScan(), newTrust(), newForget())
+
+	return cmd
+}
+
+func newScan() *cobra.Command {
+	return &cobra.Command{
+		Use:   "scan <host>",
+		Short: "Prints the host keys of a server",
+		Long: `Prints the host keys of a server
+
+	Fetches the host keys without logging in and compares them
+	with known_hosts: known, unknown or mismatch.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			name, keys, err := deployment.ScanHostKeys(args[0])
+			if err != nil {
+				return err
+			}
+
+			//nolint:forbidigo
+			fmt.Printf("Host keys of %s:\n", name)
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, k := range keys {
+				fmt.Fprintf(w, "  %s\t%s\t%s\n", k.Type, k.Fingerprint, k.Status)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func newTrust() *cobra.Command {
+	var fingerprint string
+
+	cmd := &cobra.Command{
+		Use:   "trust <host>",
+		Short: "Adds the host keys of a server to known_hosts",
+		Long: `Adds the host keys of a server to known_hosts
+
+	Compare the fingerprints printed by 'goploy hosts scan' with the
+	server's (ssh-keygen -lf /etc/ssh/ssh_host_ed25519_key.pub) first,
+	or pass the expected one with --fingerprint to only trust that key.
+	Servers presenting another key than the known one have to be
+	forgotten first.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			added, err := deployment.TrustHostKeys(args[0], fingerprint)
+			for _, k := range added {
+				//nolint:forbidigo
+				fmt.Printf("Trusted %s key %s\n", k.Type, k.Fingerprint)
+			}
+			if err != nil {
+				return err
+			}
+			if len(added) == 0 {
+				//nolint:forbidigo
+				fmt.Printf("The host keys of %s are known already.\n", args[0])
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&fingerprint, "fingerprint", "", "SHA256 fingerprint of the only key to trust")
+
+	return cmd
+}
+
+func newForget() *cobra.Command {
+	return &cobra.Command{
+		Use:   "forget <host>",
+		Short: "Removes the host keys of a server from known_hosts",
+		Long: `Removes the host keys of a server from known_hosts
+
+	Use it after the server has been reinstalled, its next key is
+	then unknown (see 'goploy hosts trust' and the tofu host key policy).`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			removed, err := deployment.ForgetHostKeys(args[0])
+			if err != nil {
+				return err
+			}
+
+			//nolint:forbidigo
+			fmt.Printf("Removed %d known_hosts entries of %s.\n", removed, args[0])
+			return nil
+		},
+	}
+}
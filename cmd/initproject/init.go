@@ -0,0 +1,68 @@
+package initproject
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/deployment"
+	"github.com/spf13/cobra"
+)
+
+func New() *cobra.Command {
+	return &cobra.Command{
+		Use:   "init <project>",
+		Short: "Initializes a project on its hosts",
+		Long: `Initializes a project of goploy.yaml on its hosts
+
+	Checks that the container runtime (docker compose v2 by default) is installed,
+	creates the project path and clones the repo into it.
+	Hosts that have been initialized already are left as they are.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return runInit(args[0])
+		},
+	}
+}
+
+func runInit(name string) error {
+	goployCfg, err := config.LoadGoployConfig("goploy.yaml")
+	if err != nil {
+		return fmt.Errorf("failed to load goploy.yaml: %w", err)
+	}
+
+	var project *config.Project
+	for i := range goployCfg.Projects {
+		if goployCfg.Projects[i].Name == name {
+			project = &goployCfg.Projects[i]
+		}
+	}
+	if project == nil {
+		return fmt.Errorf("project %q not found in goploy.yaml", name)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var deployer deployment.Controller
+	if project.IsLocal() {
+		local := deployment.NewLocalClient(nil)
+		defer local.Close()
+		deployer = local
+	} else {
+		remote := deployment.NewSSHClient(nil)
+		defer remote.Close()
+		deployer = remote
+	}
+
+	if err := deployer.Init(ctx, *project, deployment.NewTextSink(os.Stdout)); err != nil {
+		return fmt.Errorf("failed to initialize %s: %w", name, err)
+	}
+
+	//nolint:forbidigo
+	fmt.Printf("%s initialized.\n", name)
+	return nil
+}
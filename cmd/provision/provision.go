@@ -0,0 +1,96 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pmaojo/goploy/internal/deployment"
+	"github.com/spf13/cobra"
+)
+
+type Flags struct {
+	IdentityFile string
+	DeployUser   string
+	PublicKey    string
+	Caddy        bool
+}
+
+func New() *cobra.Command {
+	var flags Flags
+
+	cmd := &cobra.Command{
+		Use:   "provision <host>",
+		Short: "Prepares a fresh server for deployments",
+		Long: `Prepares a fresh server for deployments
+
+	Connects to [user@]host[:port] as root (or a user allowed to sudo
+	without a password), adds its host key to known_hosts, installs
+	Docker Engine and the compose plugin, creates the deploy user in
+	the docker group and authorizes the public key for it.
+	Steps done before are skipped, provisioning can be repeated.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return runProvision(args[0], flags)
+		},
+	}
+
+	cmd.Flags().StringVarP(&flags.IdentityFile, "identity-file", "i", "", "private key to connect with")
+	cmd.Flags().StringVar(&flags.DeployUser, "user", "deploy", "deploy user to create")
+	cmd.Flags().StringVar(&flags.PublicKey, "public-key", "", "public key authorized for the deploy user (default: the identity file's .pub, ~/.ssh/id_ed25519.pub, id_ecdsa.pub or id_rsa.pub)")
+	cmd.Flags().BoolVar(&flags.Caddy, "caddy", false, "install Caddy with its admin API on localhost")
+
+	return cmd
+}
+
+func runProvision(host string, flags Flags) error {
+	keyFile, err := publicKeyFile(flags)
+	if err != nil {
+		return err
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read public key: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opts := deployment.ProvisionOptions{
+		Host:         host,
+		IdentityFile: flags.IdentityFile,
+		DeployUser:   flags.DeployUser,
+		PublicKey:    string(key),
+		Caddy:        flags.Caddy,
+	}
+	if err := deployment.NewSSHClient(nil).Provision(ctx, opts, deployment.NewTextSink(os.Stdout)); err != nil {
+		return fmt.Errorf("failed to provision %s: %w", host, err)
+	}
+	return nil
+}
+
+// publicKeyFile returns the --public-key file, or the public key of the identity file or a default key.
+func publicKeyFile(flags Flags) (string, error) {
+	if flags.PublicKey != "" {
+		return flags.PublicKey, nil
+	}
+	if flags.IdentityFile != "" {
+		return flags.IdentityFile + ".pub", nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	for _, name := range []string{"id_ed25519.pub", "id_ecdsa.pub", "id_rsa.pub"} {
+		f := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(f); err == nil {
+			return f, nil
+		}
+	}
+	return "", errors.New("no public key found in ~/.ssh, pass --public-key")
+}
@@ -5,6 +5,9 @@ import (
 	"os"
 
 	"github.com/pmaojo/goploy/cmd/env"
+	"github.com/pmaojo/goploy/cmd/hosts"
+	"github.com/pmaojo/goploy/cmd/initproject"
+	"github.com/pmaojo/goploy/cmd/provision"
 	"github.com/pmaojo/goploy/cmd/server"
 	"github.com/pmaojo/goploy/internal/config"
 	"github.com/rs/zerolog/log"
@@ -30,6 +33,9 @@ func Execute() {
 	// attach the subcommands
 	rootCmd.AddCommand(
 		env.New(),
+		hosts.New(),
+		initproject.New(),
+		provision.New(),
 		server.New(),
 	)
 
@@ -13,7 +13,9 @@ import (
 	"github.com/pmaojo/goploy/internal/api/router"
 	"github.com/pmaojo/goploy/internal/config"
 	"github.com/pmaojo/goploy/internal/deployment"
+	"github.com/pmaojo/goploy/internal/history"
 	"github.com/pmaojo/goploy/internal/mailer"
+	"github.com/pmaojo/goploy/internal/proxy"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -67,11 +69,17 @@ func runServer(flags Flags) {
 		log.Fatal().Err(err).Msg("Failed to initialize mailer")
 	}
 
+	// Initialize Deployment History
+	store := history.NewStore(goployCfg.HistoryDir)
+
 	// Initialize Deployment Controller
 	deployer := deployment.NewSSHClient(mail)
+	deployer.History = store
+	deployer.Trigger = history.TriggerAPI
+	deployer.Upstreams = proxy.NewCaddyClient(nil)
 
 	// Initialize Server
-	s := api.NewServer(cfg, goployCfg, mail, deployer)
+	s := api.NewServer(cfg, goployCfg, mail, deployer, store)
 
 	err = router.Init(s)
 	if err != nil {
@@ -97,4 +105,8 @@ func runServer(flags Flags) {
 	if errs := s.Shutdown(shutdownCtx); len(errs) > 0 {
 		log.Error().Errs("shutdownErrors", errs).Msg("Failed to gracefully shut down server")
 	}
+
+	if err := deployer.Close(); err != nil {
+		log.Error().Err(err).Msg("Failed to close SSH connections")
+	}
 }
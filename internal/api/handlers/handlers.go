@@ -13,6 +13,11 @@ func AttachAllRoutes(s *api.Server) {
 	// Projects routes
 	s.Router.APIV1Projects.GET("", projects.ListProjects(s))
 	s.Router.APIV1Projects.GET("/:name/status", projects.GetProjectStatus(s))
+	s.Router.APIV1Projects.POST("/:name/init", projects.InitProject(s))
+	s.Router.APIV1Projects.POST("/:name/plan", projects.PlanDeploy(s))
 	s.Router.APIV1Projects.POST("/:name/deploy", projects.TriggerDeploy(s))
+	s.Router.APIV1Projects.POST("/:name/rollback", projects.RollbackProject(s))
 	s.Router.APIV1Projects.GET("/:name/logs", projects.StreamProjectLogs(s))
+	s.Router.APIV1Projects.GET("/:name/deployments", projects.ListProjectDeployments(s))
+	s.Router.APIV1Projects.GET("/:name/deployments/:id", projects.GetProjectDeployment(s))
 }
@@ -0,0 +1,57 @@
+package projects
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/pmaojo/goploy/internal/api"
+	"github.com/pmaojo/goploy/internal/history"
+	"github.com/labstack/echo/v4"
+)
+
+// ListProjectDeployments returns the recorded deployments of a project, newest first.
+// The captured output is omitted, use GetProjectDeployment to fetch it.
+func ListProjectDeployments(s *api.Server) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		project := findProject(s, c.Param("name"))
+		if project == nil {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Project not found"})
+		}
+
+		deployments, err := s.History.List(project.Name)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+
+		if limit, err := strconv.Atoi(c.QueryParam("limit")); err == nil && limit > 0 && limit < len(deployments) {
+			deployments = deployments[:limit]
+		}
+
+		for i := range deployments {
+			deployments[i].Output = ""
+		}
+
+		return c.JSON(http.StatusOK, echo.Map{"deployments": deployments})
+	}
+}
+
+// GetProjectDeployment returns a single recorded deployment including its captured output.
+func GetProjectDeployment(s *api.Server) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		project := findProject(s, c.Param("name"))
+		if project == nil {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Project not found"})
+		}
+
+		deployment, err := s.History.Get(project.Name, c.Param("id"))
+		if err != nil {
+			if errors.Is(err, history.ErrNotFound) {
+				return c.JSON(http.StatusNotFound, echo.Map{"error": "Deployment not found"})
+			}
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, deployment)
+	}
+}
@@ -2,14 +2,27 @@ package projects
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/pmaojo/goploy/internal/api"
 	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/deployment"
+	"github.com/pmaojo/goploy/internal/history"
 	"github.com/labstack/echo/v4"
 )
 
+// findProject looks up a project of the goploy config by name, nil if it is not configured.
+func findProject(s *api.Server, name string) *config.Project {
+	for _, p := range s.GoployConfig.Projects {
+		if p.Name == name {
+			return &p
+		}
+	}
+	return nil
+}
+
 func ListProjects(s *api.Server) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		projects := make([]string, len(s.GoployConfig.Projects))
@@ -22,15 +35,7 @@ func ListProjects(s *api.Server) echo.HandlerFunc {
 
 func GetProjectStatus(s *api.Server) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		projectName := c.Param("name")
-		var project *config.Project
-		for _, p := range s.GoployConfig.Projects {
-			if p.Name == projectName {
-				project = &p
-				break
-			}
-		}
-
+		project := findProject(s, c.Param("name"))
 		if project == nil {
 			return c.JSON(http.StatusNotFound, echo.Map{"error": "Project not found"})
 		}
@@ -50,15 +55,7 @@ type TriggerDeployRequest struct {
 
 func TriggerDeploy(s *api.Server) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		projectName := c.Param("name")
-		var project *config.Project
-		for _, p := range s.GoployConfig.Projects {
-			if p.Name == projectName {
-				project = &p
-				break
-			}
-		}
-
+		project := findProject(s, c.Param("name"))
 		if project == nil {
 			return c.JSON(http.StatusNotFound, echo.Map{"error": "Project not found"})
 		}
@@ -67,44 +64,105 @@ func TriggerDeploy(s *api.Server) echo.HandlerFunc {
 		if err := c.Bind(&req); err != nil {
 			// Optional body, ignore error if empty but check if malformed
 		}
+		if err := deployment.ValidateRef(*project, req.Ref); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
 
-		c.Response().Header().Set(echo.HeaderContentType, "text/plain")
-		c.Response().WriteHeader(http.StatusOK)
+		writer, events := newEventStream(c, fmt.Sprintf("Starting deployment for %s (ref: %s)...\n", project.Name, req.Ref))
 
-		writer := c.Response()
+		if err := s.Deployment.Deploy(c.Request().Context(), *project, events, req.Ref); err != nil {
+			return writer.fail("Deployment", err)
+		}
 
-		// Helper to flush if possible
-		flush := func() {
-			c.Response().Flush()
+		writer.succeed("Deployment")
+
+		return nil
+	}
+}
+
+// PlanDeploy reports what a deployment of the ref in the body (see TriggerDeployRequest) would change, without deploying.
+func PlanDeploy(s *api.Server) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		project := findProject(s, c.Param("name"))
+		if project == nil {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Project not found"})
 		}
 
-		fmt.Fprintf(writer, "Starting deployment for %s (ref: %s)...\n", project.Name, req.Ref)
-		flush()
+		var req TriggerDeployRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body"})
+		}
+		if err := deployment.ValidateRef(*project, req.Ref); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
 
-		err := s.Deployment.Deploy(*project, writer, req.Ref)
+		plan, err := s.Deployment.Plan(c.Request().Context(), *project, req.Ref)
 		if err != nil {
-			fmt.Fprintf(writer, "\nDeployment failed: %v\n", err)
-			return nil // We already sent 200 OK and started streaming
+			var requestErr *deployment.PlanRequestError
+			if errors.As(err, &requestErr) {
+				return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+			}
+			var lockErr *deployment.LockError
+			if errors.As(err, &lockErr) {
+				return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+			}
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 		}
 
-		fmt.Fprintf(writer, "\nDeployment finished successfully.\n")
-		flush()
+		return c.JSON(http.StatusOK, plan)
+	}
+}
+
+// InitProject prepares the project on its hosts (see deployment.Controller.Init), streaming the progress like a deployment.
+func InitProject(s *api.Server) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		project := findProject(s, c.Param("name"))
+		if project == nil {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Project not found"})
+		}
+
+		writer, events := newEventStream(c, fmt.Sprintf("Initializing %s...\n", project.Name))
+
+		if err := s.Deployment.Init(c.Request().Context(), *project, events); err != nil {
+			return writer.fail("Initialization", err)
+		}
+
+		writer.succeed("Initialization")
 
 		return nil
 	}
 }
 
-func StreamProjectLogs(s *api.Server) echo.HandlerFunc {
+func RollbackProject(s *api.Server) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		projectName := c.Param("name")
-		var project *config.Project
-		for _, p := range s.GoployConfig.Projects {
-			if p.Name == projectName {
-				project = &p
-				break
+		project := findProject(s, c.Param("name"))
+		if project == nil {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Project not found"})
+		}
+
+		target, err := s.History.RollbackTarget(project.Name)
+		if err != nil {
+			if errors.Is(err, history.ErrNoRollbackTarget) {
+				return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
 			}
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+
+		writer, events := newEventStream(c, fmt.Sprintf("Starting rollback for %s (target commit: %s)...\n", project.Name, target.CommitAfter))
+
+		if err := s.Deployment.Rollback(c.Request().Context(), *project, events); err != nil {
+			return writer.fail("Rollback", err)
 		}
 
+		writer.succeed("Rollback")
+
+		return nil
+	}
+}
+
+func StreamProjectLogs(s *api.Server) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		project := findProject(s, c.Param("name"))
 		if project == nil {
 			return c.JSON(http.StatusNotFound, echo.Map{"error": "Project not found"})
 		}
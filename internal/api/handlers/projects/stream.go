@@ -0,0 +1,79 @@
+package projects
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pmaojo/goploy/internal/deployment"
+	"github.com/labstack/echo/v4"
+)
+
+// mimeNDJSON is requested via the Accept header to receive deployment events as newline delimited JSON.
+const mimeNDJSON = "application/x-ndjson"
+
+// streamResponse defers committing the streamed 200 response until the first write,
+// so failures reported before any output (e.g. a held project lock) still get a proper status code.
+type streamResponse struct {
+	c           echo.Context
+	contentType string
+	preamble    string
+	started     bool
+}
+
+// newEventStream streams deployment events as NDJSON if the client accepts it, otherwise as plain text
+// starting with preamble.
+func newEventStream(c echo.Context, preamble string) (*streamResponse, deployment.EventSink) {
+	if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), mimeNDJSON) {
+		w := &streamResponse{c: c, contentType: mimeNDJSON}
+		return w, deployment.NewJSONSink(w)
+	}
+
+	w := &streamResponse{c: c, contentType: echo.MIMETextPlain, preamble: preamble}
+	return w, deployment.NewTextSink(w)
+}
+
+func (w *streamResponse) Write(p []byte) (int, error) {
+	w.start()
+
+	n, err := w.c.Response().Write(p)
+	w.c.Response().Flush()
+	return n, err
+}
+
+func (w *streamResponse) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+
+	w.c.Response().Header().Set(echo.HeaderContentType, w.contentType)
+	w.c.Response().WriteHeader(http.StatusOK)
+	fmt.Fprint(w.c.Response(), w.preamble)
+}
+
+// fail reports err as JSON if nothing has been streamed yet, otherwise appends it to the stream.
+func (w *streamResponse) fail(action string, err error) error {
+	if !w.started {
+		status := http.StatusInternalServerError
+		var lockErr *deployment.LockError
+		if errors.As(err, &lockErr) {
+			status = http.StatusConflict
+		}
+		return w.c.JSON(status, echo.Map{"error": err.Error()})
+	}
+
+	// We already sent 200 OK and started streaming, NDJSON clients get the error from the result event.
+	if w.contentType != mimeNDJSON {
+		fmt.Fprintf(w, "\n%s failed: %v\n", action, err)
+	}
+	return nil
+}
+
+// succeed ends a plain text stream with a success line.
+func (w *streamResponse) succeed(action string) {
+	if w.contentType != mimeNDJSON {
+		fmt.Fprintf(w, "\n%s finished successfully.\n", action)
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/pmaojo/goploy/internal/config"
 	"github.com/pmaojo/goploy/internal/deployment"
+	"github.com/pmaojo/goploy/internal/history"
 	"github.com/pmaojo/goploy/internal/mailer"
 	"github.com/pmaojo/goploy/internal/util"
 	"github.com/labstack/echo/v4"
@@ -33,14 +34,16 @@ type Server struct {
 	GoployConfig   *config.GoployConfig
 	Deployment     deployment.Controller
 	Mailer         *mailer.Mailer
+	History        *history.Store
 }
 
-func NewServer(config config.Server, goployConfig *config.GoployConfig, mailer *mailer.Mailer, dep deployment.Controller) *Server {
+func NewServer(config config.Server, goployConfig *config.GoployConfig, mailer *mailer.Mailer, dep deployment.Controller, store *history.Store) *Server {
 	s := &Server{
 		Config:       config,
 		GoployConfig: goployConfig,
 		Mailer:       mailer,
 		Deployment:   dep,
+		History:      store,
 	}
 
 	return s
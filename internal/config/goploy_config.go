@@ -1,13 +1,22 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"strings"
+	"time"
+	"unicode"
 
 	"gopkg.in/yaml.v3"
 )
 
 // GoployConfig represents the structure of the goploy.yaml configuration file.
 type GoployConfig struct {
+	// HistoryDir is where deployment records are stored, defaults to .goploy/history.
+	HistoryDir string `yaml:"history_dir"`
+	// HostKey is the host key policy of projects without their own.
+	HostKey  *HostKey  `yaml:"host_key"`
 	Projects []Project `yaml:"projects"`
 }
 
@@ -15,14 +24,256 @@ type GoployConfig struct {
 type Project struct {
 	Name         string       `yaml:"name"`
 	Host         string       `yaml:"host"`
+	Hosts        []string     `yaml:"hosts"`
 	User         string       `yaml:"user"`
 	Port         string       `yaml:"port"`
 	IdentityFile string       `yaml:"identity_file"`
+	HostKey      *HostKey     `yaml:"host_key"`
+	ProxyJump    []JumpHost   `yaml:"proxy_jump"`
 	Path         string       `yaml:"path"`
+	Strategy     string       `yaml:"strategy"`
+	Git          *GitConfig   `yaml:"git"`
+	Image        *ImageConfig `yaml:"image"`
+	LocalBuild   *LocalBuild  `yaml:"local_build"`
+	Upload       *Upload      `yaml:"upload"`
+	Compose      *Compose     `yaml:"compose"`
 	Repo         string       `yaml:"repo"`
+	Clone        *Clone       `yaml:"clone"`
 	NotifyEmails []string     `yaml:"notify_emails"`
 	Caddy        *CaddyConfig `yaml:"caddy"`
 	Nginx        *NginxConfig `yaml:"nginx"`
+	Hooks        *HooksConfig `yaml:"hooks"`
+	HealthCheck  *HealthCheck `yaml:"health_check"`
+	Timeouts     *Timeouts    `yaml:"timeouts"`
+	Rollout      *Rollout     `yaml:"rollout"`
+	BlueGreen    *BlueGreen   `yaml:"blue_green"`
+}
+
+// HostList returns the hosts the project runs on, Hosts if set and Host otherwise.
+func (p Project) HostList() []string {
+	if len(p.Hosts) > 0 {
+		return p.Hosts
+	}
+	return []string{p.Host}
+}
+
+// LocalHost is the host of projects on the machine goploy runs on, their commands run without SSH.
+const LocalHost = "local"
+
+// IsLocal reports whether the project targets the machine goploy runs on, see LocalHost.
+func (p Project) IsLocal() bool {
+	return p.Host == LocalHost
+}
+
+// OnHost returns a copy of the project that only targets host.
+func (p Project) OnHost(host string) Project {
+	p.Host = host
+	p.Hosts = nil
+	return p
+}
+
+// Rollout controls how deployments of multi-host projects proceed. Hosts are deployed in the
+// order they are listed, Parallel at a time (default 1), and the rollout halts on the first failure.
+type Rollout struct {
+	Parallel int `yaml:"parallel"`
+}
+
+// BlueGreen deploys the project as two alternating compose projects (colors) instead of updating it in place.
+// The new version is brought up next to the live one with PortVariable set to the port of its color,
+// health-checked, and the Caddy route is switched to it before the previous color is torn down after Drain.
+// It requires a single host and a caddy section.
+type BlueGreen struct {
+	BluePort     int           `yaml:"blue_port"`
+	GreenPort    int           `yaml:"green_port"`
+	PortVariable string        `yaml:"port_variable"` // defaults to GOPLOY_PORT
+	UpstreamHost string        `yaml:"upstream_host"` // defaults to the host of caddy.upstream
+	Drain        time.Duration `yaml:"drain"`         // defaults to 30s
+}
+
+// Timeouts bounds how long deployments may run, zero means no limit.
+// Deploy covers a whole deployment or rollback, Step every single remote command (including hooks).
+type Timeouts struct {
+	Deploy time.Duration `yaml:"deploy"`
+	Step   time.Duration `yaml:"step"`
+}
+
+// JumpHost is a bastion the connection to the project host is tunneled through,
+// like OpenSSH's ProxyJump. Host may also be given as "user@host:port".
+type JumpHost struct {
+	Host         string `yaml:"host"`
+	User         string `yaml:"user"`
+	Port         string `yaml:"port"`
+	IdentityFile string `yaml:"identity_file"`
+}
+
+// UnmarshalYAML allows jump hosts to be written as a plain "user@host:port" string.
+func (j *JumpHost) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		j.Host = value.Value
+		return nil
+	}
+
+	type plain JumpHost
+	return value.Decode((*plain)(j))
+}
+
+const (
+	// StrategyGit updates a git checkout on the host and brings the containers up from it, the default.
+	StrategyGit = "git"
+	// StrategyImage only updates the image tag the compose file refers to, the host needs no source or git access.
+	StrategyImage = "image"
+	// StrategyUpload uploads a local directory as a new release next to the previous ones and switches
+	// the `current` symlink in the project path to it.
+	StrategyUpload = "upload"
+	// StrategySwarm updates a git checkout on a Swarm manager and deploys its compose files as a stack
+	// (named like the compose project) with `docker stack deploy`, forwarding the registry credentials to the nodes.
+	// Only compose.files are passed on, variables are substituted from the environment of the host.
+	StrategySwarm = "swarm"
+)
+
+// Upload configures the upload strategy. Path is the local directory uploaded on every deployment,
+// files matching its .goployignore are left out. Keep old releases are retained for rollbacks (default 5).
+type Upload struct {
+	Path string `yaml:"path"`
+	Keep int    `yaml:"keep"`
+}
+
+const (
+	// GitTransportFetch lets the host fetch from its git remotes, the default.
+	GitTransportFetch = "fetch"
+	// GitTransportBundle bundles the commits the host is missing from a local repository and
+	// pushes them over SSH, for hosts without access to the repository.
+	GitTransportBundle = "bundle"
+)
+
+// GitConfig configures how the source of git projects reaches the host. LocalPath is the
+// repository bundles are created from, it is fetched before every deployment.
+type GitConfig struct {
+	Transport string `yaml:"transport"`
+	LocalPath string `yaml:"local_path"`
+}
+
+// Clone configures how Repo is cloned when a project is initialized on a host: at Branch
+// (the repository's default branch if empty) and with the last Depth commits only (full history if 0).
+type Clone struct {
+	Branch string `yaml:"branch"`
+	Depth  int    `yaml:"depth"`
+}
+
+const (
+	// HostKeyStrict only accepts hosts listed in known_hosts, the default.
+	HostKeyStrict = "strict"
+	// HostKeyTOFU trusts the key of a host on first use and adds it to known_hosts,
+	// the TUI asks for confirmation first. Changed keys are rejected like with strict.
+	HostKeyTOFU = "tofu"
+	// HostKeyPinned only accepts the key with the configured fingerprint, known_hosts is not used.
+	HostKeyPinned = "pinned"
+)
+
+// HostKey configures how the SSH host keys of a project are verified. Fingerprint is the
+// SHA256 fingerprint (as printed by `ssh-keygen -lf`) of pinned projects.
+type HostKey struct {
+	Policy      string `yaml:"policy"`
+	Fingerprint string `yaml:"fingerprint"`
+}
+
+func (h *HostKey) validate() error {
+	if h == nil {
+		return nil
+	}
+
+	switch h.Policy {
+	case "", HostKeyStrict, HostKeyTOFU:
+		return nil
+	case HostKeyPinned:
+		if h.Fingerprint == "" {
+			return errors.New("the pinned host key policy requires host_key.fingerprint")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown host key policy %q", h.Policy)
+	}
+}
+
+// ImageConfig configures the image strategy. The deployed tag is written as TagVariable (default GOPLOY_IMAGE_TAG)
+// into the compose env file (compose.env_file, .env otherwise), e.g. for `image: registry/app:${GOPLOY_IMAGE_TAG}`.
+type ImageConfig struct {
+	TagVariable string `yaml:"tag_variable"`
+}
+
+// LocalBuild builds the images on the machine running goploy instead of the host. The images are
+// streamed to the host over SSH (`docker save` into `docker load`) and brought up without --build.
+// Path is the local directory of the compose project, it has to be at the revision being deployed.
+type LocalBuild struct {
+	Path string `yaml:"path"`
+}
+
+// Compose selects what `docker compose` operates on, all remote compose commands of the project use it.
+// Files are passed as -f in order (later files override earlier ones), relative paths resolve against the project path.
+// Runtime is the compose CLI of the hosts, detected on each host if empty.
+type Compose struct {
+	Files       []string `yaml:"files"`
+	Profiles    []string `yaml:"profiles"`
+	EnvFile     string   `yaml:"env_file"`
+	ProjectName string   `yaml:"project_name"`
+	Runtime     string   `yaml:"runtime"`
+}
+
+const (
+	// RuntimeDocker runs `docker compose`, the compose v2 plugin of docker.
+	RuntimeDocker = "docker"
+	// RuntimeDockerCompose runs the standalone docker-compose v1 binary.
+	RuntimeDockerCompose = "docker-compose"
+	// RuntimePodman runs `podman compose`, with whatever compose provider podman is set up with.
+	RuntimePodman = "podman"
+)
+
+func (c *Compose) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	switch c.Runtime {
+	case "", RuntimeDocker, RuntimeDockerCompose, RuntimePodman:
+		return nil
+	default:
+		return fmt.Errorf("unknown compose runtime %q", c.Runtime)
+	}
+}
+
+// HealthCheck gates deployments on the project becoming healthy after `docker compose up -d`.
+// Containers must be running (or have exited with 0) and pass their Docker healthchecks,
+// an optional URL is probed from goploy and must answer with a status below 400.
+type HealthCheck struct {
+	URL      string        `yaml:"url"`
+	Timeout  time.Duration `yaml:"timeout"`  // defaults to 2m
+	Interval time.Duration `yaml:"interval"` // defaults to 5s
+}
+
+// HooksConfig holds the steps run around the deployment command chain.
+// Pre-deploy hooks run after the source and images are updated but before containers are touched,
+// post-deploy hooks run once the containers are up.
+type HooksConfig struct {
+	PreDeploy  []HookStep `yaml:"pre_deploy"`
+	PostDeploy []HookStep `yaml:"post_deploy"`
+}
+
+// HookStep is either a plain shell command (given as a YAML string) or, if Service is set,
+// a command run in a one-off container via `docker compose run --rm <service> <run>`.
+type HookStep struct {
+	Run     string `yaml:"run"`
+	Service string `yaml:"service"`
+}
+
+// UnmarshalYAML allows hook steps to be written as a plain string.
+func (h *HookStep) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		h.Run = value.Value
+		return nil
+	}
+
+	type plain HookStep
+	return value.Decode((*plain)(h))
 }
 
 type CaddyConfig struct {
@@ -41,12 +292,51 @@ type NginxConfig struct {
 	Domains          []string `yaml:"domains"`
 }
 
+// Slug reduces a project name to lowercase letters, digits and dashes, e.g. for Caddy route IDs
+// and compose project names. It returns "project" if nothing is left.
+func Slug(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		case r == ' ' || r == '_' || r == '-':
+			b.WriteRune('-')
+		}
+	}
+
+	cleaned := strings.Trim(b.String(), "-")
+	if cleaned == "" {
+		cleaned = "project"
+	}
+	return cleaned
+}
+
 // ParseGoployConfig parses the provided YAML data into a GoployConfig struct.
 func ParseGoployConfig(data []byte) (*GoployConfig, error) {
 	var config GoployConfig
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, err
 	}
+
+	if config.HostKey != nil {
+		for i := range config.Projects {
+			if config.Projects[i].HostKey == nil {
+				hostKey := *config.HostKey
+				config.Projects[i].HostKey = &hostKey
+			}
+		}
+	}
+
+	for _, p := range config.Projects {
+		if err := p.HostKey.validate(); err != nil {
+			return nil, fmt.Errorf("project %s: %w", p.Name, err)
+		}
+		if err := p.Compose.validate(); err != nil {
+			return nil, fmt.Errorf("project %s: %w", p.Name, err)
+		}
+	}
+
 	return &config, nil
 }
 
@@ -2,6 +2,7 @@ package config_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/pmaojo/goploy/internal/config"
 	"github.com/stretchr/testify/assert"
@@ -9,7 +10,7 @@ import (
 )
 
 func TestParseGoployConfig_Valid(t *testing.T) {
-        yamlData := []byte(`
+	yamlData := []byte(`
 projects:
   - name: "Project Alpha"
     host: "ssh://user@192.168.1.100:22"
@@ -32,21 +33,21 @@ projects:
 	require.NotNil(t, cfg)
 	require.Len(t, cfg.Projects, 2)
 
-        assert.Equal(t, "Project Alpha", cfg.Projects[0].Name)
-        assert.Equal(t, "ssh://user@192.168.1.100:22", cfg.Projects[0].Host)
-        assert.Equal(t, "/var/www/alpha", cfg.Projects[0].Path)
-        assert.Equal(t, "https://github.com/user/alpha.git", cfg.Projects[0].Repo)
-        require.NotNil(t, cfg.Projects[0].Caddy)
-        assert.Equal(t, "http://localhost:2019", cfg.Projects[0].Caddy.AdminURL)
-        assert.Equal(t, "srv0", cfg.Projects[0].Caddy.Server)
-        assert.Equal(t, "localhost:3000", cfg.Projects[0].Caddy.Upstream)
-        assert.Equal(t, "ops@example.com", cfg.Projects[0].Caddy.Email)
-        assert.Equal(t, []string{"alpha.example.com"}, cfg.Projects[0].Caddy.Domains)
+	assert.Equal(t, "Project Alpha", cfg.Projects[0].Name)
+	assert.Equal(t, "ssh://user@192.168.1.100:22", cfg.Projects[0].Host)
+	assert.Equal(t, "/var/www/alpha", cfg.Projects[0].Path)
+	assert.Equal(t, "https://github.com/user/alpha.git", cfg.Projects[0].Repo)
+	require.NotNil(t, cfg.Projects[0].Caddy)
+	assert.Equal(t, "http://localhost:2019", cfg.Projects[0].Caddy.AdminURL)
+	assert.Equal(t, "srv0", cfg.Projects[0].Caddy.Server)
+	assert.Equal(t, "localhost:3000", cfg.Projects[0].Caddy.Upstream)
+	assert.Equal(t, "ops@example.com", cfg.Projects[0].Caddy.Email)
+	assert.Equal(t, []string{"alpha.example.com"}, cfg.Projects[0].Caddy.Domains)
 
-        assert.Equal(t, "Project Beta", cfg.Projects[1].Name)
-        assert.Equal(t, "ssh://admin@10.0.0.5", cfg.Projects[1].Host)
-        assert.Equal(t, "/opt/beta", cfg.Projects[1].Path)
-        assert.Empty(t, cfg.Projects[1].Repo) // Optional field check if omitted in yaml (though I didn't omit it in struct definition yet, assuming flexible)
+	assert.Equal(t, "Project Beta", cfg.Projects[1].Name)
+	assert.Equal(t, "ssh://admin@10.0.0.5", cfg.Projects[1].Host)
+	assert.Equal(t, "/opt/beta", cfg.Projects[1].Path)
+	assert.Empty(t, cfg.Projects[1].Repo) // Optional field check if omitted in yaml (though I didn't omit it in struct definition yet, assuming flexible)
 }
 
 func TestParseGoployConfig_InvalidYAML(t *testing.T) {
@@ -59,3 +60,314 @@ projects:
 	_, err := config.ParseGoployConfig(yamlData)
 	assert.Error(t, err)
 }
+
+func TestParseGoployConfig_Hooks(t *testing.T) {
+	yamlData := []byte(`
+projects:
+  - name: "Project Alpha"
+    hooks:
+      pre_deploy:
+        - "./scripts/build-assets.sh"
+        - service: app
+          run: "php artisan migrate --force"
+      post_deploy:
+        - service: app
+          run: "php artisan cache:warm"
+`)
+
+	cfg, err := config.ParseGoployConfig(yamlData)
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Projects[0].Hooks)
+
+	hooks := cfg.Projects[0].Hooks
+	assert.Equal(t, []config.HookStep{
+		{Run: "./scripts/build-assets.sh"},
+		{Service: "app", Run: "php artisan migrate --force"},
+	}, hooks.PreDeploy)
+	assert.Equal(t, []config.HookStep{
+		{Service: "app", Run: "php artisan cache:warm"},
+	}, hooks.PostDeploy)
+}
+
+func TestParseGoployConfig_HealthCheck(t *testing.T) {
+	yamlData := []byte(`
+projects:
+  - name: "Project Alpha"
+    health_check:
+      url: "https://alpha.example.com/healthz"
+      timeout: 90s
+`)
+
+	cfg, err := config.ParseGoployConfig(yamlData)
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Projects[0].HealthCheck)
+	assert.Equal(t, "https://alpha.example.com/healthz", cfg.Projects[0].HealthCheck.URL)
+	assert.Equal(t, 90*time.Second, cfg.Projects[0].HealthCheck.Timeout)
+	assert.Zero(t, cfg.Projects[0].HealthCheck.Interval)
+}
+
+func TestParseGoployConfig_ProxyJump(t *testing.T) {
+	yamlData := []byte(`
+projects:
+  - name: "Project Alpha"
+    host: "10.0.1.20"
+    proxy_jump:
+      - "ops@bastion.example.com:2222"
+      - host: "10.0.0.5"
+        user: "jump"
+        identity_file: "~/.ssh/jump_key"
+`)
+
+	cfg, err := config.ParseGoployConfig(yamlData)
+	require.NoError(t, err)
+	require.Len(t, cfg.Projects[0].ProxyJump, 2)
+	assert.Equal(t, config.JumpHost{Host: "ops@bastion.example.com:2222"}, cfg.Projects[0].ProxyJump[0])
+	assert.Equal(t, config.JumpHost{Host: "10.0.0.5", User: "jump", IdentityFile: "~/.ssh/jump_key"}, cfg.Projects[0].ProxyJump[1])
+}
+
+func TestParseGoployConfig_Timeouts(t *testing.T) {
+	yamlData := []byte(`
+projects:
+  - name: "Project Alpha"
+    timeouts:
+      deploy: 15m
+      step: 5m
+`)
+
+	cfg, err := config.ParseGoployConfig(yamlData)
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Projects[0].Timeouts)
+	assert.Equal(t, 15*time.Minute, cfg.Projects[0].Timeouts.Deploy)
+	assert.Equal(t, 5*time.Minute, cfg.Projects[0].Timeouts.Step)
+}
+
+func TestParseGoployConfig_Hosts(t *testing.T) {
+	yamlData := []byte(`
+projects:
+  - name: "Project Alpha"
+    hosts:
+      - "deploy@web1.example.com"
+      - "deploy@web2.example.com"
+    rollout:
+      parallel: 2
+  - name: "Project Beta"
+    host: "beta.example.com"
+`)
+
+	cfg, err := config.ParseGoployConfig(yamlData)
+	require.NoError(t, err)
+
+	alpha := cfg.Projects[0]
+	assert.Equal(t, []string{"deploy@web1.example.com", "deploy@web2.example.com"}, alpha.HostList())
+	require.NotNil(t, alpha.Rollout)
+	assert.Equal(t, 2, alpha.Rollout.Parallel)
+
+	web2 := alpha.OnHost("deploy@web2.example.com")
+	assert.Equal(t, "deploy@web2.example.com", web2.Host)
+	assert.Equal(t, []string{"deploy@web2.example.com"}, web2.HostList())
+	assert.Len(t, alpha.Hosts, 2)
+
+	assert.Equal(t, []string{"beta.example.com"}, cfg.Projects[1].HostList())
+}
+
+func TestParseGoployConfig_BlueGreen(t *testing.T) {
+	yamlData := []byte(`
+projects:
+  - name: "Project Alpha"
+    host: "alpha.example.com"
+    blue_green:
+      blue_port: 8001
+      green_port: 8002
+      drain: 45s
+`)
+
+	cfg, err := config.ParseGoployConfig(yamlData)
+	require.NoError(t, err)
+
+	bg := cfg.Projects[0].BlueGreen
+	require.NotNil(t, bg)
+	assert.Equal(t, 8001, bg.BluePort)
+	assert.Equal(t, 8002, bg.GreenPort)
+	assert.Equal(t, 45*time.Second, bg.Drain)
+	assert.Empty(t, bg.PortVariable)
+}
+
+func TestSlug(t *testing.T) {
+	assert.Equal(t, "project-alpha", config.Slug("Project Alpha"))
+	assert.Equal(t, "my-api", config.Slug("-My_API!-"))
+	assert.Equal(t, "project", config.Slug("!!!"))
+}
+
+func TestParseGoployConfig_Compose(t *testing.T) {
+	yamlData := []byte(`
+projects:
+  - name: "Project Alpha"
+    host: "alpha.example.com"
+    compose:
+      files:
+        - docker-compose.yml
+        - docker-compose.prod.yml
+      profiles: [web]
+      env_file: .env.production
+      project_name: alpha
+      runtime: podman
+`)
+
+	cfg, err := config.ParseGoployConfig(yamlData)
+	require.NoError(t, err)
+
+	compose := cfg.Projects[0].Compose
+	require.NotNil(t, compose)
+	assert.Equal(t, []string{"docker-compose.yml", "docker-compose.prod.yml"}, compose.Files)
+	assert.Equal(t, []string{"web"}, compose.Profiles)
+	assert.Equal(t, ".env.production", compose.EnvFile)
+	assert.Equal(t, "alpha", compose.ProjectName)
+	assert.Equal(t, config.RuntimePodman, compose.Runtime)
+
+	_, err = config.ParseGoployConfig([]byte(`
+projects:
+  - name: "Project Alpha"
+    host: "alpha.example.com"
+    compose:
+      runtime: nerdctl
+`))
+	assert.EqualError(t, err, `project Project Alpha: unknown compose runtime "nerdctl"`)
+}
+
+func TestParseGoployConfig_ImageStrategy(t *testing.T) {
+	yamlData := []byte(`
+projects:
+  - name: "Project Alpha"
+    host: "alpha.example.com"
+    strategy: image
+    image:
+      tag_variable: ALPHA_TAG
+`)
+
+	cfg, err := config.ParseGoployConfig(yamlData)
+	require.NoError(t, err)
+
+	alpha := cfg.Projects[0]
+	assert.Equal(t, config.StrategyImage, alpha.Strategy)
+	require.NotNil(t, alpha.Image)
+	assert.Equal(t, "ALPHA_TAG", alpha.Image.TagVariable)
+}
+
+func TestParseGoployConfig_LocalBuild(t *testing.T) {
+	yamlData := []byte(`
+projects:
+  - name: "Project Alpha"
+    host: "alpha.example.com"
+    local_build:
+      path: /home/dev/alpha
+`)
+
+	cfg, err := config.ParseGoployConfig(yamlData)
+	require.NoError(t, err)
+
+	require.NotNil(t, cfg.Projects[0].LocalBuild)
+	assert.Equal(t, "/home/dev/alpha", cfg.Projects[0].LocalBuild.Path)
+}
+
+func TestParseGoployConfig_Upload(t *testing.T) {
+	yamlData := []byte(`
+projects:
+  - name: "Project Alpha"
+    host: "alpha.example.com"
+    strategy: upload
+    upload:
+      path: ./dist
+      keep: 3
+`)
+
+	cfg, err := config.ParseGoployConfig(yamlData)
+	require.NoError(t, err)
+
+	alpha := cfg.Projects[0]
+	assert.Equal(t, config.StrategyUpload, alpha.Strategy)
+	require.NotNil(t, alpha.Upload)
+	assert.Equal(t, "./dist", alpha.Upload.Path)
+	assert.Equal(t, 3, alpha.Upload.Keep)
+}
+
+func TestParseGoployConfig_GitBundle(t *testing.T) {
+	yamlData := []byte(`
+projects:
+  - name: "Project Alpha"
+    host: "alpha.example.com"
+    git:
+      transport: bundle
+      local_path: /home/dev/alpha
+`)
+
+	cfg, err := config.ParseGoployConfig(yamlData)
+	require.NoError(t, err)
+
+	alpha := cfg.Projects[0]
+	require.NotNil(t, alpha.Git)
+	assert.Equal(t, config.GitTransportBundle, alpha.Git.Transport)
+	assert.Equal(t, "/home/dev/alpha", alpha.Git.LocalPath)
+}
+
+func TestParseGoployConfig_Clone(t *testing.T) {
+	yamlData := []byte(`
+projects:
+  - name: "Project Alpha"
+    host: "alpha.example.com"
+    repo: "git@github.com:acme/alpha.git"
+    clone:
+      branch: release
+      depth: 1
+`)
+
+	cfg, err := config.ParseGoployConfig(yamlData)
+	require.NoError(t, err)
+
+	alpha := cfg.Projects[0]
+	assert.Equal(t, "git@github.com:acme/alpha.git", alpha.Repo)
+	require.NotNil(t, alpha.Clone)
+	assert.Equal(t, "release", alpha.Clone.Branch)
+	assert.Equal(t, 1, alpha.Clone.Depth)
+}
+
+func TestParseGoployConfig_HostKey(t *testing.T) {
+	yamlData := []byte(`
+host_key:
+  policy: tofu
+projects:
+  - name: "Project Alpha"
+    host: "alpha.example.com"
+  - name: "Project Beta"
+    host: "beta.example.com"
+    host_key:
+      policy: pinned
+      fingerprint: "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"
+`)
+
+	cfg, err := config.ParseGoployConfig(yamlData)
+	require.NoError(t, err)
+
+	require.NotNil(t, cfg.Projects[0].HostKey)
+	assert.Equal(t, config.HostKeyTOFU, cfg.Projects[0].HostKey.Policy)
+	require.NotNil(t, cfg.Projects[1].HostKey)
+	assert.Equal(t, config.HostKeyPinned, cfg.Projects[1].HostKey.Policy)
+	assert.Equal(t, "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8", cfg.Projects[1].HostKey.Fingerprint)
+
+	_, err = config.ParseGoployConfig([]byte(`
+projects:
+  - name: "Project Alpha"
+    host: "alpha.example.com"
+    host_key:
+      policy: pinned
+`))
+	assert.EqualError(t, err, "project Project Alpha: the pinned host key policy requires host_key.fingerprint")
+
+	_, err = config.ParseGoployConfig([]byte(`
+host_key:
+  policy: sometimes
+projects:
+  - name: "Project Alpha"
+    host: "alpha.example.com"
+`))
+	assert.EqualError(t, err, `project Project Alpha: unknown host key policy "sometimes"`)
+}
@@ -0,0 +1,263 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/shell"
+)
+
+const (
+	colorBlue  = "blue"
+	colorGreen = "green"
+
+	// colorFile records the live color in the project path.
+	colorFile = ".goploy.color"
+
+	defaultPortVariable = "GOPLOY_PORT"
+	defaultDrain        = 30 * time.Second
+
+	switchPhase = "switch"
+)
+
+// UpstreamSwitcher points the reverse proxy route of a project at another upstream, see proxy.CaddyClient.
+type UpstreamSwitcher interface {
+	SwitchUpstream(ctx context.Context, project config.Project, upstream string) error
+}
+
+// validateBlueGreen checks the preconditions of blue/green deployments, it accepts all other projects.
+func (c *SSHClient) validateBlueGreen(project config.Project) error {
+	bg := project.BlueGreen
+	switch {
+	case bg == nil:
+		return nil
+	case len(project.HostList()) > 1:
+		return errors.New("blue/green deployments support a single host only")
+	case uploadStrategy(project):
+		return errors.New("blue/green deployments do not support the upload strategy")
+	case project.Caddy == nil:
+		return errors.New("blue/green deployments require a caddy configuration")
+	case c.Upstreams == nil:
+		return errors.New("blue/green deployments require an upstream switcher")
+	case bg.BluePort <= 0 || bg.GreenPort <= 0 || bg.BluePort == bg.GreenPort:
+		return errors.New("blue/green deployments require distinct blue_port and green_port")
+	}
+	return nil
+}
+
+// deployBlueGreen brings the project up as the idle color next to the live one, switches the upstream
+// to it once it is healthy and tears the previous color down after the drain period. source updates the
+// checked out code, hooks run against the new color. Until the switch the live color keeps serving,
+// a failure discards the new color and restores the previous commit.
+func (c *SSHClient) deployBlueGreen(ctx context.Context, h hostConn, source []step, hooks *config.HooksConfig, events EventSink) error {
+	project := h.project
+	before := c.headCommit(ctx, h.client, project)
+
+	live, err := c.liveColor(ctx, h.client, project)
+	if err != nil {
+		return err
+	}
+	next := nextColor(live)
+	compose := colorCompose(project, next)
+
+	if live == "" {
+		emitMessage(events, "", "No live color, deploying %s on port %d.", next, colorPort(project, next))
+	} else {
+		emitMessage(events, "", "Live color is %s, deploying %s on port %d.", live, next, colorPort(project, next))
+	}
+
+	err = c.runSteps(ctx, h.client, project, append(source, composeSteps(project, hooks, compose, colorProjectName(project, next))...), events)
+
+	if err == nil {
+		if healthErr := c.waitHealthy(ctx, h.client, colorHealthProject(project), compose, events); healthErr != nil {
+			err = healthErr
+			if ctx.Err() == nil {
+				err = &HealthCheckError{Reason: healthErr.Error(), RolledBackTo: before}
+			}
+		}
+	}
+
+	if err == nil {
+		err = c.switchColor(ctx, h.client, project, live, next, events)
+	}
+
+	if err != nil {
+		discardErr := c.discardColor(ctx, h.client, project, next, before, events)
+		var healthErr *HealthCheckError
+		if errors.As(err, &healthErr) {
+			healthErr.RollbackErr = discardErr
+		}
+		return err
+	}
+
+	if hooks != nil && len(hooks.PostDeploy) > 0 {
+		if err := c.runSteps(ctx, h.client, project, hookSteps("post_deploy", hooks.PostDeploy, compose), events); err != nil {
+			return err
+		}
+	}
+
+	return c.retireColor(ctx, h.client, project, live, events)
+}
+
+// switchColor routes traffic to the next color and records it as live.
+// The route is switched back if the color can't be recorded.
+func (c *SSHClient) switchColor(ctx context.Context, client executor, project config.Project, live, next string, events EventSink) error {
+	upstream := colorUpstream(project, next)
+
+	start := time.Now()
+	events.Emit(Event{Type: EventPhaseStarted, Time: start, Phase: switchPhase, Text: fmt.Sprintf("routing %s to %s (%s)", project.Name, next, upstream)})
+	err := c.Upstreams.SwitchUpstream(ctx, project, upstream)
+	events.Emit(Event{Type: EventPhaseFinished, Time: time.Now(), Phase: switchPhase, Outcome: newOutcome(start, err)})
+	if err != nil {
+		return fmt.Errorf("failed to switch upstream: %w", err)
+	}
+
+	record := []step{{name: "record color", command: fmt.Sprintf("echo %s > %s", next, colorFile)}}
+	if err := c.runSteps(ctx, client, project, record, events); err != nil {
+		cleanupCtx, cancel := cleanupContext(ctx)
+		defer cancel()
+
+		previous := colorUpstream(project, live)
+		emitMessage(events, "", "Switching back to %s...", previous)
+		if switchErr := c.Upstreams.SwitchUpstream(cleanupCtx, project, previous); switchErr != nil {
+			return fmt.Errorf("%w (switching back failed: %v)", err, switchErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// discardColor tears down a color that never went live and restores the previous commit (on the checked out
+// branch, see versionStep) or image tag.
+// It runs on a cleanup context, the deployment may have been cancelled.
+func (c *SSHClient) discardColor(ctx context.Context, client executor, project config.Project, color, commit string, events EventSink) error {
+	ctx, cancel := cleanupContext(ctx)
+	defer cancel()
+
+	emitMessage(events, "", "Discarding %s, the live color keeps serving.", color)
+
+	steps := []step{{name: "discard down", command: colorCompose(project, color) + " down"}}
+	if commit != "" {
+		restore := versionStep(project, commit)
+		restore.name = "discard " + restore.name
+		steps = append(steps, restore)
+	}
+	return c.runSteps(ctx, client, project, steps, events)
+}
+
+// retireColor waits for the drain period and tears the previous color down. Projects deployed
+// in place before switching to blue/green have no live color, their default compose project is removed.
+func (c *SSHClient) retireColor(ctx context.Context, client executor, project config.Project, live string, events EventSink) error {
+	drain := project.BlueGreen.Drain
+	if drain <= 0 {
+		drain = defaultDrain
+	}
+
+	name := live
+	compose := colorCompose(project, live)
+	if live == "" {
+		name = "previous stack"
+		compose = inPlaceCompose(project)
+	}
+
+	emitMessage(events, "", "Draining %s for %s...", name, drain)
+	select {
+	case <-time.After(drain):
+	case <-ctx.Done():
+		return fmt.Errorf("drain interrupted, %s is still running: %w", name, ctx.Err())
+	}
+
+	return c.runSteps(ctx, client, project, []step{{name: "teardown", command: compose + " down"}}, events)
+}
+
+// liveColor reads the color currently serving the project, empty if there is none.
+func (c *SSHClient) liveColor(ctx context.Context, client executor, project config.Project) (string, error) {
+	var b strings.Builder
+	cmd := shell.InDir(project.Path, "cat "+colorFile+" 2>/dev/null || true")
+	if err := c.runSession(client, cmd, &b, io.Discard, ctx); err != nil {
+		return "", fmt.Errorf("failed to read live color: %w", err)
+	}
+
+	switch color := strings.TrimSpace(b.String()); color {
+	case colorBlue, colorGreen:
+		return color, nil
+	default:
+		return "", nil
+	}
+}
+
+// nextColor returns the color to deploy next to live, blue if there is no live color.
+func nextColor(live string) string {
+	if live == colorBlue {
+		return colorGreen
+	}
+	return colorBlue
+}
+
+func colorPort(project config.Project, color string) int {
+	if color == colorGreen {
+		return project.BlueGreen.GreenPort
+	}
+	return project.BlueGreen.BluePort
+}
+
+// colorProjectName is the compose project name of a color, compose.project_name (or goploy-<project>) suffixed with the color.
+func colorProjectName(project config.Project, color string) string {
+	return colorPrefix(project) + "-" + color
+}
+
+func colorPrefix(project config.Project) string {
+	if project.Compose != nil && project.Compose.ProjectName != "" {
+		return project.Compose.ProjectName
+	}
+	return "goploy-" + config.Slug(project.Name)
+}
+
+// colorCompose returns the docker compose command of a color, its port is passed in the port variable.
+func colorCompose(project config.Project, color string) string {
+	return fmt.Sprintf("%s=%d %s -p %s", portVariable(project), colorPort(project, color), composeBase(project), shell.Quote(colorProjectName(project, color)))
+}
+
+// portVariable is the variable the compose files of blue/green projects read the port of the color from.
+func portVariable(project config.Project) string {
+	if project.BlueGreen.PortVariable != "" {
+		return project.BlueGreen.PortVariable
+	}
+	return defaultPortVariable
+}
+
+// colorUpstream is the address the reverse proxy dials for a color, caddy.upstream if there is no live color.
+func colorUpstream(project config.Project, color string) string {
+	if color == "" {
+		return project.Caddy.Upstream
+	}
+
+	host := project.BlueGreen.UpstreamHost
+	if host == "" {
+		host = "localhost"
+		if h, _, err := net.SplitHostPort(project.Caddy.Upstream); err == nil && h != "" {
+			host = h
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(colorPort(project, color)))
+}
+
+// colorHealthProject returns the project as health-checked before the switch. Containers are always
+// checked, the URL is skipped: it is served by the live color until the switch.
+func colorHealthProject(project config.Project) config.Project {
+	check := config.HealthCheck{}
+	if project.HealthCheck != nil {
+		check = *project.HealthCheck
+	}
+	check.URL = ""
+	project.HealthCheck = &check
+	return project
+}
@@ -0,0 +1,174 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSwitcher struct {
+	mu        sync.Mutex
+	upstreams []string
+	err       error
+}
+
+func (s *fakeSwitcher) SwitchUpstream(_ context.Context, _ config.Project, upstream string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.upstreams = append(s.upstreams, upstream)
+	return s.err
+}
+
+func blueGreenProject() config.Project {
+	return config.Project{
+		Name:      "API",
+		Host:      "web1",
+		Path:      "/srv/api",
+		Caddy:     &config.CaddyConfig{AdminURL: "http://localhost:2019", Upstream: "localhost:3000"},
+		BlueGreen: &config.BlueGreen{BluePort: 8001, GreenPort: 8002, Drain: time.Millisecond},
+	}
+}
+
+// blueGreenHandler simulates a host whose live color is blue, containers report health.
+func blueGreenHandler(health string) testHandler {
+	return func(cmd string, _ io.Reader, stdout, _ io.Writer, _ <-chan string) int {
+		switch {
+		case strings.HasSuffix(cmd, "git rev-parse HEAD"):
+			io.WriteString(stdout, "abc123\n")
+		case strings.Contains(cmd, "cat "+colorFile):
+			io.WriteString(stdout, "blue\n")
+		case strings.HasSuffix(cmd, "ps -a --format json"):
+			io.WriteString(stdout, `[{"Name":"api-green-app-1","State":"running","Health":"`+health+`"}]`)
+		}
+		return 0
+	}
+}
+
+func TestDeployBlueGreen_SwitchesColor(t *testing.T) {
+	server := newTestSSHServer(t, blueGreenHandler("healthy"))
+	client := server.connect(t)
+
+	switcher := &fakeSwitcher{}
+	c := &SSHClient{Upstreams: switcher}
+	project := blueGreenProject()
+
+	err := c.deployBlueGreen(context.Background(), hostConn{project: project, client: client}, sourceSteps(project, ""), nil, &recordingSink{})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"localhost:8002"}, switcher.upstreams)
+
+	executed := strings.Join(server.executed(), "\n")
+	assert.Contains(t, executed, "GOPLOY_PORT=8002 docker compose -p 'goploy-api-green' up -d --build")
+	assert.Contains(t, executed, "GOPLOY_PORT=8002 docker compose -p 'goploy-api-green' ps -a --format json")
+	assert.Contains(t, executed, "echo green > "+colorFile)
+	assert.Contains(t, executed, "GOPLOY_PORT=8001 docker compose -p 'goploy-api-blue' down")
+	assert.NotContains(t, executed, "goploy-api-green' down")
+}
+
+func TestDeployBlueGreen_UnhealthyKeepsLiveColor(t *testing.T) {
+	server := newTestSSHServer(t, blueGreenHandler("unhealthy"))
+	client := server.connect(t)
+
+	switcher := &fakeSwitcher{}
+	c := &SSHClient{Upstreams: switcher}
+	project := blueGreenProject()
+
+	err := c.deployBlueGreen(context.Background(), hostConn{project: project, client: client}, sourceSteps(project, ""), nil, &recordingSink{})
+
+	var healthErr *HealthCheckError
+	require.ErrorAs(t, err, &healthErr)
+	assert.True(t, healthErr.rolledBack())
+	assert.Empty(t, switcher.upstreams)
+
+	executed := strings.Join(server.executed(), "\n")
+	assert.Contains(t, executed, "GOPLOY_PORT=8002 docker compose -p 'goploy-api-green' down")
+	// The previous commit is restored on the branch, later deployments of the branch pull it again.
+	assert.Contains(t, executed, `if branch=$(git symbolic-ref --short -q HEAD); then git checkout -B "$branch" 'abc123'; else git checkout 'abc123'; fi`)
+	assert.NotContains(t, executed, "goploy-api-blue' down")
+	assert.NotContains(t, executed, "> "+colorFile)
+}
+
+func TestDeployBlueGreen_SwitchFailure(t *testing.T) {
+	server := newTestSSHServer(t, blueGreenHandler("healthy"))
+	client := server.connect(t)
+
+	c := &SSHClient{Upstreams: &fakeSwitcher{err: errors.New("caddy route goploy-api not found")}}
+	project := blueGreenProject()
+
+	err := c.deployBlueGreen(context.Background(), hostConn{project: project, client: client}, sourceSteps(project, ""), nil, &recordingSink{})
+	assert.EqualError(t, err, "failed to switch upstream: caddy route goploy-api not found")
+
+	executed := strings.Join(server.executed(), "\n")
+	assert.Contains(t, executed, "goploy-api-green' down")
+	assert.NotContains(t, executed, "goploy-api-blue' down")
+}
+
+func TestBlueGreenCommands(t *testing.T) {
+	project := blueGreenProject()
+
+	assert.Equal(t, colorBlue, nextColor(""))
+	assert.Equal(t, colorGreen, nextColor(colorBlue))
+	assert.Equal(t, colorBlue, nextColor(colorGreen))
+
+	assert.Equal(t, "localhost:3000", colorUpstream(project, ""))
+	assert.Equal(t, "localhost:8001", colorUpstream(project, colorBlue))
+	project.BlueGreen.UpstreamHost = "app.internal"
+	assert.Equal(t, "app.internal:8002", colorUpstream(project, colorGreen))
+
+	project.BlueGreen.PortVariable = "APP_PORT"
+	assert.Equal(t, "APP_PORT=8001 docker compose -p 'goploy-api-blue'", colorCompose(project, colorBlue))
+
+	assert.Equal(t, "APP_PORT=$(case $(cat .goploy.color 2>/dev/null) in (blue) echo 8001 ;; (green) echo 8002 ;; esac) "+
+		"docker compose $(test -f .goploy.color && echo -p 'goploy-api'-$(cat .goploy.color))", composeCommand(project))
+	assert.Equal(t, "docker compose", composeCommand(config.Project{Name: "API"}))
+}
+
+func TestBlueGreen_LiveCommandsCarryPort(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	server := newTestSSHServer(t, func(string, io.Reader, io.Writer, io.Writer, <-chan string) int { return 0 })
+	host, port, _ := net.SplitHostPort(server.addr)
+	project := blueGreenProject()
+	project.Host, project.Port, project.User = host, port, "test"
+	project.HostKey = &config.HostKey{Policy: config.HostKeyTOFU}
+	project.Compose = &config.Compose{Runtime: config.RuntimeDocker}
+
+	c := NewSSHClient(nil)
+	defer c.Close()
+
+	require.NoError(t, c.Restart(context.Background(), project, io.Discard))
+	require.NoError(t, c.Stop(context.Background(), project, io.Discard))
+
+	live := "GOPLOY_PORT=$(case $(cat .goploy.color 2>/dev/null) in (blue) echo 8001 ;; (green) echo 8002 ;; esac) " +
+		"docker compose $(test -f .goploy.color && echo -p 'goploy-api'-$(cat .goploy.color))"
+	executed := strings.Join(server.executed(), "\n")
+	assert.Contains(t, executed, live+" restart")
+	assert.Contains(t, executed, live+" stop")
+}
+
+func TestValidateBlueGreen(t *testing.T) {
+	c := &SSHClient{Upstreams: &fakeSwitcher{}}
+	assert.NoError(t, c.validateBlueGreen(blueGreenProject()))
+	assert.NoError(t, c.validateBlueGreen(config.Project{Hosts: []string{"web1", "web2"}}))
+
+	multiHost := blueGreenProject()
+	multiHost.Hosts = []string{"web1", "web2"}
+	assert.EqualError(t, c.validateBlueGreen(multiHost), "blue/green deployments support a single host only")
+
+	samePort := blueGreenProject()
+	samePort.BlueGreen.GreenPort = samePort.BlueGreen.BluePort
+	assert.EqualError(t, c.validateBlueGreen(samePort), "blue/green deployments require distinct blue_port and green_port")
+
+	assert.EqualError(t, (&SSHClient{}).validateBlueGreen(blueGreenProject()), "blue/green deployments require an upstream switcher")
+}
@@ -0,0 +1,160 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/shell"
+)
+
+const (
+	initPhase = "init"
+
+	// cloneDir is cloned into next to the lock dir, the project path is not empty once it is locked.
+	cloneDir = ".goploy.clone"
+)
+
+// prerequisite is a tool the host needs, check exits non-zero if it is missing.
+type prerequisite struct {
+	name  string
+	check string
+}
+
+// Init prepares the project on its hosts: it checks the prerequisites, creates the project path and
+// clones the repository (see config.Clone) unless it has been cloned already. Bundle projects get an
+// empty repository, upload projects the path only. It is rolled out, reports progress and is bounded
+// by timeouts like Deploy, which initializes hosts on its own if their project path is missing: nothing is
+// emitted before the project lock has been acquired, the last event after it is an EventResult.
+func (c *SSHClient) Init(ctx context.Context, project config.Project, events EventSink) error {
+	if err := validateStrategy(project, ""); err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	ctx, cancel, wrapTimeout := withTimeout(ctx, deployTimeout(project), initPhase)
+	defer cancel()
+
+	hosts, release, err := c.connectHosts(ctx, project)
+	if err != nil {
+		return wrapTimeout(fmt.Errorf("connection failed: %w", err))
+	}
+	defer release()
+
+	unlock, _, err := c.lockCreatingPaths(ctx, hosts, initPhase)
+	if err != nil {
+		return wrapTimeout(err)
+	}
+	defer unlock()
+
+	emitMessage(events, "", "Connected to %s, project lock acquired.", hostNames(hosts))
+
+	err = c.rollout(project, hosts, events, func(h hostConn, events EventSink) error {
+		return c.bootstrapHost(ctx, h, events)
+	})
+	err = wrapTimeout(err)
+
+	events.Emit(Event{Type: EventResult, Time: time.Now(), Phase: initPhase, Outcome: newOutcome(start, err)})
+
+	return err
+}
+
+// bootstrapHost checks the prerequisites of a single host and sets up the project source in its path.
+func (c *SSHClient) bootstrapHost(ctx context.Context, h hostConn, events EventSink) error {
+	source, err := bootstrapStep(h.project)
+	if err != nil {
+		return err
+	}
+
+	steps := []step{{
+		name:    "prerequisites",
+		command: prerequisitesCommand(h.project),
+		local: func(ctx context.Context, c *SSHClient, client executor, events EventSink) error {
+			return c.checkPrerequisites(ctx, client, h.project)
+		},
+	}}
+	if source != nil {
+		steps = append(steps, *source)
+	}
+	return c.runSteps(ctx, h.client, h.project, steps, events)
+}
+
+// bootstrapStep sets up the source in the project path, nil if there is nothing to set up.
+// Existing checkouts are left alone.
+func bootstrapStep(project config.Project) (*step, error) {
+	switch {
+	case uploadStrategy(project):
+		return nil, nil
+	case bundleTransport(project):
+		return &step{name: "git init", command: "test -e .git || git init --quiet", dir: project.Path}, nil
+	case project.Repo != "":
+		return &step{name: "clone", command: cloneCommand(project), dir: project.Path}, nil
+	case imageStrategy(project):
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("project %s has no repo to clone into %s", project.Name, project.Path)
+	}
+}
+
+// cloneCommand clones the repository into the project path unless it is a checkout already. The path holds the
+// lock dir, git refuses to clone into it: the repository is cloned next to it and its git directory moved in.
+func cloneCommand(project config.Project) string {
+	args := []string{"git clone --quiet"}
+	if project.Clone != nil && project.Clone.Branch != "" {
+		args = append(args, "--branch "+shell.Quote(project.Clone.Branch))
+	}
+	if project.Clone != nil && project.Clone.Depth > 0 {
+		args = append(args, fmt.Sprintf("--depth %d", project.Clone.Depth))
+	}
+	args = append(args, shell.Quote(project.Repo), cloneDir)
+
+	return fmt.Sprintf("test -e .git || { rm -rf %[1]s && %[2]s && mv %[1]s/.git .git && rm -rf %[1]s && git reset --hard --quiet; }",
+		cloneDir, strings.Join(args, " "))
+}
+
+// prerequisites are the tools the project needs on its hosts: the container CLI and compose of its runtime
+// (docker compose v2 unless another one is configured or detected) and git for git checkouts.
+func prerequisites(project config.Project) []prerequisite {
+	rt := runtimeOf(project)
+	list := []prerequisite{
+		{name: rt.engine, check: "command -v " + rt.engine},
+		{name: rt.name, check: rt.compose + " version"},
+	}
+	if bundleTransport(project) || project.Repo != "" || (!uploadStrategy(project) && !imageStrategy(project)) {
+		list = append(list, prerequisite{name: "git", check: "command -v git"})
+	}
+	return list
+}
+
+// prerequisitesCommand describes the checks of the prerequisites step.
+func prerequisitesCommand(project config.Project) string {
+	var checks []string
+	for _, p := range prerequisites(project) {
+		checks = append(checks, p.check)
+	}
+	return strings.Join(checks, " && ")
+}
+
+// checkPrerequisites reports all prerequisites missing on the host at once.
+func (c *SSHClient) checkPrerequisites(ctx context.Context, client executor, project config.Project) error {
+	var missing []string
+	for _, p := range prerequisites(project) {
+		err := c.runSession(client, p.check, io.Discard, io.Discard, ctx)
+		_, exited := exitStatus(err)
+		switch {
+		case exited:
+			missing = append(missing, p.name)
+		case err != nil:
+			return fmt.Errorf("failed to check %s: %w", p.name, err)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing prerequisites on %s: %s", project.Host, strings.Join(missing, ", "))
+	}
+	return nil
+}
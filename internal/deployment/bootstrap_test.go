@@ -0,0 +1,115 @@
+package deployment
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockCreatingPaths(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "api")
+	hosts := []hostConn{{project: config.Project{Name: "api", Host: config.LocalHost, Path: dir}, client: localExecutor{}}}
+
+	// The path is created under the lock, its holder bootstraps the host.
+	unlock, created, err := (&SSHClient{}).lockCreatingPaths(context.Background(), hosts, "deploy")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{config.LocalHost: true}, created)
+	assert.DirExists(t, filepath.Join(dir, remoteLockDir))
+	unlock()
+
+	unlock, created, err = (&SSHClient{}).lockCreatingPaths(context.Background(), hosts, "deploy")
+	require.NoError(t, err)
+	assert.Empty(t, created)
+	unlock()
+}
+
+func TestDeploy_CreatePathFailure(t *testing.T) {
+	// The project path can't be created below a file.
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+	project := config.Project{Name: "api", Host: config.LocalHost, Path: filepath.Join(file, "api"), Repo: "git@github.com:acme/api.git"}
+
+	c := NewSSHClient(nil)
+	defer c.Close()
+	c.History = history.NewStore(t.TempDir())
+
+	sink := &recordingSink{}
+	err := c.Deploy(context.Background(), project, sink, "")
+	require.ErrorContains(t, err, "failed to create "+project.Path)
+
+	// The lock is not held, the failure is only returned.
+	assert.Empty(t, sink.events)
+
+	deployments, listErr := c.History.List("api")
+	require.NoError(t, listErr)
+	require.Len(t, deployments, 1)
+	assert.Equal(t, history.StatusFailure, deployments[0].Status)
+	assert.Equal(t, err.Error(), deployments[0].Error)
+}
+
+func TestBootstrapHost_Clone(t *testing.T) {
+	server := newTestSSHServer(t, func(string, io.Reader, io.Writer, io.Writer, <-chan string) int { return 0 })
+	client := server.connect(t)
+
+	project := config.Project{
+		Name:  "api",
+		Host:  "web1",
+		Path:  "/srv/api",
+		Repo:  "git@github.com:acme/api.git",
+		Clone: &config.Clone{Branch: "main", Depth: 1},
+	}
+	sink := &recordingSink{}
+	require.NoError(t, (&SSHClient{}).bootstrapHost(context.Background(), hostConn{project: project, client: client}, sink))
+
+	executed := server.executed()
+	assert.Contains(t, executed, "command -v docker")
+	assert.Contains(t, executed, "docker compose version")
+	assert.Contains(t, executed, "command -v git")
+	assert.Contains(t, executed, `cd '/srv/api' && test -e .git || { rm -rf .goploy.clone && git clone --quiet --branch 'main' --depth 1 'git@github.com:acme/api.git' .goploy.clone && mv .goploy.clone/.git .git && rm -rf .goploy.clone && git reset --hard --quiet; }`)
+	assert.Len(t, sink.ofType(EventPhaseStarted), 2)
+}
+
+func TestBootstrapHost_MissingPrerequisites(t *testing.T) {
+	server := newTestSSHServer(t, func(cmd string, _ io.Reader, _, _ io.Writer, _ <-chan string) int {
+		if cmd == "docker compose version" || cmd == "command -v git" {
+			return 127
+		}
+		return 0
+	})
+	client := server.connect(t)
+
+	project := config.Project{Name: "api", Host: "web1", Path: "/srv/api", Repo: "https://example.com/api.git"}
+	err := (&SSHClient{}).bootstrapHost(context.Background(), hostConn{project: project, client: client}, &recordingSink{})
+	assert.EqualError(t, err, "step prerequisites failed: missing prerequisites on web1: docker compose v2, git")
+	assert.NotContains(t, strings.Join(server.executed(), "\n"), "git clone")
+}
+
+func TestBootstrapStep(t *testing.T) {
+	s, err := bootstrapStep(config.Project{Strategy: config.StrategyUpload})
+	require.NoError(t, err)
+	assert.Nil(t, s)
+
+	s, err = bootstrapStep(config.Project{Strategy: config.StrategyImage})
+	require.NoError(t, err)
+	assert.Nil(t, s)
+
+	s, err = bootstrapStep(config.Project{Path: "/srv/api", Git: &config.GitConfig{Transport: config.GitTransportBundle, LocalPath: "."}})
+	require.NoError(t, err)
+	assert.Equal(t, "test -e .git || git init --quiet", s.command)
+
+	assert.Equal(t, "test -e .git || { rm -rf .goploy.clone && git clone --quiet 'https://example.com/api.git' .goploy.clone && mv .goploy.clone/.git .git && rm -rf .goploy.clone && git reset --hard --quiet; }",
+		cloneCommand(config.Project{Repo: "https://example.com/api.git"}))
+
+	_, err = bootstrapStep(config.Project{Name: "api", Path: "/srv/api"})
+	assert.EqualError(t, err, "project api has no repo to clone into /srv/api")
+
+	assert.Len(t, prerequisites(config.Project{Strategy: config.StrategyUpload}), 2)
+}
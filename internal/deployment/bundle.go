@@ -0,0 +1,163 @@
+package deployment
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/shell"
+)
+
+const (
+	bundlePhase = "bundle"
+
+	// bundleRef points at the deployed commit while bundling, locally and on the host.
+	bundleRef = "refs/goploy/deploy"
+)
+
+func bundleTransport(project config.Project) bool {
+	return project.Git != nil && project.Git.Transport == config.GitTransportBundle
+}
+
+// bundleStep brings the host to ref (its current branch if empty) with a git bundle of the commits it is missing.
+func bundleStep(project config.Project, ref string) step {
+	target := ref
+	if target == "" {
+		target = "the current branch"
+	}
+
+	return step{
+		name:    bundlePhase,
+		command: fmt.Sprintf("git fetch <bundle of %s from %s> && git checkout", target, project.Git.LocalPath),
+		local: func(ctx context.Context, c *SSHClient, client executor, events EventSink) error {
+			return c.pushBundle(ctx, client, project, ref, events)
+		},
+	}
+}
+
+// pushBundle fetches the local repository, bundles the commits between the host's HEAD and the target,
+// fetches the bundle on the host and checks the target out there.
+func (c *SSHClient) pushBundle(ctx context.Context, client executor, project config.Project, ref string, events EventSink) error {
+	local := project.Git.LocalPath
+
+	if err := runLocalCommand(ctx, local, bundlePhase, nil, events, "git", "fetch", "--all", "--quiet"); err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", local, err)
+	}
+
+	remoteHead := c.headCommit(ctx, client, project)
+	remoteBranch := c.remoteBranch(ctx, client, project)
+
+	target, branch, err := resolveBundleTarget(ctx, local, ref, remoteBranch)
+	if err != nil {
+		return err
+	}
+
+	if target == remoteHead {
+		emitMessage(events, bundlePhase, "Host is at %s already, nothing to bundle.", target)
+	} else if err := c.transferBundle(ctx, client, project, target, remoteHead, events); err != nil {
+		return err
+	}
+
+	checkout := shell.Command("git checkout --detach", target)
+	if branch != "" {
+		checkout = shell.Command("git checkout -B", branch, target)
+	}
+	return c.runStep(ctx, client, project, bundlePhase, shell.InDir(project.Path, checkout), events)
+}
+
+// transferBundle bundles target, excluding the history the host already has, and fetches it on the host.
+func (c *SSHClient) transferBundle(ctx context.Context, client executor, project config.Project, target, remoteHead string, events EventSink) error {
+	local := project.Git.LocalPath
+
+	dir, err := os.MkdirTemp("", "goploy-bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "deploy.bundle")
+
+	if err := runLocalCommand(ctx, local, bundlePhase, io.Discard, events, "git", "update-ref", bundleRef, target); err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer runLocalCommand(context.WithoutCancel(ctx), local, bundlePhase, io.Discard, events, "git", "update-ref", "-d", bundleRef)
+
+	args := []string{"bundle", "create", "--quiet", file, bundleRef}
+	if remoteHead != "" && localCommitExists(ctx, local, remoteHead) {
+		args = append(args, "^"+remoteHead)
+	} else {
+		emitMessage(events, bundlePhase, "Host history unknown locally, bundling the full history of %s.", target)
+	}
+	if err := runLocalCommand(ctx, local, bundlePhase, io.Discard, events, "git", args...); err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The bundle file lives in the host's git directory only while it is fetched.
+	remoteCommand := fmt.Sprintf(`cd %s && f="$(git rev-parse --git-dir)/goploy.bundle" && cat > "$f" && git fetch --quiet "$f" %s; status=$?; rm -f "$f"; exit $status`,
+		shell.Quote(project.Path), shell.Quote("+"+bundleRef+":"+bundleRef))
+	err = c.streamCommand(ctx, client, remoteCommand, f, bundlePhase, events, func(n int64) {
+		emitMessage(events, bundlePhase, "%s transferred", formatBytes(n))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push bundle: %w", err)
+	}
+	return nil
+}
+
+// resolveBundleTarget resolves ref in the local repository, preferring the remote-tracking branch
+// (origin/<ref>) over the local one. An empty ref deploys the host's current branch. branch is set
+// if ref names a branch, which is then checked out on the host, tags and commits are checked out detached.
+func resolveBundleTarget(ctx context.Context, dir, ref, remoteBranch string) (target, branch string, err error) {
+	if ref == "" {
+		if remoteBranch == "" {
+			return "", "", errors.New("host is not on a branch, a ref is required")
+		}
+		ref = remoteBranch
+	}
+
+	for _, candidate := range []string{"refs/remotes/origin/" + ref, "refs/heads/" + ref} {
+		if commit, err := localRevParse(ctx, dir, candidate); err == nil {
+			return commit, ref, nil
+		}
+	}
+
+	commit, err := localRevParse(ctx, dir, ref)
+	if err != nil {
+		return "", "", fmt.Errorf("ref %s not found in %s", ref, dir)
+	}
+	return commit, "", nil
+}
+
+func localRevParse(ctx context.Context, dir, ref string) (string, error) {
+	var out bytes.Buffer
+	if err := runLocalCommand(ctx, dir, bundlePhase, &out, EventFunc(func(Event) {}), "git", "rev-parse", "--verify", "--quiet", ref+"^{commit}"); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+func localCommitExists(ctx context.Context, dir, commit string) bool {
+	_, err := localRevParse(ctx, dir, commit)
+	return err == nil
+}
+
+// remoteBranch returns the branch checked out on the host, empty if it is detached or unknown.
+func (c *SSHClient) remoteBranch(ctx context.Context, client executor, project config.Project) string {
+	var b strings.Builder
+	cmd := shell.InDir(project.Path, "git symbolic-ref --quiet --short HEAD")
+	if err := c.runSession(client, cmd, &b, io.Discard, ctx); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(b.String())
+}
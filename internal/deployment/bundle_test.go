@@ -0,0 +1,157 @@
+package deployment
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// initRepo creates a local repository on branch main with the given number of commits and returns
+// its path and the commits, oldest first.
+func initRepo(t *testing.T, commits int) (string, []string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+		return strings.TrimSpace(string(out))
+	}
+
+	git("init", "--quiet", "--initial-branch=main")
+	var shas []string
+	for i := 0; i < commits; i++ {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte{byte('a' + i)}, 0o644))
+		git("add", "file")
+		git("commit", "--quiet", "-m", "commit")
+		shas = append(shas, git("rev-parse", "HEAD"))
+	}
+	git("tag", "v1", shas[0])
+	return dir, shas
+}
+
+func TestPushBundle(t *testing.T) {
+	local, shas := initRepo(t, 2)
+
+	var (
+		mu     sync.Mutex
+		bundle []byte
+	)
+	server := newTestSSHServer(t, func(cmd string, stdin io.Reader, stdout, _ io.Writer, _ <-chan string) int {
+		switch {
+		case strings.HasSuffix(cmd, "git rev-parse HEAD"):
+			io.WriteString(stdout, shas[0]+"\n")
+		case strings.HasSuffix(cmd, "git symbolic-ref --quiet --short HEAD"):
+			io.WriteString(stdout, "main\n")
+		case strings.Contains(cmd, "goploy.bundle"):
+			data, _ := io.ReadAll(stdin)
+			mu.Lock()
+			bundle = data
+			mu.Unlock()
+		}
+		return 0
+	})
+	client := server.connect(t)
+
+	project := config.Project{Name: "api", Path: "/srv/api", Git: &config.GitConfig{Transport: config.GitTransportBundle, LocalPath: local}}
+	err := (&SSHClient{}).runSteps(context.Background(), client, project, sourceSteps(project, ""), &recordingSink{})
+	require.NoError(t, err)
+
+	executed := strings.Join(server.executed(), "\n")
+	assert.Contains(t, executed, `git fetch --quiet "$f" '+refs/goploy/deploy:refs/goploy/deploy'`)
+	assert.Contains(t, executed, "git checkout -B 'main' '"+shas[1]+"'")
+	assert.NotContains(t, executed, "git pull")
+
+	// The bundle holds the new commit only, the host has its parent.
+	file := filepath.Join(t.TempDir(), "deploy.bundle")
+	mu.Lock()
+	require.NoError(t, os.WriteFile(file, bundle, 0o644))
+	mu.Unlock()
+	out, err := exec.Command("git", "-C", local, "bundle", "list-heads", file).CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Equal(t, shas[1]+" "+bundleRef, strings.TrimSpace(string(out)))
+	out, err = exec.Command("git", "-C", local, "bundle", "verify", file).CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Contains(t, string(out), shas[0])
+
+	// The temporary ref is removed again.
+	assert.Error(t, exec.Command("git", "-C", local, "rev-parse", "--verify", "--quiet", bundleRef).Run())
+}
+
+func TestPushBundle_UpToDate(t *testing.T) {
+	local, shas := initRepo(t, 1)
+
+	server := newTestSSHServer(t, func(cmd string, _ io.Reader, stdout, _ io.Writer, _ <-chan string) int {
+		switch {
+		case strings.HasSuffix(cmd, "git rev-parse HEAD"):
+			io.WriteString(stdout, shas[0]+"\n")
+		case strings.HasSuffix(cmd, "git symbolic-ref --quiet --short HEAD"):
+			return 1
+		}
+		return 0
+	})
+	client := server.connect(t)
+
+	project := config.Project{Name: "api", Path: "/srv/api", Git: &config.GitConfig{Transport: config.GitTransportBundle, LocalPath: local}}
+	err := (&SSHClient{}).pushBundle(context.Background(), client, project, "v1", &recordingSink{})
+	require.NoError(t, err)
+
+	executed := strings.Join(server.executed(), "\n")
+	assert.NotContains(t, executed, "goploy.bundle")
+	assert.Contains(t, executed, "git checkout --detach '"+shas[0]+"'")
+
+	err = (&SSHClient{}).pushBundle(context.Background(), client, project, "", &recordingSink{})
+	assert.EqualError(t, err, "host is not on a branch, a ref is required")
+}
+
+func TestResolveBundleTarget(t *testing.T) {
+	local, shas := initRepo(t, 2)
+	ctx := context.Background()
+
+	target, branch, err := resolveBundleTarget(ctx, local, "main", "")
+	require.NoError(t, err)
+	assert.Equal(t, shas[1], target)
+	assert.Equal(t, "main", branch)
+
+	target, branch, err = resolveBundleTarget(ctx, local, "", "main")
+	require.NoError(t, err)
+	assert.Equal(t, shas[1], target)
+	assert.Equal(t, "main", branch)
+
+	target, branch, err = resolveBundleTarget(ctx, local, "v1", "main")
+	require.NoError(t, err)
+	assert.Equal(t, shas[0], target)
+	assert.Empty(t, branch)
+
+	_, _, err = resolveBundleTarget(ctx, local, "missing", "main")
+	assert.EqualError(t, err, "ref missing not found in "+local)
+}
+
+func TestValidateStrategy_Bundle(t *testing.T) {
+	bundle := &config.GitConfig{Transport: config.GitTransportBundle, LocalPath: "."}
+	assert.NoError(t, validateStrategy(config.Project{Git: bundle}, "main"))
+	assert.NoError(t, validateStrategy(config.Project{Git: &config.GitConfig{Transport: config.GitTransportFetch}}, "main"))
+
+	assert.EqualError(t, validateStrategy(config.Project{Git: &config.GitConfig{Transport: config.GitTransportBundle}}, ""),
+		"the bundle transport requires git.local_path")
+	assert.EqualError(t, validateStrategy(config.Project{Strategy: config.StrategyImage, Git: bundle}, ""),
+		"the bundle transport cannot be combined with the image strategy")
+	assert.EqualError(t, validateStrategy(config.Project{Git: &config.GitConfig{Transport: "rsync"}}, ""),
+		`unknown git transport "rsync"`)
+}
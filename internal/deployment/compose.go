@@ -0,0 +1,86 @@
+package deployment
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/shell"
+)
+
+// composeCommand returns the docker compose command of the project's live stack, built from its
+// compose settings. The live color of blue/green projects and its port are resolved on the remote,
+// the port variable stays empty for stacks deployed in place.
+func composeCommand(project config.Project) string {
+	if project.BlueGreen == nil {
+		return inPlaceCompose(project)
+	}
+
+	fallback := ""
+	if project.Compose != nil && project.Compose.ProjectName != "" {
+		fallback = " || echo -p " + shell.Quote(project.Compose.ProjectName)
+	}
+	port := fmt.Sprintf("%s=$(case $(cat %s 2>/dev/null) in (%s) echo %d ;; (%s) echo %d ;; esac)", portVariable(project),
+		colorFile, colorBlue, colorPort(project, colorBlue), colorGreen, colorPort(project, colorGreen))
+	return fmt.Sprintf("%s %s $(test -f %s && echo -p %s-$(cat %s)%s)", port, composeBase(project), colorFile, shell.Quote(colorPrefix(project)), colorFile, fallback)
+}
+
+// inPlaceCompose returns the docker compose command of the stack deployed in place, with compose.project_name if set.
+// Upload projects always pass the project name, it would be derived from the release directory otherwise.
+func inPlaceCompose(project config.Project) string {
+	if (project.Compose != nil && project.Compose.ProjectName != "") || uploadStrategy(project) {
+		return composeBase(project) + " -p " + shell.Quote(composeProjectName(project))
+	}
+	return composeBase(project)
+}
+
+// composeBase returns the compose command of the project's runtime (`docker compose` by default) with the
+// configured files, profiles and env file, but without project name.
+func composeBase(project config.Project) string {
+	args := []string{runtimeOf(project).compose}
+
+	// composeArgs are flag/value pairs, only the values need quoting.
+	flags := composeArgs(project)
+	for i := 0; i < len(flags); i += 2 {
+		args = append(args, flags[i], shell.Quote(flags[i+1]))
+	}
+	return strings.Join(args, " ")
+}
+
+// composeArgs returns the flag/value pairs of `docker compose` selecting files, profiles and env file.
+func composeArgs(project config.Project) []string {
+	var args []string
+
+	if cfg := project.Compose; cfg != nil {
+		for _, f := range cfg.Files {
+			args = append(args, "-f", f)
+		}
+		for _, p := range cfg.Profiles {
+			args = append(args, "--profile", p)
+		}
+		if cfg.EnvFile != "" {
+			args = append(args, "--env-file", cfg.EnvFile)
+		}
+	}
+
+	return args
+}
+
+// composeProjectName returns the name of the compose project deployed in place: compose.project_name,
+// or the name compose derives from the project path.
+func composeProjectName(project config.Project) string {
+	if project.Compose != nil && project.Compose.ProjectName != "" {
+		return project.Compose.ProjectName
+	}
+
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return -1
+		}
+	}, strings.ToLower(path.Base(project.Path)))
+	return strings.TrimLeft(name, "-_")
+}
@@ -0,0 +1,36 @@
+package deployment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComposeCommand(t *testing.T) {
+	project := config.Project{Name: "API"}
+	assert.Equal(t, "docker compose", composeCommand(project))
+
+	project.Compose = &config.Compose{
+		Files:       []string{"docker-compose.yml", "docker-compose.prod.yml"},
+		Profiles:    []string{"web", "worker"},
+		EnvFile:     ".env.production",
+		ProjectName: "api-prod",
+	}
+	assert.Equal(t,
+		"docker compose -f 'docker-compose.yml' -f 'docker-compose.prod.yml' --profile 'web' --profile 'worker' --env-file '.env.production' -p 'api-prod'",
+		composeCommand(project))
+
+	steps := deploySteps(project, "")
+	assert.Equal(t, composeCommand(project)+" up -d --build", steps[len(steps)-1].command)
+
+	project.BlueGreen = &config.BlueGreen{BluePort: 8001, GreenPort: 8002, Drain: time.Second}
+	assert.Equal(t,
+		"GOPLOY_PORT=$(case $(cat .goploy.color 2>/dev/null) in (blue) echo 8001 ;; (green) echo 8002 ;; esac) "+
+			"docker compose -f 'docker-compose.yml' -f 'docker-compose.prod.yml' --profile 'web' --profile 'worker' --env-file '.env.production' $(test -f .goploy.color && echo -p 'api-prod'-$(cat .goploy.color) || echo -p 'api-prod')",
+		composeCommand(project))
+	assert.Equal(t,
+		"GOPLOY_PORT=8002 docker compose -f 'docker-compose.yml' -f 'docker-compose.prod.yml' --profile 'web' --profile 'worker' --env-file '.env.production' -p 'api-prod-green'",
+		colorCompose(project, colorGreen))
+}
@@ -1,6 +1,7 @@
 package deployment
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,33 +9,56 @@ import (
 	"io"
 	"net"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/history"
 	"github.com/pmaojo/goploy/internal/mailer"
+	"github.com/pmaojo/goploy/internal/shell"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/knownhosts"
-	"golang.org/x/term"
 )
 
 // Controller defines the interface for controlling a project.
+// Cancelling ctx interrupts the running remote command.
 type Controller interface {
-	Deploy(project config.Project, output io.Writer, ref string) error
+	Deploy(ctx context.Context, project config.Project, events EventSink, ref string) error
+	Init(ctx context.Context, project config.Project, events EventSink) error
+	Plan(ctx context.Context, project config.Project, ref string) (DeploymentPlan, error)
+	Rollback(ctx context.Context, project config.Project, events EventSink) error
 	StreamLogs(ctx context.Context, project config.Project, output io.Writer) error
-	Restart(project config.Project, output io.Writer) error
-	Stop(project config.Project, output io.Writer) error
-	ListServices(project config.Project) ([]string, error)
-	RunShell(project config.Project, service string) error
+	Restart(ctx context.Context, project config.Project, output io.Writer) error
+	Stop(ctx context.Context, project config.Project, output io.Writer) error
+	ListServices(ctx context.Context, project config.Project) ([]string, error)
+	RunShell(ctx context.Context, project config.Project, service string) error
 	GetStatus(ctx context.Context, project config.Project) (ProjectStatus, error)
-	UploadFile(project config.Project, content []byte, remotePath string) error
-	RunCommand(project config.Project, cmd string) error
+	UploadFile(ctx context.Context, project config.Project, content []byte, remotePath string) error
+	RunCommand(ctx context.Context, project config.Project, cmd string) error
 }
 
 // SSHClient implements Controller using golang.org/x/crypto/ssh.
 type SSHClient struct {
 	Mailer *mailer.Mailer
+	// History records deployments if set.
+	History *history.Store
+	// Trigger is recorded as the initiator of deployments and lock holders, e.g. history.TriggerAPI.
+	Trigger string
+	// Upstreams switches the Caddy route of blue/green deployments.
+	Upstreams UpstreamSwitcher
+	// HostKeyPrompt asks whether to trust the key of an unknown host of a tofu project. Without it unknown keys are trusted right away.
+	HostKeyPrompt func(ctx context.Context, host, keyType, fingerprint string) bool
+
+	locks    projectLocks
+	pool     connPool
+	promptMu sync.Mutex
+	// runtimes are the runtimes detected per host, see resolveRuntime.
+	runtimes sync.Map
+	// local runs the commands of every project on this machine, see LocalClient.
+	local bool
 }
 
 var _ Controller = (*SSHClient)(nil)
@@ -46,12 +70,151 @@ func NewSSHClient(mailer *mailer.Mailer) *SSHClient {
 	}
 }
 
-// connect establishes an SSH connection to the project host.
-func (c *SSHClient) connect(project config.Project) (*ssh.Client, error) {
-	// 1. Determine Host, User, Port
-	host := project.Host
-	user := project.User
-	port := project.Port
+// Close closes all pooled SSH connections.
+func (c *SSHClient) Close() error {
+	c.pool.close()
+	return nil
+}
+
+// connect returns a pooled SSH connection to the project host, shared per user@host:port
+// (and jump host chain). The returned func releases the connection back into the pool.
+// Dialing is shared between callers and bounded by its own timeout, ctx is checked before and after.
+// Projects with host: local (and LocalClient) run their commands on this machine instead.
+func (c *SSHClient) connect(ctx context.Context, project config.Project) (executor, func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if c.local || project.IsLocal() {
+		return localExecutor{}, func() {}, nil
+	}
+
+	sshCfg, err := userSSHConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read ssh config: %w", err)
+	}
+
+	ep := resolveEndpoint(project, sshCfg)
+	hops := append(slices.Clone(ep.jumps), ep.hop)
+	dial := func() (*ssh.Client, error) {
+		return dialChain(hops, func(h hop) (*ssh.ClientConfig, error) {
+			return clientConfig(h, c.unknownHostKey(h))
+		})
+	}
+
+	client, release, err := c.pool.get(ep.key(), dial)
+	// Unknown keys of tofu hops are confirmed outside of the handshake, it may time out meanwhile.
+	// Each hop of the chain may present one, they are confirmed in turn.
+	for range hops {
+		var unknown *UnknownHostKeyError
+		if !errors.As(err, &unknown) || c.HostKeyPrompt == nil {
+			break
+		}
+		h, ok := unknownHop(hops, unknown)
+		if !ok || h.hostKeyPolicy != config.HostKeyTOFU {
+			break
+		}
+		if err = c.confirmHostKey(ctx, h, unknown); err != nil {
+			break
+		}
+		client, release, err = c.pool.get(ep.key(), dial)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	return sshExecutor{client}, release, nil
+}
+
+// unknownHop returns the hop of the chain that presented the unknown key.
+func unknownHop(hops []hop, unknown *UnknownHostKeyError) (hop, bool) {
+	for _, h := range hops {
+		if knownhosts.Normalize(h.addr()) == unknown.Host {
+			return h, true
+		}
+	}
+	return hop{}, false
+}
+
+// unknownHostKey handles keys of h that known_hosts doesn't list: tofu hops add them unless the user
+// has to confirm them (see confirmHostKey), others reject them.
+func (c *SSHClient) unknownHostKey(h hop) func(hostname string, key ssh.PublicKey) error {
+	if h.hostKeyPolicy != config.HostKeyTOFU || c.HostKeyPrompt != nil {
+		return strictUnknown
+	}
+	return func(hostname string, key ssh.PublicKey) error {
+		return addKnownHost(h.knownHostsFiles[0], hostname, key)
+	}
+}
+
+// hop is a single SSH destination.
+type hop struct {
+	host            string
+	user            string
+	port            string
+	identityFiles   []string
+	knownHostsFiles []string
+	// hostKeyPolicy is one of the config.HostKey policies, fingerprint the key pinned for it.
+	hostKeyPolicy string
+	fingerprint   string
+}
+
+func (h hop) addr() string {
+	return net.JoinHostPort(h.host, h.port)
+}
+
+// endpoint is a fully resolved SSH destination, reached through jumps (in order) if any.
+type endpoint struct {
+	hop
+	jumps []hop
+}
+
+func (ep endpoint) key() string {
+	var b strings.Builder
+	for _, j := range ep.jumps {
+		b.WriteString(j.user + "@" + j.addr() + ">")
+	}
+	b.WriteString(ep.user + "@" + ep.addr())
+	return b.String()
+}
+
+// resolveEndpoint determines host, user, port, identity files and jump hosts of the project,
+// the first host of multi-host projects. Values set in goploy.yaml take precedence over the ones from ssh_config.
+func resolveEndpoint(project config.Project, sshCfg *sshConfig) endpoint {
+	target, proxyJump := resolveHop(project.HostList()[0], project.User, project.Port, project.IdentityFile, sshCfg)
+	ep := endpoint{hop: target}
+
+	// The pinned key belongs to the target, jump hosts are verified strictly then.
+	jumpPolicy := ""
+	if project.HostKey != nil {
+		ep.hostKeyPolicy = project.HostKey.Policy
+		ep.fingerprint = project.HostKey.Fingerprint
+		if project.HostKey.Policy != config.HostKeyPinned {
+			jumpPolicy = project.HostKey.Policy
+		}
+	}
+
+	jumps := project.ProxyJump
+	if len(jumps) == 0 {
+		jumps = parseProxyJump(proxyJump)
+	}
+	for _, j := range jumps {
+		// ProxyJump settings of jump hosts themselves are not followed.
+		jump, _ := resolveHop(j.Host, j.User, j.Port, j.IdentityFile, sshCfg)
+		jump.hostKeyPolicy = jumpPolicy
+		ep.jumps = append(ep.jumps, jump)
+	}
+
+	return ep
+}
+
+// resolveHop resolves a single destination, it also returns the ProxyJump configured for it in ssh_config.
+func resolveHop(host, user, port, identityFile string, sshCfg *sshConfig) (hop, string) {
+	host = strings.TrimPrefix(host, "ssh://")
 
 	// If host contains user@ or :port, parse it
 	if strings.Contains(host, "@") {
@@ -69,6 +232,18 @@ func (c *SSHClient) connect(project config.Project) (*ssh.Client, error) {
 		}
 	}
 
+	// Host aliases from ~/.ssh/config
+	hc := sshCfg.lookup(host)
+	if hc.HostName != "" {
+		host = hc.HostName
+	}
+	if user == "" {
+		user = hc.User
+	}
+	if port == "" {
+		port = hc.Port
+	}
+
 	// Defaults
 	if user == "" {
 		user = os.Getenv("USER") // fallback to current user
@@ -77,32 +252,130 @@ func (c *SSHClient) connect(project config.Project) (*ssh.Client, error) {
 		port = "22"
 	}
 
-	// 2. Prepare Auth Methods
-	authMethods := []ssh.AuthMethod{}
+	h := hop{host: host, user: user, port: port}
 
-	// Identity File
-	identityFile := project.IdentityFile
-	if identityFile == "" {
+	// Identity Files
+	identityFiles := hc.IdentityFiles
+	if identityFile != "" {
+		identityFiles = []string{identityFile}
+	} else if len(identityFiles) == 0 {
 		// Default to ~/.ssh/id_rsa
-		home, err := os.UserHomeDir()
-		if err == nil {
-			identityFile = home + "/.ssh/id_rsa"
+		identityFiles = []string{"~/.ssh/id_rsa"}
+	}
+	for _, f := range identityFiles {
+		h.identityFiles = append(h.identityFiles, expandSSHTokens(f, h))
+	}
+
+	// Known hosts, defaults to ~/.ssh/known_hosts
+	knownHostsFiles := hc.KnownHostsFiles
+	if len(knownHostsFiles) == 0 {
+		knownHostsFiles = []string{"~/.ssh/known_hosts"}
+	}
+	for _, f := range knownHostsFiles {
+		h.knownHostsFiles = append(h.knownHostsFiles, expandSSHTokens(f, h))
+	}
+
+	return h, hc.ProxyJump
+}
+
+// dialChain connects to the last hop, tunneling through the previous ones like OpenSSH's ProxyJump.
+// The jump connections are closed once the returned client is closed.
+func dialChain(hops []hop, configure func(hop) (*ssh.ClientConfig, error)) (*ssh.Client, error) {
+	var clients []*ssh.Client
+	closeAll := func() {
+		for i := len(clients) - 1; i >= 0; i-- {
+			clients[i].Close()
 		}
-	} else {
-		// Expand ~ if present
-		if strings.HasPrefix(identityFile, "~/") {
-			home, err := os.UserHomeDir()
-			if err == nil {
-				identityFile = home + identityFile[1:]
+	}
+
+	for i, h := range hops {
+		clientConfig, err := configure(h)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+
+		var client *ssh.Client
+		if i == 0 {
+			client, err = ssh.Dial("tcp", h.addr(), clientConfig)
+		} else {
+			client, err = dialThrough(clients[i-1], h.addr(), clientConfig)
+		}
+		if err != nil {
+			closeAll()
+			if i < len(hops)-1 {
+				return nil, fmt.Errorf("failed to dial jump host %s: %w", h.addr(), err)
 			}
+			return nil, fmt.Errorf("failed to dial ssh %s: %w", h.addr(), err)
 		}
+		clients = append(clients, client)
 	}
 
-	key, err := os.ReadFile(identityFile)
-	if err == nil {
-		signer, err := ssh.ParsePrivateKey(key)
+	target := clients[len(clients)-1]
+	if len(clients) > 1 {
+		go func() {
+			_ = target.Wait()
+			closeAll()
+		}()
+	}
+
+	return target, nil
+}
+
+// dialThrough opens an SSH connection to addr tunneled through an existing connection.
+func dialThrough(jump *ssh.Client, addr string, clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := jump.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// ssh.Dial applies the timeout to the TCP dial only, bound the handshake the same way.
+	if clientConfig.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(clientConfig.Timeout))
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// clientTimeout bounds dialing a single hop.
+const clientTimeout = 10 * time.Second
+
+// clientConfig prepares authentication and host key verification for a hop, following its host key policy.
+// Keys of the host not listed in ~/.ssh/known_hosts (or UserKnownHostsFile from ssh_config) are passed to unknown,
+// missing files are skipped like OpenSSH does.
+func clientConfig(h hop, unknown func(hostname string, key ssh.PublicKey) error) (*ssh.ClientConfig, error) {
+	hostKeyCallback, algorithms, err := hostKeyConfig(h, unknown)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:              h.user,
+		Auth:              authMethods(h),
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: algorithms,
+		Timeout:           clientTimeout,
+	}, nil
+}
+
+// authMethods offers the identity files of the hop and the keys of the SSH agent.
+func authMethods(h hop) []ssh.AuthMethod {
+	authMethods := []ssh.AuthMethod{}
+
+	for _, identityFile := range h.identityFiles {
+		key, err := os.ReadFile(identityFile)
 		if err == nil {
-			authMethods = append(authMethods, ssh.PublicKeys(signer))
+			signer, err := ssh.ParsePrivateKey(key)
+			if err == nil {
+				authMethods = append(authMethods, ssh.PublicKeys(signer))
+			}
 		}
 	}
 
@@ -114,178 +387,324 @@ func (c *SSHClient) connect(project config.Project) (*ssh.Client, error) {
 		}
 	}
 
-	// 3. Host Key Verification
-	// We use ~/.ssh/known_hosts
-	home, err := os.UserHomeDir()
-	var hostKeyCallback ssh.HostKeyCallback
-	if err == nil {
-		knownHostsFile := home + "/.ssh/known_hosts"
-		hostKeyCallback, err = knownhosts.New(knownHostsFile)
-		if err != nil {
-			// Fallback or error? User asked for "safely", but if known_hosts doesn't exist or is unreadable...
-			// We might want to allow InsecureIgnoreHostKey ONLY if explicitly configured, but for now let's be strict
-			// or just warn.
-			// However, knownhosts.New returns error if file doesn't exist usually.
-			// Let's assume strict check for "safely".
-			// If file doesn't exist, create an empty one? No, knownhosts.New handles non-existent file? No.
-			// If it fails, we return error.
-			return nil, fmt.Errorf("failed to load known_hosts: %w", err)
-		}
-	} else {
-		return nil, fmt.Errorf("failed to get user home dir: %w", err)
+	return authMethods
+}
+
+// Deploy connects to the project hosts and runs the deployment commands, reporting progress as events.
+// ref is the git ref to check out, or the image tag to deploy for image projects (see config.StrategyImage).
+// Multi-host projects are rolled out host by host (see config.Rollout), halting on the first failure.
+// Nothing is emitted before the project lock has been acquired, failures up to then (validation, connection,
+// lock) are only returned. Once the lock is held, the last event is an EventResult.
+// The deployment is bounded by timeouts.deploy, each step by timeouts.step. Hosts without the project path
+// are initialized first, see Init.
+func (c *SSHClient) Deploy(ctx context.Context, project config.Project, events EventSink, ref string) error {
+	if err := validateStrategy(project, ref); err != nil {
+		return err
 	}
+	if err := c.validateBlueGreen(project); err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	ctx, cancel, wrapTimeout := withTimeout(ctx, deployTimeout(project), "deploy")
+	defer cancel()
 
-	clientConfig := &ssh.ClientConfig{
-		User:            user,
-		Auth:            authMethods,
-		HostKeyCallback: hostKeyCallback,
-		Timeout:         10 * time.Second,
+	hosts, release, err := c.connectHosts(ctx, project)
+	if err != nil {
+		err = wrapTimeout(fmt.Errorf("connection failed: %w", err))
+		c.recordFailure(project, history.ActionDeploy, ref, err)
+		return err
 	}
+	defer release()
 
-	addr := net.JoinHostPort(host, port)
-	client, err := ssh.Dial("tcp", addr, clientConfig)
+	unlock, missing, err := c.lockCreatingPaths(ctx, hosts, history.ActionDeploy)
 	if err != nil {
-		return nil, fmt.Errorf("failed to dial ssh %s: %w", addr, err)
+		var lockErr *LockError
+		if !errors.As(err, &lockErr) {
+			err = wrapTimeout(err)
+			c.recordFailure(project, history.ActionDeploy, ref, err)
+		}
+		return err
 	}
+	defer unlock()
 
-	return client, nil
-}
+	// Keep a text log for email notification and deployment history
+	var logBuffer strings.Builder
+	logged := MultiSink(events, NewTextSink(&logBuffer))
 
-// Deploy connects to the project host and runs the deployment commands.
-func (c *SSHClient) Deploy(project config.Project, output io.Writer, ref string) error {
-	fmt.Fprintf(output, "Connecting to %s...\n", project.Host)
+	record := c.startRecord(project, history.ActionDeploy, ref, events)
 
-	// Buffer output for email notification
-	var logBuffer strings.Builder
-	multiOutput := io.MultiWriter(output, &logBuffer)
+	emitMessage(logged, "", "Connected to %s, project lock acquired.", hostNames(hosts))
 
-	client, err := c.connect(project)
-	if err != nil {
-		return fmt.Errorf("connection failed: %w", err)
+	// The history records the commits of the first host, it is deployed first.
+	if record != nil {
+		record.CommitBefore = c.headCommit(ctx, hosts[0].client, hosts[0].project)
 	}
-	defer client.Close()
 
-	commands := []string{
-		fmt.Sprintf("cd %q", project.Path),
-		"git fetch --all",
+	err = c.rollout(project, hosts, logged, func(h hostConn, events EventSink) error {
+		if missing[h.project.Host] {
+			emitMessage(events, "", "%s did not exist, initializing the project...", h.project.Path)
+			if err := c.bootstrapHost(ctx, h, events); err != nil {
+				return err
+			}
+		}
+		return c.deployHost(ctx, h, ref, events)
+	})
+
+	err = wrapTimeout(err)
+	if ctx.Err() != nil {
+		emitMessage(logged, "", "Deployment interrupted: %v", err)
 	}
 
-	if ref != "" {
-		// Checkout specific ref
-		commands = append(commands, fmt.Sprintf("git checkout %s", ref))
+	// Wrapping up must not be cut short by the cancellation.
+	cleanupCtx, cleanupCancel := cleanupContext(ctx)
+	defer cleanupCancel()
+
+	if record != nil {
+		record.CommitAfter = c.headCommit(cleanupCtx, hosts[0].client, hosts[0].project)
 	}
+	c.finishRecord(record, err, logBuffer.String(), events)
 
-	commands = append(commands,
-		"git pull",
-		"docker compose pull",
-		"docker compose up -d --build",
-	)
-	remoteCommand := strings.Join(commands, " && ")
+	c.notify(cleanupCtx, project, err, logBuffer.String(), events)
 
-	fmt.Fprintf(multiOutput, "Running: %s\n", remoteCommand)
+	events.Emit(Event{Type: EventResult, Time: time.Now(), Phase: history.ActionDeploy, Outcome: newOutcome(start, err)})
 
-	err = c.runSession(client, remoteCommand, multiOutput, multiOutput, nil)
+	return err
+}
 
-	// Send notification if configured
-	if c.Mailer != nil && len(project.NotifyEmails) > 0 {
-		status := "SUCCESS"
-		if err != nil {
-			status = "FAILURE"
-		}
-		// Run in background to not block deployment response?
-		// User said "Stream response", so maybe blocking here is fine as it's the last step.
-		// Or we can just log it.
-		// Since we want to notify "depending on yaml config", we do it here.
-		notifErr := c.Mailer.SendDeploymentNotification(context.Background(), project.NotifyEmails, project.Name, status, logBuffer.String())
-		if notifErr != nil {
-			fmt.Fprintf(output, "Failed to send notification: %v\n", notifErr)
-		} else {
-			fmt.Fprintf(output, "Notification sent to %v\n", project.NotifyEmails)
+// deployHost runs the deployment pipeline on a single host, waits for it to become healthy
+// (reverting it otherwise) and runs the post-deploy hooks. Blue/green projects are deployed next to the live color.
+func (c *SSHClient) deployHost(ctx context.Context, h hostConn, ref string, events EventSink) error {
+	project := h.project
+	if project.BlueGreen != nil {
+		return c.deployBlueGreen(ctx, h, sourceSteps(project, ref), project.Hooks, events)
+	}
+
+	before := c.headCommit(ctx, h.client, project)
+
+	err := c.runSteps(ctx, h.client, project, deploySteps(project, ref), events)
+
+	if err == nil && project.HealthCheck != nil {
+		if healthErr := c.waitHealthy(ctx, h.client, project, composeCommand(project), events); healthErr != nil {
+			err = healthErr
+			if ctx.Err() == nil {
+				err = c.revert(ctx, h.client, project, before, healthErr.Error(), events)
+			}
 		}
 	}
 
+	if err == nil && project.Hooks != nil && len(project.Hooks.PostDeploy) > 0 {
+		err = c.runSteps(ctx, h.client, project, hookSteps("post_deploy", project.Hooks.PostDeploy, composeCommand(project)), events)
+	}
+
 	return err
 }
 
-// StreamLogs streams the logs from the remote project.
-func (c *SSHClient) StreamLogs(ctx context.Context, project config.Project, output io.Writer) error {
-	fmt.Fprintf(output, "Streaming logs from %s...\n", project.Host)
+// Rollback checks out the commit of the previous successful deployment and brings the containers up again.
+// It is rolled out, reports progress and is bounded by timeouts like Deploy: nothing is emitted before the
+// project lock has been acquired, the last event after it is an EventResult.
+func (c *SSHClient) Rollback(ctx context.Context, project config.Project, events EventSink) error {
+	if c.History == nil {
+		return errors.New("rollback requires a deployment history")
+	}
+	if err := validateStrategy(project, ""); err != nil {
+		return err
+	}
+	if err := c.validateBlueGreen(project); err != nil {
+		return err
+	}
 
-	client, err := c.connect(project)
+	target, err := c.History.RollbackTarget(project.Name)
 	if err != nil {
-		return fmt.Errorf("connection failed: %w", err)
+		return err
 	}
-	defer client.Close()
 
-	commands := []string{
-		fmt.Sprintf("cd %q", project.Path),
-		"docker compose logs -f",
+	start := time.Now()
+
+	ctx, cancel, wrapTimeout := withTimeout(ctx, deployTimeout(project), "rollback")
+	defer cancel()
+
+	hosts, release, err := c.connectHosts(ctx, project)
+	if err != nil {
+		err = wrapTimeout(fmt.Errorf("connection failed: %w", err))
+		c.recordFailure(project, history.ActionRollback, target.CommitAfter, err)
+		return err
 	}
-	remoteCommand := strings.Join(commands, " && ")
+	defer release()
 
-	fmt.Fprintf(output, "Running: %s\n", remoteCommand)
+	unlock, err := c.lock(ctx, hosts, history.ActionRollback)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	return c.runSession(client, remoteCommand, output, output, ctx)
+	var logBuffer strings.Builder
+	logged := MultiSink(events, NewTextSink(&logBuffer))
+
+	record := c.startRecord(project, history.ActionRollback, target.CommitAfter, events)
+
+	emitMessage(logged, "", "Rolling back %s on %s to %s (deployed %s)...", project.Name, hostNames(hosts), target.CommitAfter, target.StartedAt.Format(time.RFC3339))
+
+	if record != nil {
+		record.CommitBefore = c.headCommit(ctx, hosts[0].client, hosts[0].project)
+	}
+
+	checkout := versionStep(project, target.CommitAfter)
+	steps := []step{checkout}
+	if !swarmStrategy(project) {
+		steps = append(steps, step{name: "image pull", command: pullCommand(project, composeCommand(project))})
+	}
+	steps = append(steps, step{name: "up", command: upCommand(project, composeCommand(project))})
+
+	err = c.rollout(project, hosts, logged, func(h hostConn, events EventSink) error {
+		if project.BlueGreen != nil {
+			return c.deployBlueGreen(ctx, h, []step{checkout}, nil, events)
+		}
+		return c.runSteps(ctx, h.client, h.project, steps, events)
+	})
+
+	err = wrapTimeout(err)
+	if ctx.Err() != nil {
+		emitMessage(logged, "", "Rollback interrupted: %v", err)
+	}
+
+	cleanupCtx, cleanupCancel := cleanupContext(ctx)
+	defer cleanupCancel()
+
+	if record != nil {
+		record.CommitAfter = c.headCommit(cleanupCtx, hosts[0].client, hosts[0].project)
+	}
+	c.finishRecord(record, err, logBuffer.String(), events)
+
+	c.notify(cleanupCtx, project, err, logBuffer.String(), events)
+
+	events.Emit(Event{Type: EventResult, Time: time.Now(), Phase: history.ActionRollback, Outcome: newOutcome(start, err)})
+
+	return err
 }
 
-// Restart restarts the project containers.
-func (c *SSHClient) Restart(project config.Project, output io.Writer) error {
-	fmt.Fprintf(output, "Restarting project on %s...\n", project.Host)
+// notify sends the deployment notification email if configured.
+func (c *SSHClient) notify(ctx context.Context, project config.Project, deployErr error, log string, events EventSink) {
+	if c.Mailer == nil || len(project.NotifyEmails) == 0 {
+		return
+	}
+
+	status := "SUCCESS"
+	var healthErr *HealthCheckError
+	switch {
+	case errors.As(deployErr, &healthErr) && healthErr.rolledBack():
+		status = "ROLLED BACK"
+	case deployErr != nil:
+		status = "FAILURE"
+	}
 
-	client, err := c.connect(project)
+	// Blocking here is fine, notifying is the last step of the streamed deployment response.
+	notifErr := c.Mailer.SendDeploymentNotification(ctx, project.NotifyEmails, project.Name, status, log)
+	if notifErr != nil {
+		emitMessage(events, "", "Failed to send notification: %v", notifErr)
+	} else {
+		emitMessage(events, "", "Notification sent to %v", project.NotifyEmails)
+	}
+}
+
+// StreamLogs streams the logs from the remote project, of all services of the stack for swarm projects.
+func (c *SSHClient) StreamLogs(ctx context.Context, project config.Project, output io.Writer) error {
+	fmt.Fprintf(output, "Streaming logs from %s...\n", project.HostList()[0])
+
+	client, release, err := c.connect(ctx, project)
 	if err != nil {
 		return fmt.Errorf("connection failed: %w", err)
 	}
-	defer client.Close()
+	defer release()
+
+	project = c.resolveRuntime(ctx, client, project)
+
+	logs := composeCommand(project) + " logs -f"
+	if swarmStrategy(project) {
+		logs = swarmLogsCommand(project)
+	}
 
 	commands := []string{
-		fmt.Sprintf("cd %q", project.Path),
-		"docker compose restart",
+		shell.Command("cd", workDir(project)),
+		logs,
 	}
 	remoteCommand := strings.Join(commands, " && ")
 
 	fmt.Fprintf(output, "Running: %s\n", remoteCommand)
 
-	return c.runSession(client, remoteCommand, output, output, nil)
+	return c.runSession(client, remoteCommand, output, output, ctx)
 }
 
-// Stop stops the project containers.
-func (c *SSHClient) Stop(project config.Project, output io.Writer) error {
-	fmt.Fprintf(output, "Stopping project on %s...\n", project.Host)
+// Restart restarts the project containers, on every host of multi-host projects one after another.
+// The services of swarm projects are updated with --force, replacing their tasks.
+func (c *SSHClient) Restart(ctx context.Context, project config.Project, output io.Writer) error {
+	if swarmStrategy(project) {
+		return c.runOnHosts(ctx, project, "restart", "Restarting", swarmRestartCommand(project), output)
+	}
+	return c.runOnHosts(ctx, project, "restart", "Restarting", composeCommand(project)+" restart", output)
+}
 
-	client, err := c.connect(project)
+// Stop stops the project containers, on every host of multi-host projects one after another.
+// The services of swarm projects are scaled to 0.
+func (c *SSHClient) Stop(ctx context.Context, project config.Project, output io.Writer) error {
+	if swarmStrategy(project) {
+		return c.runOnHosts(ctx, project, "stop", "Stopping", swarmStopCommand(project), output)
+	}
+	return c.runOnHosts(ctx, project, "stop", "Stopping", composeCommand(project)+" stop", output)
+}
+
+// runOnHosts runs a docker command on every host while holding the project lock.
+func (c *SSHClient) runOnHosts(ctx context.Context, project config.Project, operation, gerund, command string, output io.Writer) error {
+	hosts, release, err := c.connectHosts(ctx, project)
 	if err != nil {
 		return fmt.Errorf("connection failed: %w", err)
 	}
-	defer client.Close()
+	defer release()
 
-	commands := []string{
-		fmt.Sprintf("cd %q", project.Path),
-		"docker compose stop",
+	unlock, err := c.lock(ctx, hosts, operation)
+	if err != nil {
+		return err
 	}
-	remoteCommand := strings.Join(commands, " && ")
+	defer unlock()
 
-	fmt.Fprintf(output, "Running: %s\n", remoteCommand)
+	for _, h := range hosts {
+		fmt.Fprintf(output, "%s project on %s...\n", gerund, h.project.Host)
+
+		commands := []string{
+			shell.Command("cd", workDir(h.project)),
+			command,
+		}
+		remoteCommand := strings.Join(commands, " && ")
+
+		fmt.Fprintf(output, "Running: %s\n", remoteCommand)
+
+		if err := c.runSession(h.client, remoteCommand, output, output, ctx); err != nil {
+			return forHost(project, h.project.Host, err)
+		}
+	}
 
-	return c.runSession(client, remoteCommand, output, output, nil)
+	return nil
 }
 
 // ListServices fetches the list of services for the project.
-func (c *SSHClient) ListServices(project config.Project) ([]string, error) {
-	client, err := c.connect(project)
+func (c *SSHClient) ListServices(ctx context.Context, project config.Project) ([]string, error) {
+	client, release, err := c.connect(ctx, project)
 	if err != nil {
 		return nil, fmt.Errorf("connection failed: %w", err)
 	}
-	defer client.Close()
+	defer release()
+
+	project = c.resolveRuntime(ctx, client, project)
 
 	commands := []string{
-		fmt.Sprintf("cd %q", project.Path),
-		"docker compose config --services",
+		shell.Command("cd", workDir(project)),
+		composeCommand(project) + " config --services",
 	}
 	remoteCommand := strings.Join(commands, " && ")
 
 	var b strings.Builder
-	if err := c.runSession(client, remoteCommand, &b, &b, nil); err != nil {
+	if err := c.runSession(client, remoteCommand, &b, &b, ctx); err != nil {
 		return nil, fmt.Errorf("failed to list services: %w", err)
 	}
 
@@ -301,103 +720,110 @@ func (c *SSHClient) ListServices(project config.Project) ([]string, error) {
 	return validServices, nil
 }
 
-// RunShell starts an interactive shell session for the service.
-func (c *SSHClient) RunShell(project config.Project, service string) error {
-	client, err := c.connect(project)
+// RunShell starts an interactive shell session for the service, one of ListServices.
+// For swarm projects the shell is opened in a task of the service on the manager.
+func (c *SSHClient) RunShell(ctx context.Context, project config.Project, service string) error {
+	services, err := c.ListServices(ctx, project)
 	if err != nil {
-		return fmt.Errorf("connection failed: %w", err)
+		return err
+	}
+	if !slices.Contains(services, service) {
+		return fmt.Errorf("unknown service %q, the project has %s", service, strings.Join(services, ", "))
 	}
-	defer client.Close()
 
-	session, err := client.NewSession()
+	client, release, err := c.connect(ctx, project)
 	if err != nil {
-		return fmt.Errorf("failed to create session: %w", err)
+		return fmt.Errorf("connection failed: %w", err)
+	}
+	defer release()
+
+	project = c.resolveRuntime(ctx, client, project)
+
+	enter := shell.Command(composeCommand(project)+" exec -it", service, "/bin/sh")
+	if swarmStrategy(project) {
+		enter = swarmShellCommand(project, service)
 	}
-	defer session.Close()
 
 	commands := []string{
-		fmt.Sprintf("cd %q", project.Path),
-		fmt.Sprintf("docker compose exec -it %s /bin/sh", service),
+		shell.Command("cd", workDir(project)),
+		enter,
 	}
 	remoteCommand := strings.Join(commands, " && ")
 
-	// Request PTY
-	fd := int(os.Stdin.Fd())
-	if term.IsTerminal(fd) {
-		state, err := term.MakeRaw(fd)
-		if err != nil {
-			return fmt.Errorf("failed to make raw terminal: %w", err)
-		}
-		defer term.Restore(fd, state)
+	return handleRunShellError(client.runTerminal(ctx, remoteCommand))
+}
 
-		w, h, err := term.GetSize(fd)
-		if err == nil {
-			if err := session.RequestPty("xterm", h, w, ssh.TerminalModes{
-				ssh.ECHO:          1,
-				ssh.TTY_OP_ISPEED: 14400,
-				ssh.TTY_OP_OSPEED: 14400,
-			}); err != nil {
-				return fmt.Errorf("failed to request pty: %w", err)
-			}
+// GetStatus returns the status of the project, aggregated over all hosts of multi-host projects.
+// It only fails if no host could be reached.
+func (c *SSHClient) GetStatus(ctx context.Context, project config.Project) (ProjectStatus, error) {
+	hostList := project.HostList()
+	hosts := make([]HostStatus, len(hostList))
+	errs := make([]error, len(hostList))
+
+	var wg sync.WaitGroup
+	for i, host := range hostList {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hosts[i], errs[i] = c.hostStatus(ctx, project.OnHost(host))
+		}()
+	}
+	wg.Wait()
 
-			// Handle window resize? (Advanced, skipped for now)
+	reachable := 0
+	for i, err := range errs {
+		if err != nil {
+			errs[i] = forHost(project, hostList[i], err)
+			hosts[i] = HostStatus{Host: hostList[i], Status: "Unreachable", Error: err.Error()}
+			continue
 		}
+		reachable++
 	}
-
-	session.Stdin = os.Stdin
-	session.Stdout = os.Stdout
-	session.Stderr = os.Stderr
-
-	if err := handleRunShellError(session.Run(remoteCommand)); err != nil {
-		return err
+	if reachable == 0 {
+		return ProjectStatus{}, joinErrors(errs)
 	}
 
-	return nil
+	return aggregateStatus(project.Name, hosts), nil
 }
 
-// GetStatus returns the status of the project.
-func (c *SSHClient) GetStatus(ctx context.Context, project config.Project) (ProjectStatus, error) {
-	client, err := c.connect(project)
+// hostStatus reads branch, commit and containers of a single host.
+func (c *SSHClient) hostStatus(ctx context.Context, project config.Project) (HostStatus, error) {
+	client, release, err := c.connect(ctx, project)
 	if err != nil {
-		return ProjectStatus{}, fmt.Errorf("connection failed: %w", err)
+		return HostStatus{}, fmt.Errorf("connection failed: %w", err)
+	}
+	defer release()
+
+	project = c.resolveRuntime(ctx, client, project)
+
+	// Image projects have no branch, their commit is the deployed image tag.
+	branchCommand := "git rev-parse --abbrev-ref HEAD"
+	if imageStrategy(project) {
+		branchCommand = "true"
 	}
-	defer client.Close()
 
 	commands := []string{
-		fmt.Sprintf("cd %q", project.Path),
-		"(git rev-parse --abbrev-ref HEAD || echo '')",
+		shell.Command("cd", workDir(project)),
+		fmt.Sprintf("(%s || echo '')", branchCommand),
 		"echo '---SPLIT---'",
-		"docker compose ps -a --format json",
+		fmt.Sprintf("(%s || echo '')", versionCommand(project)),
+		"echo '---SPLIT---'",
+		containersCommand(project, composeCommand(project)),
 	}
 	remoteCommand := strings.Join(commands, " && ")
 
 	var b strings.Builder
 	if err := c.runSession(client, remoteCommand, &b, &b, ctx); err != nil {
-		return ProjectStatus{}, fmt.Errorf("failed to get status: %w", err)
+		return HostStatus{}, fmt.Errorf("failed to get status: %w", err)
 	}
 
 	output := b.String()
 	parts := strings.Split(output, "---SPLIT---")
-	if len(parts) < 2 {
-		return ProjectStatus{}, fmt.Errorf("unexpected output format: %s", output)
+	if len(parts) < 3 {
+		return HostStatus{}, fmt.Errorf("unexpected output format: %s", output)
 	}
 
-	branch := strings.TrimSpace(parts[0])
-	jsonOutput := strings.TrimSpace(parts[1])
-
-	var containers []ContainerStatus
-	if err := json.Unmarshal([]byte(jsonOutput), &containers); err != nil {
-		lines := strings.Split(jsonOutput, "\n")
-		for _, line := range lines {
-			if strings.TrimSpace(line) == "" {
-				continue
-			}
-			var container ContainerStatus
-			if err := json.Unmarshal([]byte(line), &container); err == nil {
-				containers = append(containers, container)
-			}
-		}
-	}
+	containers := projectContainers(project, strings.TrimSpace(parts[2]))
 
 	status := "Down"
 	runningCount := 0
@@ -424,82 +850,127 @@ func (c *SSHClient) GetStatus(ctx context.Context, project config.Project) (Proj
 		}
 	}
 
-	return ProjectStatus{
-		Name:           project.Name,
-		Branch:         branch,
+	return HostStatus{
+		Host:           project.Host,
+		Branch:         strings.TrimSpace(parts[0]),
+		Commit:         strings.TrimSpace(parts[1]),
 		LastDeployedAt: lastDeployed,
 		Status:         status,
 		Containers:     containers,
 	}, nil
 }
 
+// aggregateStatus combines the status of all hosts, at least one of which must be reachable.
+// Hosts running another commit than the first reachable host are marked as behind.
+func aggregateStatus(name string, hosts []HostStatus) ProjectStatus {
+	ps := ProjectStatus{Name: name, Hosts: hosts}
+
+	var reference *HostStatus
+	healthy, down := 0, 0
+	for i := range hosts {
+		h := &hosts[i]
+		switch h.Status {
+		case "Healthy":
+			healthy++
+		case "Down", "Unreachable":
+			down++
+		}
+		if h.Status == "Unreachable" {
+			continue
+		}
+
+		if reference == nil {
+			reference = h
+			ps.Branch = h.Branch
+		}
+		h.Behind = h.Commit != reference.Commit
+
+		if h.LastDeployedAt.After(ps.LastDeployedAt) {
+			ps.LastDeployedAt = h.LastDeployedAt
+		}
+		ps.Containers = append(ps.Containers, h.Containers...)
+	}
+
+	switch {
+	case healthy == len(hosts):
+		ps.Status = "Healthy"
+	case down == len(hosts):
+		ps.Status = "Down"
+	default:
+		ps.Status = "Partial"
+	}
+
+	return ps
+}
+
 // UploadFile uploads content to a remote file.
-func (c *SSHClient) UploadFile(project config.Project, content []byte, remotePath string) error {
-	client, err := c.connect(project)
+func (c *SSHClient) UploadFile(ctx context.Context, project config.Project, content []byte, remotePath string) error {
+	client, release, err := c.connect(ctx, project)
 	if err != nil {
 		return fmt.Errorf("connection failed: %w", err)
 	}
-	defer client.Close()
+	defer release()
 
-	session, err := client.NewSession()
+	proc, err := client.start(shell.Command("cat >", remotePath), bytes.NewReader(content), io.Discard, io.Discard)
 	if err != nil {
-		return fmt.Errorf("failed to create session: %w", err)
+		return fmt.Errorf("failed to upload file to %s: %w", remotePath, err)
 	}
-	defer session.Close()
-
-	session.Stdin = strings.NewReader(string(content))
-	cmd := fmt.Sprintf("cat > %q", remotePath)
+	defer proc.Close()
 
-	if err := session.Run(cmd); err != nil {
+	if err := waitForSession(ctx, proc); err != nil {
 		return fmt.Errorf("failed to upload file to %s: %w", remotePath, err)
 	}
 	return nil
 }
 
 // RunCommand runs a command on the remote host without TUI output streaming (just returns error).
-func (c *SSHClient) RunCommand(project config.Project, cmd string) error {
-	client, err := c.connect(project)
+func (c *SSHClient) RunCommand(ctx context.Context, project config.Project, cmd string) error {
+	client, release, err := c.connect(ctx, project)
 	if err != nil {
 		return fmt.Errorf("connection failed: %w", err)
 	}
-	defer client.Close()
+	defer release()
 
-	return c.runSession(client, cmd, io.Discard, os.Stderr, nil)
+	return c.runSession(client, cmd, io.Discard, os.Stderr, ctx)
 }
 
-func (c *SSHClient) runSession(client *ssh.Client, cmd string, stdout, stderr io.Writer, ctx context.Context) error {
-	session, err := client.NewSession()
+func (c *SSHClient) runSession(client executor, cmd string, stdout, stderr io.Writer, ctx context.Context) error {
+	proc, err := client.start(cmd, nil, stdout, stderr)
 	if err != nil {
-		return fmt.Errorf("failed to create session: %w", err)
-	}
-	defer session.Close()
-
-	session.Stdout = stdout
-	session.Stderr = stderr
-
-	if err := session.Start(cmd); err != nil {
-		return fmt.Errorf("failed to start command: %w", err)
+		return err
 	}
+	defer proc.Close()
 
-	return waitForSession(ctx, session.Wait)
+	return waitForSession(ctx, proc)
 }
 
-func waitForSession(ctx context.Context, wait func() error) error {
+// waitForSession waits for the remote command to exit. If ctx is cancelled first, the command
+// is sent SIGTERM and its session closed if it did not exit within sessionStopTimeout.
+func waitForSession(ctx context.Context, proc remoteProcess) error {
 	if ctx == nil {
-		return wait()
+		return proc.Wait()
 	}
 
 	done := make(chan error, 1)
 	go func() {
-		done <- wait()
+		done <- proc.Wait()
 	}()
 
 	select {
 	case err := <-done:
 		return err
 	case <-ctx.Done():
-		return ctx.Err()
 	}
+
+	// Not every server supports signals, closing the session is the fallback.
+	_ = proc.Signal(ssh.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(sessionStopTimeout):
+		_ = proc.Close()
+	}
+
+	return ctx.Err()
 }
 
 func handleRunShellError(err error) error {
@@ -507,14 +978,46 @@ func handleRunShellError(err error) error {
 		return nil
 	}
 
-	var exitErr *ssh.ExitError
-	if errors.As(err, &exitErr) {
-		return fmt.Errorf("remote shell exited with status %d: %w", exitErr.ExitStatus(), err)
+	if status, ok := exitStatus(err); ok {
+		return fmt.Errorf("remote shell exited with status %d: %w", status, err)
 	}
 
 	return fmt.Errorf("remote shell error: %w", err)
 }
 
+// containersCommand prints the containers of the project for projectContainers, compose is the compose
+// command of the stack. Swarm projects print their services instead, see swarmStatusCommand.
+func containersCommand(project config.Project, compose string) string {
+	if swarmStrategy(project) {
+		return swarmStatusCommand(project)
+	}
+	return psCommand(project, compose)
+}
+
+// projectContainers parses the output of containersCommand.
+func projectContainers(project config.Project, output string) []ContainerStatus {
+	if swarmStrategy(project) {
+		return parseSwarmServices(project, output)
+	}
+	return parseContainers(output)
+}
+
+// parseContainers parses the output of psCommand: `docker compose ps --format json` output, which is either
+// a JSON array or one JSON object per line depending on the compose version, or the JSON array of inspect.
+// The containers of every runtime are normalized to those of `docker compose ps`.
+func parseContainers(jsonOutput string) []ContainerStatus {
+	var raw []rawContainer
+	if err := json.Unmarshal([]byte(jsonOutput), &raw); err != nil {
+		raw = jsonLines[rawContainer](jsonOutput)
+	}
+
+	var containers []ContainerStatus
+	for _, r := range raw {
+		containers = append(containers, r.normalize())
+	}
+	return containers
+}
+
 func parseDockerTime(s string) (time.Time, error) {
 	layouts := []string{
 		"2006-01-02 15:04:05 -0700 MST",
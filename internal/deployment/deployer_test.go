@@ -3,31 +3,116 @@ package deployment
 import (
 	"context"
 	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/history"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-// Since we switched to crypto/ssh, we can't easily mock the SSH connection
-// without a complex mock server.
-// The previous tests relied on mocking exec.Command which is no longer used.
-//
-// For this iteration, we will test the configuration parsing logic via a helper function
-// that we expose or just by testing the behaviors that don't require network.
-//
-// However, since SSHClient.connect is private and does network I/O, we can't test it easily.
-// We will skip the tests that require actual SSH connection.
+// Tests that need a remote host use the in-process server from ssh_server_test.go.
 
 func TestSSHClient_Parsing(t *testing.T) {
-	// Ideally we would test that host/user/port parsing works.
-	// We can create a test for the parsing logic if we extracted it.
-	// But it's inside `connect` method.
-	//
-	// We'll assume the code is correct for now as we can't unit test it without refactoring
-	// the connection logic out of SSHClient or mocking net.Dial / ssh.Dial.
+	t.Setenv("USER", "local")
+	t.Setenv("HOME", "/home/local")
+
+	ep := resolveEndpoint(config.Project{
+		Host:         "deploy@10.0.1.20:2200",
+		IdentityFile: "~/.ssh/prod_key",
+		ProxyJump: []config.JumpHost{
+			{Host: "ops@bastion.example.com:2222"},
+			{Host: "10.0.0.5", User: "jump", IdentityFile: "/keys/jump"},
+		},
+	}, nil)
+
+	knownHosts := []string{"/home/local/.ssh/known_hosts"}
+	assert.Equal(t, hop{host: "10.0.1.20", user: "deploy", port: "2200", identityFiles: []string{"/home/local/.ssh/prod_key"}, knownHostsFiles: knownHosts}, ep.hop)
+	assert.Equal(t, []hop{
+		{host: "bastion.example.com", user: "ops", port: "2222", identityFiles: []string{"/home/local/.ssh/id_rsa"}, knownHostsFiles: knownHosts},
+		{host: "10.0.0.5", user: "jump", port: "22", identityFiles: []string{"/keys/jump"}, knownHostsFiles: knownHosts},
+	}, ep.jumps)
+	assert.Equal(t, "ops@bastion.example.com:2222>jump@10.0.0.5:22>deploy@10.0.1.20:2200", ep.key())
+
+	direct := resolveEndpoint(config.Project{Host: "example.com", User: "admin", Port: "22"}, nil)
+	assert.Empty(t, direct.jumps)
+	assert.Equal(t, "admin@example.com:22", direct.key())
+
+	// Explicit user and port win over the ones in host
+	explicit := resolveEndpoint(config.Project{Host: "deploy@example.com:2200", User: "admin", Port: "22"}, nil)
+	assert.Equal(t, "admin@example.com:22", explicit.key())
+
+	defaults := resolveEndpoint(config.Project{Host: "ssh://example.com"}, nil)
+	assert.Equal(t, "local@example.com:22", defaults.key())
+}
+
+func TestDialChain_ProxyJump(t *testing.T) {
+	bastion := newTestSSHServer(t, nil)
+	inner := newTestSSHServer(t, nil)
+	target := newTestSSHServer(t, func(cmd string, _ io.Reader, stdout, _ io.Writer, _ <-chan string) int {
+		io.WriteString(stdout, "hello from target")
+		return 0
+	})
+
+	servers := map[string]*testSSHServer{bastion.addr: bastion, inner.addr: inner, target.addr: target}
+	configure := func(h hop) (*ssh.ClientConfig, error) {
+		return servers[h.addr()].clientConfig(), nil
+	}
+
+	client, err := dialChain([]hop{bastion.hop(), inner.hop(), target.hop()}, configure)
+	require.NoError(t, err)
+
+	c := &SSHClient{}
+	var out strings.Builder
+	require.NoError(t, c.runSession(sshExecutor{client}, "uptime", &out, io.Discard, nil))
+	assert.Equal(t, "hello from target", out.String())
+
+	// Each hop only connects to the next one
+	assert.Equal(t, []string{inner.addr}, bastion.forwarded())
+	assert.Equal(t, []string{target.addr}, inner.forwarded())
+	assert.Empty(t, bastion.executed())
+	assert.Empty(t, inner.executed())
+	assert.Equal(t, []string{"uptime"}, target.executed())
+
+	// Losing the bastion drops the tunneled connection too
+	bastion.closeConnections()
+	done := make(chan struct{})
+	go func() {
+		_ = client.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunneled connection still open after bastion closed")
+	}
+}
+
+func TestDialChain_JumpHostUnreachable(t *testing.T) {
+	bastion := newTestSSHServer(t, nil)
+
+	// Reserve a port and close it again, so nothing listens there
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	configure := func(h hop) (*ssh.ClientConfig, error) {
+		return bastion.clientConfig(), nil
+	}
+
+	_, err = dialChain([]hop{bastion.hop(), {host: host, user: "test", port: port}}, configure)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to dial ssh "+net.JoinHostPort(host, port))
 }
 
 func TestParseDockerTime(t *testing.T) {
@@ -77,29 +162,368 @@ func TestHandleRunShellError(t *testing.T) {
 	assert.ErrorIs(t, err, otherErr)
 }
 
+func TestRunShell_UnknownService(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	server := newTestSSHServer(t, func(cmd string, _ io.Reader, stdout, _ io.Writer, _ <-chan string) int {
+		if strings.HasSuffix(cmd, "config --services") {
+			io.WriteString(stdout, "api\ndb\n")
+			return 0
+		}
+		return 127
+	})
+	host, port, _ := net.SplitHostPort(server.addr)
+	project := config.Project{Name: "app", Host: host, Port: port, User: "test", Path: "/srv/app", HostKey: &config.HostKey{Policy: config.HostKeyTOFU}}
+
+	c := NewSSHClient(nil)
+	defer c.Close()
+
+	err := c.RunShell(context.Background(), project, "api /bin/sh; id")
+	assert.EqualError(t, err, `unknown service "api /bin/sh; id", the project has api, db`)
+	assert.Equal(t, []string{detectRuntimeCommand, "cd '/srv/app' && docker compose config --services"}, server.executed())
+}
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, 0, exitCode(nil))
+	assert.Equal(t, -1, exitCode(errors.New("connection reset")))
+}
+
+func TestProjectLocks(t *testing.T) {
+	var locks projectLocks
+
+	assert.NoError(t, locks.tryLock("api", "alice@laptop via tui (deploy)"))
+
+	err := locks.tryLock("api", "bob@ci via api (deploy)")
+	var lockErr *LockError
+	if assert.ErrorAs(t, err, &lockErr) {
+		assert.Equal(t, "alice@laptop via tui (deploy)", lockErr.Holder)
+		assert.Equal(t, "project api is locked by alice@laptop via tui (deploy)", lockErr.Error())
+	}
+
+	// Other projects are independent
+	assert.NoError(t, locks.tryLock("web", "bob@ci via api (deploy)"))
+
+	locks.unlock("api")
+	assert.NoError(t, locks.tryLock("api", "bob@ci via api (deploy)"))
+}
+
+// Failures before the project lock is held are only returned: the API answers them with a status code
+// instead of an event stream that has already started.
+func TestEarlyFailures_EmitNothing(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, listener.Close())
+	unreachable := config.Project{Name: "api", Host: host, Port: port, User: "test", Path: "/srv/api", Repo: "git@github.com:acme/api.git"}
+
+	locked := config.Project{Name: "api", Host: config.LocalHost, Path: t.TempDir(), Repo: "git@github.com:acme/api.git"}
+	require.NoError(t, os.Mkdir(filepath.Join(locked.Path, remoteLockDir), 0o755))
+
+	operations := map[string]func(c *SSHClient, project config.Project, events EventSink) error{
+		history.ActionDeploy: func(c *SSHClient, project config.Project, events EventSink) error {
+			return c.Deploy(context.Background(), project, events, "")
+		},
+		history.ActionRollback: func(c *SSHClient, project config.Project, events EventSink) error {
+			return c.Rollback(context.Background(), project, events)
+		},
+		initPhase: func(c *SSHClient, project config.Project, events EventSink) error {
+			return c.Init(context.Background(), project, events)
+		},
+	}
+	failures := []struct {
+		name    string
+		project config.Project
+	}{
+		{"connection", unreachable},
+		{"lock", locked},
+	}
+
+	for action, operation := range operations {
+		for _, failure := range failures {
+			t.Run(action+" "+failure.name, func(t *testing.T) {
+				c := NewSSHClient(nil)
+				defer c.Close()
+				c.History = history.NewStore(t.TempDir())
+
+				// A rollback target, so rollbacks get as far as connecting.
+				started := time.Now().Add(-time.Hour)
+				for _, commit := range []string{"a", "b"} {
+					started = started.Add(time.Minute)
+					require.NoError(t, c.History.Save(&history.Deployment{Project: "api", Action: history.ActionDeploy, Status: history.StatusSuccess, CommitAfter: commit, StartedAt: started}))
+				}
+
+				sink := &recordingSink{}
+				err := operation(c, failure.project, sink)
+				require.Error(t, err)
+				assert.Empty(t, sink.events)
+
+				var lockErr *LockError
+				assert.Equal(t, failure.name == "lock", errors.As(err, &lockErr))
+
+				// Connection failures of deployments and rollbacks are recorded, lock conflicts are not.
+				deployments, err := c.History.List("api")
+				require.NoError(t, err)
+				recorded := 0
+				for _, d := range deployments {
+					if d.Status == history.StatusFailure {
+						recorded++
+					}
+				}
+				if failure.name == "connection" && action != initPhase {
+					assert.Equal(t, 1, recorded)
+				} else {
+					assert.Zero(t, recorded)
+				}
+			})
+		}
+	}
+}
+
+func TestLock_Remote(t *testing.T) {
+	dir := t.TempDir()
+	project := config.Project{Name: "api", Host: config.LocalHost, Path: dir}
+	lockDir := filepath.Join(dir, remoteLockDir)
+
+	held := &SSHClient{}
+	unlock, err := held.lock(context.Background(), []hostConn{{project: project, client: localExecutor{}}}, "deploy")
+	require.NoError(t, err)
+
+	// Another instance finds the lock held.
+	_, err = (&SSHClient{}).lock(context.Background(), []hostConn{{project: project, client: localExecutor{}}}, "deploy")
+	var lockErr *LockError
+	require.ErrorAs(t, err, &lockErr)
+	assert.Contains(t, lockErr.Holder, "(deploy) since")
+
+	unlock()
+	assert.NoDirExists(t, lockDir)
+}
+
+func TestLock_RefreshesAndTakesOverStaleLocks(t *testing.T) {
+	interval := lockRefreshInterval
+	lockRefreshInterval = 10 * time.Millisecond
+	t.Cleanup(func() { lockRefreshInterval = interval })
+
+	dir := t.TempDir()
+	hosts := []hostConn{{project: config.Project{Name: "api", Host: config.LocalHost, Path: dir}, client: localExecutor{}}}
+	lockDir := filepath.Join(dir, remoteLockDir)
+	old := time.Now().Add(-(staleLockMinutes + 1) * time.Minute)
+
+	// Left over by a goploy process that died: not refreshed for too long.
+	require.NoError(t, os.MkdirAll(lockDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(lockDir, "owner"), []byte("alice@laptop via tui (deploy)\n"), 0o644))
+	require.NoError(t, os.Chtimes(lockDir, old, old))
+
+	unlock, err := (&SSHClient{Trigger: "api"}).lock(context.Background(), hosts, "deploy")
+	require.NoError(t, err)
+	owner, err := os.ReadFile(filepath.Join(lockDir, "owner"))
+	require.NoError(t, err)
+	assert.Contains(t, string(owner), "via api (deploy)")
+
+	// Held locks are refreshed and don't expire.
+	require.NoError(t, os.Chtimes(lockDir, old, old))
+	assert.Eventually(t, func() bool {
+		info, err := os.Stat(lockDir)
+		return err == nil && info.ModTime().After(old.Add(time.Minute))
+	}, 2*time.Second, 10*time.Millisecond)
+
+	unlock()
+	assert.NoDirExists(t, lockDir)
+}
+
+func TestDeploySteps(t *testing.T) {
+	commands := func(steps []step) []string {
+		var out []string
+		for _, s := range steps {
+			out = append(out, s.command)
+		}
+		return out
+	}
+
+	project := config.Project{Name: "api", Path: "/srv/api"}
+	assert.Equal(t, []string{
+		"git fetch --all",
+		"git pull",
+		"docker compose pull",
+		"docker compose up -d --build",
+	}, commands(deploySteps(project, "")))
+
+	project.Hooks = &config.HooksConfig{
+		PreDeploy: []config.HookStep{
+			{Run: "./build-assets.sh"},
+			{Service: "app", Run: "migrate --force"},
+		},
+		PostDeploy: []config.HookStep{
+			{Service: "app", Run: "cache:warm"},
+		},
+	}
+
+	steps := deploySteps(project, "v1.2.0")
+	assert.Equal(t, []string{
+		"git fetch --all",
+		"git checkout 'v1.2.0'",
+		"git pull",
+		"docker compose pull",
+		"./build-assets.sh",
+		"docker compose run --rm 'app' migrate --force",
+		"docker compose up -d --build",
+	}, commands(steps))
+	assert.Equal(t, "pre_deploy 2/2", steps[5].name)
+
+	postSteps := hookSteps("post_deploy", project.Hooks.PostDeploy, "docker compose")
+	assert.Equal(t, []string{"docker compose run --rm 'app' cache:warm"}, commands(postSteps))
+	assert.Equal(t, "post_deploy 1/1", postSteps[0].name)
+}
+
+func TestEvaluateHealth(t *testing.T) {
+	reason, fatal := evaluateHealth(nil)
+	assert.NotEmpty(t, reason)
+	assert.False(t, fatal)
+
+	reason, _ = evaluateHealth([]ContainerStatus{
+		{Name: "web", State: "running", Health: "healthy"},
+		{Name: "worker", State: "running"},
+		{Name: "migrate", State: "exited", ExitCode: 0},
+	})
+	assert.Empty(t, reason)
+
+	reason, fatal = evaluateHealth([]ContainerStatus{
+		{Name: "web", State: "running", Health: "starting"},
+	})
+	assert.Contains(t, reason, "starting")
+	assert.False(t, fatal)
+
+	reason, fatal = evaluateHealth([]ContainerStatus{
+		{Name: "web", State: "restarting", Status: "Restarting (1) 2 seconds ago"},
+	})
+	assert.Contains(t, reason, "restarting")
+	assert.False(t, fatal)
+
+	reason, fatal = evaluateHealth([]ContainerStatus{
+		{Name: "web", State: "running", Health: "unhealthy"},
+	})
+	assert.Contains(t, reason, "unhealthy")
+	assert.True(t, fatal)
+}
+
+func TestHealthCheckError(t *testing.T) {
+	err := &HealthCheckError{Reason: "container web is unhealthy", RolledBackTo: "abc123"}
+	assert.True(t, err.rolledBack())
+	assert.Equal(t, "health check failed: container web is unhealthy (rolled back to abc123)", err.Error())
+
+	rollbackErr := errors.New("checkout failed")
+	err = &HealthCheckError{Reason: "timeout", RolledBackTo: "abc123", RollbackErr: rollbackErr}
+	assert.False(t, err.rolledBack())
+	assert.ErrorIs(t, err, rollbackErr)
+}
+
+func TestParseContainers(t *testing.T) {
+	array := `[{"Name":"web","State":"running","Health":"healthy"}]`
+	assert.Equal(t, []ContainerStatus{{Name: "web", State: "running", Health: "healthy"}}, parseContainers(array))
+
+	lines := "{\"Name\":\"web\",\"State\":\"running\"}\n{\"Name\":\"db\",\"State\":\"exited\",\"ExitCode\":1}\n"
+	containers := parseContainers(lines)
+	if assert.Len(t, containers, 2) {
+		assert.Equal(t, "db", containers[1].Name)
+		assert.Equal(t, 1, containers[1].ExitCode)
+	}
+}
+
+// fakeProcess exits once it receives a signal (if exitOnSignal) or is closed.
+type fakeProcess struct {
+	exitOnSignal bool
+	exit         chan struct{}
+	once         sync.Once
+	signals      []ssh.Signal
+	closed       bool
+	err          error
+}
+
+func (p *fakeProcess) Wait() error {
+	<-p.exit
+	return p.err
+}
+
+func (p *fakeProcess) Signal(sig ssh.Signal) error {
+	p.signals = append(p.signals, sig)
+	if p.exitOnSignal {
+		p.once.Do(func() { close(p.exit) })
+	}
+	return nil
+}
+
+func (p *fakeProcess) Close() error {
+	p.closed = true
+	p.once.Do(func() { close(p.exit) })
+	return nil
+}
+
 func TestWaitForSession(t *testing.T) {
 	waitErr := errors.New("wait failure")
-	err := waitForSession(nil, func() error {
-		return waitErr
-	})
-	assert.Equal(t, waitErr, err)
+	done := &fakeProcess{exit: make(chan struct{}), err: waitErr}
+	close(done.exit)
+	assert.Equal(t, waitErr, waitForSession(nil, done))
+	assert.Equal(t, waitErr, waitForSession(context.Background(), done))
 
 	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	waitCh := make(chan struct{})
 	go func() {
 		time.Sleep(10 * time.Millisecond)
 		cancel()
-		time.Sleep(10 * time.Millisecond)
-		close(waitCh)
 	}()
 
-	err = waitForSession(ctx, func() error {
-		<-waitCh
-		return nil
+	proc := &fakeProcess{exit: make(chan struct{}), exitOnSignal: true}
+	err := waitForSession(ctx, proc)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []ssh.Signal{ssh.SIGTERM}, proc.signals)
+	assert.False(t, proc.closed)
+}
+
+func TestRunSession_CancelSignalsRemoteCommand(t *testing.T) {
+	server := newTestSSHServer(t, func(cmd string, _ io.Reader, stdout, _ io.Writer, signals <-chan string) int {
+		io.WriteString(stdout, "started\n")
+		<-signals
+		return 143
 	})
+
+	client := server.connect(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := &syncBuffer{}
+	go func() {
+		require.Eventually(t, func() bool { return out.String() != "" }, time.Second, 5*time.Millisecond)
+		cancel()
+	}()
+
+	c := &SSHClient{}
+	err := c.runSession(client, "sleep 600", out, io.Discard, ctx)
 	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{"TERM"}, server.signalled())
+}
+
+func TestWithTimeout(t *testing.T) {
+	ctx, cancel, wrap := withTimeout(context.Background(), 0, "deploy")
+	defer cancel()
+	_, hasDeadline := ctx.Deadline()
+	assert.False(t, hasDeadline)
+	assert.Equal(t, assert.AnError, wrap(assert.AnError))
+
+	ctx, cancel, wrap = withTimeout(context.Background(), time.Millisecond, "step")
+	defer cancel()
+	<-ctx.Done()
+	assert.Nil(t, wrap(nil))
+	err := wrap(ctx.Err())
+	assert.EqualError(t, err, "step timed out after 1ms: context deadline exceeded")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// A cancelled parent is not reported as a timeout
+	parent, cancelParent := context.WithCancel(context.Background())
+	cancelParent()
+	ctx, cancel, wrap = withTimeout(parent, time.Hour, "deploy")
+	defer cancel()
+	assert.Equal(t, context.Canceled, wrap(ctx.Err()))
 }
 
 // Note: The following tests are removed/commented out because they relied on
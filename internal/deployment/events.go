@@ -0,0 +1,235 @@
+package deployment
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of a deployment Event.
+type EventType string
+
+const (
+	// EventPhaseStarted is emitted before a phase (fetch, checkout, pull, image pull, up, hooks, health, switch) runs.
+	EventPhaseStarted EventType = "phase_started"
+	// EventPhaseFinished is emitted once a phase is done, Outcome reports whether it failed.
+	EventPhaseFinished EventType = "phase_finished"
+	// EventLog carries a single line of remote command output.
+	EventLog EventType = "log"
+	// EventMessage carries a line of goploy's own progress output.
+	EventMessage EventType = "message"
+	// EventResult is the last event of a deployment or rollback.
+	EventResult EventType = "result"
+)
+
+// Stream tags log lines with the remote stream they were written to.
+type Stream string
+
+const (
+	StreamStdout Stream = "stdout"
+	StreamStderr Stream = "stderr"
+)
+
+// Event is a single step of the progress of a deployment or rollback.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Host is set on the events of multi-host projects.
+	Host string `json:"host,omitempty"`
+	// Phase is the step the event belongs to, for EventResult the action (deploy or rollback).
+	Phase   string `json:"phase,omitempty"`
+	Command string `json:"command,omitempty"`
+	Stream  Stream `json:"stream,omitempty"`
+	// Text is the log line or message, without trailing newline.
+	Text    string   `json:"text,omitempty"`
+	Outcome *Outcome `json:"outcome,omitempty"`
+}
+
+// Outcome is the result of a finished phase or of the whole deployment.
+type Outcome struct {
+	ExitCode int           `json:"exit_code"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	// Skipped marks phases that had nothing to do, e.g. provisioning steps done before.
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// EventSink consumes deployment events. Emit may be called from several goroutines at once.
+type EventSink interface {
+	Emit(event Event)
+}
+
+// EventFunc adapts a func to an EventSink.
+type EventFunc func(event Event)
+
+// Emit calls f(event).
+func (f EventFunc) Emit(event Event) {
+	f(event)
+}
+
+// MultiSink duplicates every event to all sinks.
+func MultiSink(sinks ...EventSink) EventSink {
+	return EventFunc(func(event Event) {
+		for _, s := range sinks {
+			s.Emit(event)
+		}
+	})
+}
+
+// newOutcome describes a phase or deployment that ran since start and ended with err.
+func newOutcome(start time.Time, err error) *Outcome {
+	o := &Outcome{ExitCode: exitCode(err), Duration: time.Since(start)}
+	if err != nil {
+		o.Error = err.Error()
+	}
+	return o
+}
+
+// emitMessage emits a goploy progress message, optionally tagged with a phase.
+func emitMessage(events EventSink, phase string, format string, args ...any) {
+	events.Emit(Event{Type: EventMessage, Time: time.Now(), Phase: phase, Text: fmt.Sprintf(format, args...)})
+}
+
+// TextSink renders events as the plain text log shown in the TUI, the API and notification emails.
+type TextSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewTextSink returns a TextSink writing to w.
+func NewTextSink(w io.Writer) *TextSink {
+	return &TextSink{w: w}
+}
+
+// Emit writes the text form of event, lines of multi-host projects are prefixed with the host.
+func (s *TextSink) Emit(event Event) {
+	line, ok := formatEvent(event)
+	if !ok {
+		return
+	}
+	if event.Host != "" {
+		line = event.Host + " | " + line
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintln(s.w, line)
+}
+
+func formatEvent(event Event) (string, bool) {
+	switch event.Type {
+	case EventPhaseStarted:
+		detail := event.Command
+		if detail == "" {
+			detail = event.Text
+		}
+		return fmt.Sprintf("==> [%s] %s", event.Phase, detail), true
+	case EventPhaseFinished:
+		return fmt.Sprintf("<== [%s] %s", event.Phase, describeOutcome(event.Outcome)), true
+	case EventMessage:
+		if event.Phase != "" {
+			return "    " + event.Text, true
+		}
+		return event.Text, true
+	case EventLog:
+		return event.Text, true
+	case EventResult:
+		o := event.Outcome
+		if o == nil {
+			o = &Outcome{}
+		}
+		if o.Error != "" {
+			return fmt.Sprintf("==> %s failed after %s (exit code %d)", event.Phase, o.Duration.Round(time.Millisecond), o.ExitCode), true
+		}
+		return fmt.Sprintf("==> %s finished in %s", event.Phase, o.Duration.Round(time.Millisecond)), true
+	default:
+		return "", false
+	}
+}
+
+func describeOutcome(o *Outcome) string {
+	if o == nil {
+		return "done"
+	}
+	if o.Error != "" {
+		return fmt.Sprintf("failed after %s: %s", o.Duration.Round(time.Millisecond), o.Error)
+	}
+	if o.Skipped {
+		return "skipped"
+	}
+	return fmt.Sprintf("done in %s", o.Duration.Round(time.Millisecond))
+}
+
+// JSONSink writes every event as a JSON object on its own line (NDJSON).
+type JSONSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONSink returns a JSONSink writing to w.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{enc: json.NewEncoder(w)}
+}
+
+// Emit writes event as a single JSON line.
+func (s *JSONSink) Emit(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Encoding errors are not fatal to the deployment, same as failed writes to a text stream.
+	_ = s.enc.Encode(event)
+}
+
+// logWriter turns remote command output into log events, one per line.
+// Flush emits a trailing line not terminated by a newline.
+type logWriter struct {
+	mu     sync.Mutex
+	events EventSink
+	phase  string
+	stream Stream
+	buf    []byte
+}
+
+func newLogWriter(events EventSink, phase string, stream Stream) *logWriter {
+	return &logWriter{events: events, phase: phase, stream: stream}
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *logWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *logWriter) emit(line []byte) {
+	w.events.Emit(Event{
+		Type:   EventLog,
+		Time:   time.Now(),
+		Phase:  w.phase,
+		Stream: w.stream,
+		Text:   string(bytes.TrimSuffix(line, []byte("\r"))),
+	})
+}
@@ -0,0 +1,161 @@
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingSink collects emitted events.
+type recordingSink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *recordingSink) Emit(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+}
+
+func (s *recordingSink) ofType(t EventType) []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []Event
+	for _, e := range s.events {
+		if e.Type == t {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func TestTextSink(t *testing.T) {
+	var b strings.Builder
+	sink := NewTextSink(&b)
+
+	sink.Emit(Event{Type: EventMessage, Text: "Connected to example.com, project lock acquired."})
+	sink.Emit(Event{Type: EventPhaseStarted, Phase: "fetch", Command: "git fetch --all"})
+	sink.Emit(Event{Type: EventLog, Phase: "fetch", Stream: StreamStdout, Text: "Fetching origin"})
+	sink.Emit(Event{Type: EventPhaseFinished, Phase: "fetch", Outcome: &Outcome{Duration: 1500 * time.Millisecond}})
+	sink.Emit(Event{Type: EventPhaseStarted, Phase: "health", Text: "waiting up to 2m0s for app to become healthy"})
+	sink.Emit(Event{Type: EventMessage, Phase: "health", Text: "container web is still starting, retrying in 5s"})
+	sink.Emit(Event{Type: EventPhaseFinished, Phase: "health", Outcome: &Outcome{ExitCode: -1, Duration: 2 * time.Minute, Error: "container web is unhealthy"}})
+	sink.Emit(Event{Type: EventResult, Phase: "deploy", Outcome: &Outcome{ExitCode: -1, Duration: 3 * time.Minute, Error: "container web is unhealthy"}})
+
+	expected := `Connected to example.com, project lock acquired.
+==> [fetch] git fetch --all
+Fetching origin
+<== [fetch] done in 1.5s
+==> [health] waiting up to 2m0s for app to become healthy
+    container web is still starting, retrying in 5s
+<== [health] failed after 2m0s: container web is unhealthy
+==> deploy failed after 3m0s (exit code -1)
+`
+	assert.Equal(t, expected, b.String())
+}
+
+func TestJSONSink(t *testing.T) {
+	var b strings.Builder
+	sink := NewJSONSink(&b)
+
+	sink.Emit(Event{Type: EventLog, Phase: "up", Stream: StreamStderr, Text: "Container web Started"})
+	sink.Emit(Event{Type: EventResult, Phase: "deploy", Outcome: &Outcome{Duration: time.Second}})
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var event Event
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &event))
+	assert.Equal(t, Event{Type: EventLog, Phase: "up", Stream: StreamStderr, Text: "Container web Started"}, event)
+
+	assert.Contains(t, lines[1], `"outcome":{"exit_code":0,"duration":1000000000}`)
+}
+
+func TestLogWriter(t *testing.T) {
+	sink := &recordingSink{}
+	w := newLogWriter(sink, "pull", StreamStdout)
+
+	io.WriteString(w, "Already up")
+	io.WriteString(w, " to date.\r\nsecond\nthird")
+	assert.Len(t, sink.events, 2)
+
+	w.Flush()
+	require.Len(t, sink.events, 3)
+	assert.Equal(t, "Already up to date.", sink.events[0].Text)
+	assert.Equal(t, "second", sink.events[1].Text)
+	assert.Equal(t, "third", sink.events[2].Text)
+	assert.Equal(t, "pull", sink.events[2].Phase)
+	assert.Equal(t, StreamStdout, sink.events[2].Stream)
+}
+
+func TestRunSteps_Events(t *testing.T) {
+	server := newTestSSHServer(t, func(cmd string, _ io.Reader, stdout, stderr io.Writer, _ <-chan string) int {
+		if strings.HasSuffix(cmd, "docker compose pull") {
+			io.WriteString(stderr, "pull access denied\n")
+			return 18
+		}
+		io.WriteString(stdout, "ok\n")
+		return 0
+	})
+
+	client := server.connect(t)
+
+	steps := []step{
+		{name: "pull", command: "git pull"},
+		{name: "image pull", command: "docker compose pull"},
+		{name: "up", command: "docker compose up -d --build"},
+	}
+
+	sink := &recordingSink{}
+	c := &SSHClient{}
+	err := c.runSteps(context.Background(), client, config.Project{Path: "/srv/app"}, steps, sink)
+	require.Error(t, err)
+	assert.Equal(t, 18, exitCode(err))
+
+	started := sink.ofType(EventPhaseStarted)
+	require.Len(t, started, 2)
+	assert.Equal(t, "docker compose pull", started[1].Command)
+
+	logs := sink.ofType(EventLog)
+	require.Len(t, logs, 2)
+	assert.Equal(t, Event{Type: EventLog, Time: logs[0].Time, Phase: "pull", Stream: StreamStdout, Text: "ok"}, logs[0])
+	assert.Equal(t, Event{Type: EventLog, Time: logs[1].Time, Phase: "image pull", Stream: StreamStderr, Text: "pull access denied"}, logs[1])
+
+	finished := sink.ofType(EventPhaseFinished)
+	require.Len(t, finished, 2)
+	assert.Equal(t, 0, finished[0].Outcome.ExitCode)
+	assert.Equal(t, 18, finished[1].Outcome.ExitCode)
+	assert.NotEmpty(t, finished[1].Outcome.Error)
+}
+
+func TestNewOutcome(t *testing.T) {
+	o := newOutcome(time.Now().Add(-time.Second), nil)
+	assert.Equal(t, 0, o.ExitCode)
+	assert.Empty(t, o.Error)
+	assert.GreaterOrEqual(t, o.Duration, time.Second)
+
+	o = newOutcome(time.Now(), errors.New("connection failed"))
+	assert.Equal(t, -1, o.ExitCode)
+	assert.Equal(t, "connection failed", o.Error)
+}
+
+func TestTextSink_Host(t *testing.T) {
+	var b strings.Builder
+	sink := NewTextSink(&b)
+
+	sink.Emit(Event{Type: EventPhaseStarted, Host: "web1", Phase: "up", Command: "docker compose up -d --build"})
+	sink.Emit(Event{Type: EventLog, Host: "web1", Phase: "up", Stream: StreamStderr})
+
+	assert.Equal(t, "web1 | ==> [up] docker compose up -d --build\nweb1 | \n", b.String())
+}
@@ -0,0 +1,108 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// executor starts the commands of a project on its host: in sessions of an SSH connection (sshExecutor)
+// or, for projects with host: local, as processes of this machine (localExecutor).
+// Commands are POSIX shell command lines, see the shell package.
+type executor interface {
+	start(cmd string, stdin io.Reader, stdout, stderr io.Writer) (remoteProcess, error)
+	// runTerminal runs an interactive command attached to the terminal of goploy.
+	runTerminal(ctx context.Context, cmd string) error
+}
+
+// remoteProcess is a started command, waited for and interrupted by waitForSession.
+// Close releases it, killing the command if it is still running.
+type remoteProcess interface {
+	Wait() error
+	Signal(sig ssh.Signal) error
+	Close() error
+}
+
+// sshExecutor runs every command in a session of its own.
+type sshExecutor struct {
+	client *ssh.Client
+}
+
+func (e sshExecutor) start(cmd string, stdin io.Reader, stdout, stderr io.Writer) (remoteProcess, error) {
+	session, err := e.client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	if err := session.Start(cmd); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+	return session, nil
+}
+
+// runTerminal runs cmd in a pty of the host sized like the terminal, if goploy runs in one.
+func (e sshExecutor) runTerminal(ctx context.Context, cmd string) error {
+	session, err := e.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	defer session.Close()
+
+	// Request PTY
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return fmt.Errorf("failed to make raw terminal: %w", err)
+		}
+		defer term.Restore(fd, state)
+
+		w, h, err := term.GetSize(fd)
+		if err == nil {
+			if err := session.RequestPty("xterm", h, w, ssh.TerminalModes{
+				ssh.ECHO:          1,
+				ssh.TTY_OP_ISPEED: 14400,
+				ssh.TTY_OP_OSPEED: 14400,
+			}); err != nil {
+				return fmt.Errorf("failed to request pty: %w", err)
+			}
+
+			// Handle window resize? (Advanced, skipped for now)
+		}
+	}
+
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	if err := session.Start(cmd); err != nil {
+		return err
+	}
+	return waitForSession(ctx, session)
+}
+
+// exitStatus returns the exit status of a command that ran but failed, over SSH or locally.
+func exitStatus(err error) (int, bool) {
+	var sshErr *ssh.ExitError
+	if errors.As(err, &sshErr) {
+		return sshErr.ExitStatus(), true
+	}
+
+	var execErr *exec.ExitError
+	if errors.As(err, &execErr) && execErr.Exited() {
+		return execErr.ExitCode(), true
+	}
+
+	return 0, false
+}
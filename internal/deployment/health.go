@@ -0,0 +1,189 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/shell"
+)
+
+const (
+	defaultHealthTimeout  = 2 * time.Minute
+	defaultHealthInterval = 5 * time.Second
+	healthProbeTimeout    = 5 * time.Second
+
+	healthPhase = "health"
+)
+
+// HealthCheckError is returned by Deploy if the project did not become healthy after `up`.
+// RolledBackTo holds the commit that was restored, empty if no revert was possible.
+type HealthCheckError struct {
+	Reason       string
+	RolledBackTo string
+	RollbackErr  error
+}
+
+func (e *HealthCheckError) Error() string {
+	switch {
+	case e.RollbackErr != nil:
+		return fmt.Sprintf("health check failed: %s (rollback to %s failed: %v)", e.Reason, e.RolledBackTo, e.RollbackErr)
+	case e.RolledBackTo != "":
+		return fmt.Sprintf("health check failed: %s (rolled back to %s)", e.Reason, e.RolledBackTo)
+	default:
+		return fmt.Sprintf("health check failed: %s", e.Reason)
+	}
+}
+
+func (e *HealthCheckError) Unwrap() error {
+	return e.RollbackErr
+}
+
+// rolledBack reports whether the previous commit was restored successfully.
+func (e *HealthCheckError) rolledBack() bool {
+	return e.RolledBackTo != "" && e.RollbackErr == nil
+}
+
+// evaluateHealth checks container states and Docker healthcheck results.
+// It returns an empty reason if all containers are healthy, fatal is set if waiting longer won't help.
+func evaluateHealth(containers []ContainerStatus) (reason string, fatal bool) {
+	if len(containers) == 0 {
+		return "no containers found", false
+	}
+
+	for _, c := range containers {
+		state := strings.ToLower(c.State)
+		health := strings.ToLower(c.Health)
+
+		switch {
+		case state == "running" && health == "unhealthy":
+			return fmt.Sprintf("container %s is unhealthy", c.Name), true
+		case state == "running" && health == "starting":
+			return fmt.Sprintf("container %s is still starting", c.Name), false
+		case state == "running":
+			continue
+		case state == "exited" && c.ExitCode == 0:
+			// One-off containers (e.g. migrations) are allowed to finish.
+			continue
+		default:
+			return fmt.Sprintf("container %s is %s (%s)", c.Name, state, c.Status), false
+		}
+	}
+
+	return "", false
+}
+
+// waitHealthy polls the project containers (and the optional URL) until they are healthy or the timeout is reached.
+// It is reported as the "health" phase, compose is the docker compose command of the stack to check.
+func (c *SSHClient) waitHealthy(ctx context.Context, client executor, project config.Project, compose string, events EventSink) error {
+	check := project.HealthCheck
+
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+	interval := check.Interval
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+
+	start := time.Now()
+	events.Emit(Event{Type: EventPhaseStarted, Time: start, Phase: healthPhase, Text: fmt.Sprintf("waiting up to %s for %s to become healthy", timeout, project.Name)})
+
+	err := c.pollHealth(ctx, client, project, compose, timeout, interval, events)
+
+	events.Emit(Event{Type: EventPhaseFinished, Time: time.Now(), Phase: healthPhase, Outcome: newOutcome(start, err)})
+	return err
+}
+
+func (c *SSHClient) pollHealth(ctx context.Context, client executor, project config.Project, compose string, timeout, interval time.Duration, events EventSink) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		reason, fatal := c.checkHealth(ctx, client, project, compose)
+		if reason == "" {
+			return nil
+		}
+		if fatal || time.Now().Add(interval).After(deadline) {
+			return errors.New(reason)
+		}
+
+		emitMessage(events, healthPhase, "%s, retrying in %s", reason, interval)
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *SSHClient) checkHealth(ctx context.Context, client executor, project config.Project, compose string) (string, bool) {
+	var b strings.Builder
+	remoteCommand := shell.InDir(workDir(project), containersCommand(project, compose))
+	if err := c.runSession(client, remoteCommand, &b, io.Discard, ctx); err != nil {
+		return fmt.Sprintf("failed to read container status: %v", err), false
+	}
+
+	if reason, fatal := evaluateHealth(projectContainers(project, strings.TrimSpace(b.String()))); reason != "" {
+		return reason, fatal
+	}
+
+	if project.HealthCheck.URL != "" {
+		if err := probeURL(ctx, project.HealthCheck.URL); err != nil {
+			return err.Error(), false
+		}
+	}
+
+	return "", false
+}
+
+// probeURL performs a GET request against url, any status below 400 counts as healthy.
+func probeURL(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("invalid health check url: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("health check url %s unreachable: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("health check url %s returned status %d", url, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// revert restores the given commit after a failed health check, on the checked out branch (see versionStep).
+func (c *SSHClient) revert(ctx context.Context, client executor, project config.Project, commit string, reason string, events EventSink) error {
+	healthErr := &HealthCheckError{Reason: reason}
+	if commit == "" {
+		emitMessage(events, "", "Previous commit unknown, not rolling back.")
+		return healthErr
+	}
+
+	healthErr.RolledBackTo = commit
+	emitMessage(events, "", "Project did not become healthy, rolling back to %s...", commit)
+
+	restore := versionStep(project, commit)
+	restore.name = "revert " + restore.name
+
+	steps := []step{
+		restore,
+		{name: "revert up", command: upCommand(project, composeCommand(project))},
+	}
+	healthErr.RollbackErr = c.runSteps(ctx, client, project, steps, events)
+
+	return healthErr
+}
@@ -0,0 +1,85 @@
+package deployment
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/history"
+	"github.com/pmaojo/goploy/internal/shell"
+)
+
+// startRecord stores a running deployment record, returns nil if no history store is configured.
+func (c *SSHClient) startRecord(project config.Project, action string, ref string, events EventSink) *history.Deployment {
+	if c.History == nil {
+		return nil
+	}
+
+	record := &history.Deployment{
+		Project:     project.Name,
+		Action:      action,
+		Ref:         ref,
+		TriggeredBy: c.Trigger,
+		StartedAt:   time.Now().UTC(),
+		Status:      history.StatusRunning,
+	}
+
+	if err := c.History.Save(record); err != nil {
+		emitMessage(events, "", "Failed to record deployment history: %v", err)
+	}
+
+	return record
+}
+
+// finishRecord finalizes a deployment record started by startRecord.
+func (c *SSHClient) finishRecord(record *history.Deployment, deployErr error, log string, events EventSink) {
+	if record == nil {
+		return
+	}
+
+	record.FinishedAt = time.Now().UTC()
+	record.Output = log
+	record.ExitCode = exitCode(deployErr)
+	record.Status = history.StatusSuccess
+	if deployErr != nil {
+		record.Status = history.StatusFailure
+		record.Error = deployErr.Error()
+	}
+
+	if err := c.History.Save(record); err != nil {
+		emitMessage(events, "", "Failed to record deployment history: %v", err)
+	}
+}
+
+// recordFailure records a deployment that failed before the project lock was acquired. Nothing is emitted
+// until then, a failure to record it is dropped.
+func (c *SSHClient) recordFailure(project config.Project, action string, ref string, deployErr error) {
+	discard := EventFunc(func(Event) {})
+	c.finishRecord(c.startRecord(project, action, ref, discard), deployErr, "", discard)
+}
+
+// headCommit resolves the commit currently checked out on the remote, empty if it can't be determined.
+// For image projects it is the deployed image tag.
+func (c *SSHClient) headCommit(ctx context.Context, client executor, project config.Project) string {
+	var b strings.Builder
+	cmd := shell.InDir(project.Path, versionCommand(project))
+	if err := c.runSession(client, cmd, &b, io.Discard, ctx); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// exitCode maps a remote command error onto a process exit code, -1 if none is available.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	if status, ok := exitStatus(err); ok {
+		return status
+	}
+
+	return -1
+}
@@ -0,0 +1,439 @@
+package deployment
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Status values of a ScannedHostKey.
+const (
+	HostKeyKnown    = "known"
+	HostKeyUnknown  = "unknown"
+	HostKeyMismatch = "mismatch"
+)
+
+// UnknownHostKeyError is returned when a host presents a key not listed in known_hosts and the
+// host key policy doesn't allow to trust it, or the user has to confirm it first (see SSHClient.HostKeyPrompt).
+type UnknownHostKeyError struct {
+	Host string
+	Key  ssh.PublicKey
+}
+
+func (e *UnknownHostKeyError) Error() string {
+	return fmt.Sprintf("host key of %s is unknown (%s %s), run `goploy hosts trust %s` or use the tofu host key policy",
+		e.Host, e.Key.Type(), ssh.FingerprintSHA256(e.Key), e.Host)
+}
+
+// HostKeyMismatchError is returned when a host presents another key than the one in known_hosts or pinned in goploy.yaml.
+type HostKeyMismatchError struct {
+	Host      string
+	Presented ssh.PublicKey
+	// Expected lists the fingerprints of the trusted keys and where they come from.
+	Expected []string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("HOST KEY MISMATCH for %s: it presented %s %s, expected %s. The host may have been reinstalled "+
+		"or the connection is being intercepted, verify the new key before running `goploy hosts forget %s`",
+		e.Host, e.Presented.Type(), ssh.FingerprintSHA256(e.Presented), strings.Join(e.Expected, ", "), e.Host)
+}
+
+// hostKeyConfig returns the host key verification of a hop: the callback checking the presented key and
+// the host key algorithms to negotiate, preferring the types of the known keys like OpenSSH does.
+// unknown is called for keys the known_hosts files don't list for the host.
+func hostKeyConfig(h hop, unknown func(hostname string, key ssh.PublicKey) error) (ssh.HostKeyCallback, []string, error) {
+	switch h.hostKeyPolicy {
+	case config.HostKeyPinned:
+		pinned := normalizeFingerprint(h.fingerprint)
+		return func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+			if ssh.FingerprintSHA256(key) != pinned {
+				return &HostKeyMismatchError{Host: knownhosts.Normalize(hostname), Presented: key, Expected: []string{pinned + " (pinned in goploy.yaml)"}}
+			}
+			return nil
+		}, nil, nil
+	case "", config.HostKeyStrict, config.HostKeyTOFU:
+	default:
+		return nil, nil, fmt.Errorf("unknown host key policy %q", h.hostKeyPolicy)
+	}
+
+	known, err := loadKnownHosts(h.knownHostsFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	callback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if known != nil {
+			err := known(hostname, remote, key)
+			var keyErr *knownhosts.KeyError
+			switch {
+			case err == nil:
+				return nil
+			case !errors.As(err, &keyErr):
+				return err
+			case len(keyErr.Want) > 0:
+				return mismatchError(hostname, key, keyErr.Want)
+			}
+		}
+		return unknown(hostname, key)
+	}
+	return callback, knownKeyAlgorithms(known, h.addr()), nil
+}
+
+// loadKnownHosts parses the known_hosts files that exist, nil if there are none.
+func loadKnownHosts(files []string) (ssh.HostKeyCallback, error) {
+	var existing []string
+	for _, f := range files {
+		if _, err := os.Stat(f); err == nil {
+			existing = append(existing, f)
+		}
+	}
+	if len(existing) == 0 {
+		return nil, nil
+	}
+
+	known, err := knownhosts.New(existing...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts: %w", err)
+	}
+	return known, nil
+}
+
+func mismatchError(hostname string, key ssh.PublicKey, want []knownhosts.KnownKey) error {
+	e := &HostKeyMismatchError{Host: knownhosts.Normalize(hostname), Presented: key}
+	for _, k := range want {
+		e.Expected = append(e.Expected, fmt.Sprintf("%s %s (%s:%d)", k.Key.Type(), ssh.FingerprintSHA256(k.Key), k.Filename, k.Line))
+	}
+	return e
+}
+
+// knownKeyAlgorithms returns the host key algorithms matching the keys known for addr, nil (the defaults) if none are known.
+// Otherwise a host known with an RSA key that prefers Ed25519 would be reported as mismatch.
+func knownKeyAlgorithms(known ssh.HostKeyCallback, addr string) []string {
+	if known == nil {
+		return nil
+	}
+
+	// A fresh key is never known, the error lists the keys that are.
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil
+	}
+	probe, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(known(addr, unspecifiedAddr, probe), &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	for _, k := range keyErr.Want {
+		algos := []string{k.Key.Type()}
+		if k.Key.Type() == ssh.KeyAlgoRSA {
+			algos = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+		}
+		for _, a := range algos {
+			if !slices.Contains(algorithms, a) {
+				algorithms = append(algorithms, a)
+			}
+		}
+	}
+	return algorithms
+}
+
+// addKnownHost appends key for hostname to the known_hosts file, creating it if needed.
+func addKnownHost(file, hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	return err
+}
+
+// normalizeFingerprint accepts SHA256 fingerprints with or without the SHA256: prefix.
+func normalizeFingerprint(fingerprint string) string {
+	if strings.HasPrefix(fingerprint, "SHA256:") {
+		return fingerprint
+	}
+	return "SHA256:" + fingerprint
+}
+
+// confirmHostKey asks the user to trust the key of an unknown host and adds it to known_hosts if accepted.
+// Prompts are serialized, a key accepted meanwhile is not asked for again.
+func (c *SSHClient) confirmHostKey(ctx context.Context, h hop, unknown *UnknownHostKeyError) error {
+	c.promptMu.Lock()
+	defer c.promptMu.Unlock()
+
+	known, err := loadKnownHosts(h.knownHostsFiles)
+	if err != nil {
+		return err
+	}
+	if status, err := keyStatus(known, h.addr(), unknown.Key); err == nil && status == HostKeyKnown {
+		return nil
+	}
+
+	if !c.HostKeyPrompt(ctx, unknown.Host, unknown.Key.Type(), ssh.FingerprintSHA256(unknown.Key)) {
+		return fmt.Errorf("host key of %s (%s) not trusted", unknown.Host, ssh.FingerprintSHA256(unknown.Key))
+	}
+	return addKnownHost(h.knownHostsFiles[0], h.addr(), unknown.Key)
+}
+
+// ScannedHostKey is a host key presented by a server.
+type ScannedHostKey struct {
+	Type        string
+	Fingerprint string
+	// Status compares the key with known_hosts: known, unknown or mismatch.
+	Status string
+
+	key ssh.PublicKey
+}
+
+// scanAlgorithms are requested one by one to collect every key type of a host.
+var scanAlgorithms = []string{ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521, ssh.KeyAlgoRSASHA512}
+
+var errScanned = errors.New("host key scanned")
+
+// unspecifiedAddr is passed to known_hosts checks outside of a handshake, they require a TCP address but match the hostname only.
+var unspecifiedAddr net.Addr = &net.TCPAddr{IP: net.IPv4zero}
+
+// ScanHostKeys fetches the host keys of host ([user@]host[:port] or an ssh_config alias) like ssh-keyscan,
+// without authenticating. Jump hosts have to be known. It returns the known_hosts name of the host.
+func ScanHostKeys(host string) (string, []ScannedHostKey, error) {
+	ep, err := hostEndpoint(host)
+	if err != nil {
+		return "", nil, err
+	}
+	keys, err := scanEndpoint(ep)
+	return knownhosts.Normalize(ep.addr()), keys, err
+}
+
+func scanEndpoint(ep endpoint) ([]ScannedHostKey, error) {
+	known, err := loadKnownHosts(ep.knownHostsFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []ScannedHostKey
+	var lastErr error
+	for _, algorithm := range scanAlgorithms {
+		var scanned ssh.PublicKey
+		_, err := dialChain(append(ep.jumps, ep.hop), func(h hop) (*ssh.ClientConfig, error) {
+			if h.addr() != ep.addr() {
+				return clientConfig(h, strictUnknown)
+			}
+			return &ssh.ClientConfig{
+				User:              h.user,
+				HostKeyAlgorithms: []string{algorithm},
+				HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+					scanned = key
+					return errScanned
+				},
+				Timeout: clientTimeout,
+			}, nil
+		})
+		if scanned == nil {
+			// The host has no key of this type.
+			lastErr = err
+			continue
+		}
+
+		status, err := keyStatus(known, ep.addr(), scanned)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, ScannedHostKey{Type: scanned.Type(), Fingerprint: ssh.FingerprintSHA256(scanned), Status: status, key: scanned})
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("failed to scan host keys: %w", lastErr)
+	}
+	return keys, nil
+}
+
+// keyStatus compares a key presented by addr with the known_hosts entries.
+func keyStatus(known ssh.HostKeyCallback, addr string, key ssh.PublicKey) (string, error) {
+	if known == nil {
+		return HostKeyUnknown, nil
+	}
+
+	err := known(addr, unspecifiedAddr, key)
+	var keyErr *knownhosts.KeyError
+	switch {
+	case err == nil:
+		return HostKeyKnown, nil
+	case !errors.As(err, &keyErr):
+		return "", err
+	}
+
+	for _, k := range keyErr.Want {
+		if k.Key.Type() == key.Type() {
+			return HostKeyMismatch, nil
+		}
+	}
+	return HostKeyUnknown, nil
+}
+
+// TrustHostKeys adds the unknown keys of host to known_hosts, only the key with fingerprint if given.
+// Hosts presenting another key than the known one have to be forgotten first. It returns the added keys.
+func TrustHostKeys(host, fingerprint string) ([]ScannedHostKey, error) {
+	ep, err := hostEndpoint(host)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := scanEndpoint(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []ScannedHostKey
+	for _, k := range keys {
+		if fingerprint != "" && k.Fingerprint != normalizeFingerprint(fingerprint) {
+			continue
+		}
+		switch k.Status {
+		case HostKeyMismatch:
+			return added, fmt.Errorf("%s presents another %s key than known, run `goploy hosts forget %s` first", host, k.Type, host)
+		case HostKeyUnknown:
+			if err := addKnownHost(ep.knownHostsFiles[0], ep.addr(), k.key); err != nil {
+				return added, err
+			}
+			added = append(added, k)
+		}
+	}
+
+	if fingerprint != "" && !slices.ContainsFunc(keys, func(k ScannedHostKey) bool { return k.Fingerprint == normalizeFingerprint(fingerprint) }) {
+		return nil, fmt.Errorf("%s presents no key with fingerprint %s", host, normalizeFingerprint(fingerprint))
+	}
+	return added, nil
+}
+
+// ForgetHostKeys removes the entries of host from the known_hosts files, hashed ones included.
+// It returns the number of removed entries.
+func ForgetHostKeys(host string) (int, error) {
+	ep, err := hostEndpoint(host)
+	if err != nil {
+		return 0, err
+	}
+
+	name := knownhosts.Normalize(ep.addr())
+	removed := 0
+	for _, file := range ep.knownHostsFiles {
+		n, err := removeKnownHost(file, name)
+		if err != nil {
+			return removed, err
+		}
+		removed += n
+	}
+	return removed, nil
+}
+
+// removeKnownHost rewrites file without the lines listing name, a missing file has none.
+func removeKnownHost(file, name string) (int, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var kept strings.Builder
+	removed := 0
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if knownHostsLineMatches(line, name) {
+			removed++
+			continue
+		}
+		kept.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+	return removed, os.WriteFile(file, []byte(kept.String()), info.Mode().Perm())
+}
+
+// knownHostsLineMatches reports whether a known_hosts line lists name, plainly or hashed. Markers are skipped.
+func knownHostsLineMatches(line, name string) bool {
+	fields := strings.Fields(line)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return false
+	}
+
+	for _, pattern := range strings.Split(fields[0], ",") {
+		if pattern == name || hashedHostMatches(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// hashedHostMatches checks a |1|salt|hash entry as written with HashKnownHosts.
+func hashedHostMatches(pattern, name string) bool {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 || parts[0] != "" || parts[1] != "1" {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(name))
+	return hmac.Equal(mac.Sum(nil), hash)
+}
+
+// hostEndpoint resolves a host given on the command line through ssh_config.
+func hostEndpoint(host string) (endpoint, error) {
+	sshCfg, err := userSSHConfig()
+	if err != nil {
+		return endpoint{}, fmt.Errorf("failed to read ssh config: %w", err)
+	}
+	return resolveEndpoint(config.Project{Host: host}, sshCfg), nil
+}
+
+// strictUnknown rejects keys not listed in known_hosts.
+func strictUnknown(hostname string, key ssh.PublicKey) error {
+	return &UnknownHostKeyError{Host: knownhosts.Normalize(hostname), Key: key}
+}
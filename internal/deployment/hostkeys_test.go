@@ -0,0 +1,243 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var testRemote = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 22}
+
+func TestHostKeyConfig_KnownHosts(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".ssh", "known_hosts")
+	h := hop{host: "web1", port: "22", knownHostsFiles: []string{file}}
+	key := newPublicKey(t)
+
+	// Without known_hosts the unknown callback decides.
+	callback, algorithms, err := hostKeyConfig(h, strictUnknown)
+	require.NoError(t, err)
+	assert.Nil(t, algorithms)
+	err = callback("web1:22", testRemote, key)
+	var unknown *UnknownHostKeyError
+	require.ErrorAs(t, err, &unknown)
+	assert.Equal(t, "web1", unknown.Host)
+	assert.Contains(t, err.Error(), ssh.FingerprintSHA256(key))
+
+	trust := func(hostname string, k ssh.PublicKey) error { return addKnownHost(file, hostname, k) }
+	callback, _, err = hostKeyConfig(h, trust)
+	require.NoError(t, err)
+	require.NoError(t, callback("web1:22", testRemote, key))
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "web1 ssh-ed25519 "))
+
+	// Known now, a different key is a mismatch showing both fingerprints.
+	callback, algorithms, err = hostKeyConfig(h, trust)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ssh.KeyAlgoED25519}, algorithms)
+	require.NoError(t, callback("web1:22", testRemote, key))
+
+	other := newPublicKey(t)
+	err = callback("web1:22", testRemote, other)
+	var mismatch *HostKeyMismatchError
+	require.ErrorAs(t, err, &mismatch)
+	assert.Contains(t, err.Error(), "HOST KEY MISMATCH for web1")
+	assert.Contains(t, err.Error(), ssh.FingerprintSHA256(other))
+	assert.Contains(t, err.Error(), ssh.FingerprintSHA256(key)+" ("+file+":1)")
+}
+
+func TestHostKeyConfig_Pinned(t *testing.T) {
+	key := newPublicKey(t)
+	h := hop{host: "web1", port: "22", hostKeyPolicy: config.HostKeyPinned, fingerprint: strings.TrimPrefix(ssh.FingerprintSHA256(key), "SHA256:")}
+
+	callback, _, err := hostKeyConfig(h, strictUnknown)
+	require.NoError(t, err)
+	require.NoError(t, callback("web1:22", testRemote, key))
+
+	err = callback("web1:22", testRemote, newPublicKey(t))
+	var mismatch *HostKeyMismatchError
+	require.ErrorAs(t, err, &mismatch)
+	assert.Equal(t, []string{ssh.FingerprintSHA256(key) + " (pinned in goploy.yaml)"}, mismatch.Expected)
+
+	_, _, err = hostKeyConfig(hop{hostKeyPolicy: "sometimes"}, strictUnknown)
+	assert.EqualError(t, err, `unknown host key policy "sometimes"`)
+}
+
+func TestUnknownHostKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	tofu := hop{host: "web1", port: "22", hostKeyPolicy: config.HostKeyTOFU, knownHostsFiles: []string{file}}
+	key := newPublicKey(t)
+
+	var unknown *UnknownHostKeyError
+	assert.ErrorAs(t, (&SSHClient{}).unknownHostKey(hop{knownHostsFiles: []string{file}})("web1", key), &unknown)
+
+	// With a prompt, tofu keys are confirmed by connect.
+	prompting := &SSHClient{HostKeyPrompt: func(context.Context, string, string, string) bool { return true }}
+	assert.ErrorAs(t, prompting.unknownHostKey(tofu)("web1", key), &unknown)
+
+	require.NoError(t, (&SSHClient{}).unknownHostKey(tofu)("web1", key))
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, knownhosts.Line([]string{"web1"}, key)+"\n", string(data))
+}
+
+func TestConnect_PromptsForUnknownHostKey(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	server := newTestSSHServer(t, func(string, io.Reader, io.Writer, io.Writer, <-chan string) int { return 0 })
+	host, port, _ := net.SplitHostPort(server.addr)
+	project := config.Project{Name: "app", Host: host, Port: port, User: "test", HostKey: &config.HostKey{Policy: config.HostKeyTOFU}}
+
+	var prompts []string
+	accept := false
+	c := &SSHClient{HostKeyPrompt: func(_ context.Context, host, keyType, fingerprint string) bool {
+		prompts = append(prompts, host+" "+keyType+" "+fingerprint)
+		return accept
+	}}
+	defer c.Close()
+
+	fingerprint := ssh.FingerprintSHA256(server.hostKey.PublicKey())
+	_, _, err := c.connect(context.Background(), project)
+	assert.EqualError(t, err, "host key of "+knownhosts.Normalize(server.addr)+" ("+fingerprint+") not trusted")
+
+	accept = true
+	_, release, err := c.connect(context.Background(), project)
+	require.NoError(t, err)
+	release()
+
+	expected := knownhosts.Normalize(server.addr) + " ssh-ed25519 " + fingerprint
+	assert.Equal(t, []string{expected, expected}, prompts)
+
+	status, err := keyStatusFromFile(filepath.Join(home, ".ssh", "known_hosts"), server.addr, server.hostKey.PublicKey())
+	require.NoError(t, err)
+	assert.Equal(t, HostKeyKnown, status)
+}
+
+func TestDialChain_UnknownJumpHostKey(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	bastion := newTestSSHServer(t, nil)
+	target := newTestSSHServer(t, func(string, io.Reader, io.Writer, io.Writer, <-chan string) int { return 0 })
+	host, port, _ := net.SplitHostPort(target.addr)
+	project := config.Project{
+		Name:      "app",
+		Host:      host,
+		Port:      port,
+		User:      "test",
+		ProxyJump: []config.JumpHost{{Host: "test@" + bastion.addr}},
+		HostKey:   &config.HostKey{Policy: config.HostKeyTOFU},
+	}
+
+	var prompts []string
+	c := &SSHClient{HostKeyPrompt: func(_ context.Context, host, _, _ string) bool {
+		prompts = append(prompts, host)
+		return true
+	}}
+	defer c.Close()
+
+	// The jump host is confirmed first, the target once it can be reached through it.
+	_, release, err := c.connect(context.Background(), project)
+	require.NoError(t, err)
+	release()
+	assert.Equal(t, []string{knownhosts.Normalize(bastion.addr), knownhosts.Normalize(target.addr)}, prompts)
+	assert.Contains(t, bastion.forwarded(), target.addr)
+
+	// Each key is recorded under the address of the host presenting it.
+	file := filepath.Join(home, ".ssh", "known_hosts")
+	for _, server := range []*testSSHServer{bastion, target} {
+		status, err := keyStatusFromFile(file, server.addr, server.hostKey.PublicKey())
+		require.NoError(t, err)
+		assert.Equal(t, HostKeyKnown, status, server.addr)
+	}
+}
+
+func keyStatusFromFile(file, addr string, key ssh.PublicKey) (string, error) {
+	known, err := loadKnownHosts([]string{file})
+	if err != nil {
+		return "", err
+	}
+	return keyStatus(known, addr, key)
+}
+
+func TestScanEndpoint(t *testing.T) {
+	server := newTestSSHServer(t, func(string, io.Reader, io.Writer, io.Writer, <-chan string) int { return 0 })
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	h := server.hop()
+	h.knownHostsFiles = []string{file}
+	key := server.hostKey.PublicKey()
+
+	keys, err := scanEndpoint(endpoint{hop: h})
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, ssh.KeyAlgoED25519, keys[0].Type)
+	assert.Equal(t, ssh.FingerprintSHA256(key), keys[0].Fingerprint)
+	assert.Equal(t, HostKeyUnknown, keys[0].Status)
+
+	require.NoError(t, addKnownHost(file, server.addr, key))
+	keys, err = scanEndpoint(endpoint{hop: h})
+	require.NoError(t, err)
+	assert.Equal(t, HostKeyKnown, keys[0].Status)
+
+	require.NoError(t, os.WriteFile(file, []byte(knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, newPublicKey(t))+"\n"), 0o600))
+	keys, err = scanEndpoint(endpoint{hop: h})
+	require.NoError(t, err)
+	assert.Equal(t, HostKeyMismatch, keys[0].Status)
+}
+
+func TestRemoveKnownHost(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	key := newPublicKey(t)
+	other := knownhosts.Line([]string{"db1"}, key)
+	lines := []string{
+		"# managed by hand",
+		knownhosts.Line([]string{"web1"}, key),
+		other,
+		knownhosts.Line([]string{knownhosts.HashHostname("web1")}, key),
+		"@cert-authority " + knownhosts.Line([]string{"web1,web1.example.com"}, key),
+		knownhosts.Line([]string{"[web1]:2222"}, key),
+	}
+	require.NoError(t, os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0o644))
+
+	removed, err := removeKnownHost(file, "web1")
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "# managed by hand\n"+other+"\n"+knownhosts.Line([]string{"[web1]:2222"}, key)+"\n", string(data))
+
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	removed, err = removeKnownHost(filepath.Join(t.TempDir(), "missing"), "web1")
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+}
+
+func TestHostKeyMismatchError(t *testing.T) {
+	key := newPublicKey(t)
+	err := error(&HostKeyMismatchError{Host: "web1", Presented: key, Expected: []string{"ssh-ed25519 SHA256:abc (known_hosts:3)"}})
+
+	var mismatch *HostKeyMismatchError
+	assert.True(t, errors.As(err, &mismatch))
+	assert.Equal(t, "HOST KEY MISMATCH for web1: it presented ssh-ed25519 "+ssh.FingerprintSHA256(key)+
+		", expected ssh-ed25519 SHA256:abc (known_hosts:3). The host may have been reinstalled or the connection is being "+
+		"intercepted, verify the new key before running `goploy hosts forget web1`", err.Error())
+}
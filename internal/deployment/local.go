@@ -0,0 +1,142 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/mailer"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// LocalClient implements Controller for projects on the machine goploy runs on, selected with host: local
+// (see config.LocalHost). It runs the command pipelines of SSHClient (deployments, rollbacks, hooks, health
+// checks, status, logs and shells) through os/exec instead of SSH sessions, whatever host the projects name.
+// SSHClient hands its projects with host: local to the same executor, so one controller serves both.
+type LocalClient struct {
+	SSHClient
+}
+
+var _ Controller = (*LocalClient)(nil)
+
+// NewLocalClient creates a new LocalClient.
+func NewLocalClient(mailer *mailer.Mailer) *LocalClient {
+	return &LocalClient{SSHClient: SSHClient{Mailer: mailer, local: true}}
+}
+
+// localShell runs the command lines, like the login shell of a host would.
+const localShell = "/bin/sh"
+
+// localExecutor runs every command in a shell process of its own.
+type localExecutor struct{}
+
+func (localExecutor) start(cmd string, stdin io.Reader, stdout, stderr io.Writer) (remoteProcess, error) {
+	command := exec.Command(localShell, "-c", cmd)
+	command.Stdin = stdin
+	command.Stdout = stdout
+	command.Stderr = stderr
+	command.SysProcAttr = processGroupAttr()
+	command.WaitDelay = sessionStopTimeout
+
+	if err := command.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+	return &localProcess{cmd: command}, nil
+}
+
+// runTerminal runs cmd attached to the terminal of goploy. Like the pty of SSH sessions, it gets a
+// pseudo terminal of its own, the keys typed (Ctrl+C included) are passed to it in raw mode.
+// Without a terminal, or where no pty can be opened, the standard streams are passed on as they are.
+func (e localExecutor) runTerminal(ctx context.Context, cmd string) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return e.run(ctx, cmd, os.Stdin, os.Stdout, os.Stderr)
+	}
+
+	pty, tty, err := openPTY()
+	if errors.Is(err, errors.ErrUnsupported) {
+		return e.run(ctx, cmd, os.Stdin, os.Stdout, os.Stderr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open pty: %w", err)
+	}
+	defer pty.Close()
+
+	if w, h, err := term.GetSize(fd); err == nil {
+		_ = setPTYSize(pty, w, h)
+	}
+
+	command := exec.Command(localShell, "-c", cmd)
+	command.Stdin = tty
+	command.Stdout = tty
+	command.Stderr = tty
+	command.SysProcAttr = terminalSessionAttr()
+
+	err = command.Start()
+	tty.Close()
+	if err != nil {
+		return fmt.Errorf("failed to start command: %w", err)
+	}
+	proc := &localProcess{cmd: command}
+	defer proc.Close()
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("failed to make raw terminal: %w", err)
+	}
+	defer term.Restore(fd, state)
+
+	go func() { _, _ = io.Copy(pty, os.Stdin) }()
+	output := make(chan struct{})
+	go func() {
+		// Reading fails once the shell and everything it started closed the tty.
+		_, _ = io.Copy(os.Stdout, pty)
+		close(output)
+	}()
+
+	err = waitForSession(ctx, proc)
+	select {
+	case <-output:
+	case <-time.After(sessionStopTimeout):
+	}
+	return err
+}
+
+func (e localExecutor) run(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) error {
+	proc, err := e.start(cmd, stdin, stdout, stderr)
+	if err != nil {
+		return err
+	}
+	defer proc.Close()
+
+	return waitForSession(ctx, proc)
+}
+
+// localProcess is a started shell, signalled along with its process group.
+type localProcess struct {
+	cmd    *exec.Cmd
+	exited atomic.Bool
+}
+
+func (p *localProcess) Wait() error {
+	err := p.cmd.Wait()
+	p.exited.Store(true)
+	return err
+}
+
+func (p *localProcess) Signal(sig ssh.Signal) error {
+	return signalProcessGroup(p.cmd.Process, sig)
+}
+
+func (p *localProcess) Close() error {
+	if p.exited.Load() {
+		return nil
+	}
+	return killProcessGroup(p.cmd.Process)
+}
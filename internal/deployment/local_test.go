@@ -0,0 +1,207 @@
+package deployment
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/history"
+	"github.com/pmaojo/goploy/internal/shell"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDocker puts a docker on PATH that logs its arguments, prints a running container for ps and
+// follows logs until it is stopped. It returns the log file.
+func fakeDocker(t *testing.T) string {
+	bin := t.TempDir()
+	log := filepath.Join(bin, "docker.log")
+	script := `#!/bin/sh
+echo "$*" >> ` + shell.Quote(log) + `
+case "$*" in
+*" ps "*) echo '{"Name":"app-web-1","Service":"web","State":"running","CreatedAt":"2026-10-16 10:00:00 +0000 UTC"}' ;;
+*" logs "*) echo "web-1 | started"; exec sleep 600 ;;
+esac
+`
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func TestLocalHost(t *testing.T) {
+	origin, shas := initRepo(t, 2)
+	docker := fakeDocker(t)
+
+	dir := filepath.Join(t.TempDir(), "app")
+	out, err := exec.Command("git", "clone", "--quiet", origin, dir).CombinedOutput()
+	require.NoError(t, err, string(out))
+	out, err = exec.Command("git", "-C", dir, "reset", "--quiet", "--hard", shas[0]).CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	c := NewSSHClient(nil)
+	project := config.Project{Name: "app", Host: config.LocalHost, Path: dir}
+
+	sink := &recordingSink{}
+	require.NoError(t, c.Deploy(context.Background(), project, sink, "main"))
+
+	head, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	require.NoError(t, err)
+	assert.Equal(t, shas[1], strings.TrimSpace(string(head)))
+
+	log, err := os.ReadFile(docker)
+	require.NoError(t, err)
+	// The runtime is detected once, docker compose answers first.
+	assert.Equal(t, "compose version\ncompose pull\ncompose up -d --build\n", string(log))
+
+	status, err := c.GetStatus(context.Background(), project)
+	require.NoError(t, err)
+	assert.Equal(t, "Healthy", status.Status)
+	assert.Equal(t, "main", status.Branch)
+	assert.Equal(t, shas[1], status.Hosts[0].Commit)
+	assert.Equal(t, time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC), status.LastDeployedAt.UTC())
+
+	// Following the logs ends with the context.
+	ctx, cancel := context.WithCancel(context.Background())
+	logs := &syncBuffer{}
+	go func() {
+		for !strings.Contains(logs.String(), "web-1 | started") {
+			time.Sleep(5 * time.Millisecond)
+		}
+		cancel()
+	}()
+	start := time.Now()
+	err = c.StreamLogs(ctx, project, logs)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), sessionStopTimeout)
+}
+
+func TestLocalClient(t *testing.T) {
+	fakeDocker(t)
+
+	// Whatever host the project names, its commands run on this machine.
+	project := config.Project{Name: "app", Host: "web1", Path: t.TempDir()}
+	status, err := NewLocalClient(nil).GetStatus(context.Background(), project)
+	require.NoError(t, err)
+	assert.Equal(t, "Healthy", status.Status)
+	assert.Equal(t, "app-web-1", status.Containers[0].Name)
+}
+
+func TestLocalHost_DeployAfterRollback(t *testing.T) {
+	origin, shas := initRepo(t, 2)
+	fakeDocker(t)
+
+	dir := filepath.Join(t.TempDir(), "app")
+	out, err := exec.Command("git", "clone", "--quiet", origin, dir).CombinedOutput()
+	require.NoError(t, err, string(out))
+	out, err = exec.Command("git", "-C", dir, "reset", "--quiet", "--hard", shas[0]).CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	c := NewSSHClient(nil)
+	c.History = history.NewStore(t.TempDir())
+	project := config.Project{Name: "app", Host: config.LocalHost, Path: dir}
+	require.NoError(t, c.History.Save(&history.Deployment{Project: "app", Action: history.ActionDeploy, Status: history.StatusSuccess,
+		StartedAt: time.Now().Add(-time.Hour), CommitAfter: shas[0]}))
+
+	git := func(args ...string) string {
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+		require.NoError(t, err)
+		return strings.TrimSpace(string(out))
+	}
+
+	require.NoError(t, c.Deploy(context.Background(), project, &recordingSink{}, ""))
+	assert.Equal(t, shas[1], git("rev-parse", "HEAD"))
+
+	// The rollback keeps the checkout on its branch, the next deployment of the branch pulls again.
+	require.NoError(t, c.Rollback(context.Background(), project, &recordingSink{}))
+	assert.Equal(t, shas[0], git("rev-parse", "HEAD"))
+	assert.Equal(t, "main", git("symbolic-ref", "--short", "HEAD"))
+
+	require.NoError(t, c.Deploy(context.Background(), project, &recordingSink{}, ""))
+	assert.Equal(t, shas[1], git("rev-parse", "HEAD"))
+}
+
+func TestLocalHost_DeployAfterHealthRevert(t *testing.T) {
+	origin, shas := initRepo(t, 3)
+
+	// docker reports the web container unhealthy while the marker exists.
+	bin := t.TempDir()
+	unhealthy := filepath.Join(bin, "unhealthy")
+	script := `#!/bin/sh
+case "$*" in
+*" ps "*) if [ -e ` + shell.Quote(unhealthy) + ` ]; then health=unhealthy; else health=healthy; fi
+	echo '{"Name":"app-web-1","Service":"web","State":"running","Health":"'$health'"}' ;;
+esac
+`
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := filepath.Join(t.TempDir(), "app")
+	out, err := exec.Command("git", "clone", "--quiet", origin, dir).CombinedOutput()
+	require.NoError(t, err, string(out))
+	out, err = exec.Command("git", "-C", dir, "reset", "--quiet", "--hard", shas[0]).CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	git := func(args ...string) string {
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+		require.NoError(t, err)
+		return strings.TrimSpace(string(out))
+	}
+
+	c := NewSSHClient(nil)
+	project := config.Project{Name: "app", Host: config.LocalHost, Path: dir, HealthCheck: &config.HealthCheck{Timeout: time.Second, Interval: time.Millisecond}}
+
+	// The failed deployment is reverted on the branch.
+	require.NoError(t, os.WriteFile(unhealthy, nil, 0o644))
+	var healthErr *HealthCheckError
+	require.ErrorAs(t, c.Deploy(context.Background(), project, &recordingSink{}, ""), &healthErr)
+	require.NoError(t, healthErr.RollbackErr)
+	assert.Equal(t, shas[0], git("rev-parse", "HEAD"))
+	assert.Equal(t, "main", git("symbolic-ref", "--short", "HEAD"))
+
+	require.NoError(t, os.Remove(unhealthy))
+	require.NoError(t, c.Deploy(context.Background(), project, &recordingSink{}, ""))
+	assert.Equal(t, shas[2], git("rev-parse", "HEAD"))
+}
+
+func TestSSHClient_LocalHost(t *testing.T) {
+	c := &SSHClient{}
+	client, release, err := c.connect(context.Background(), config.Project{Host: config.LocalHost})
+	require.NoError(t, err)
+	defer release()
+	assert.Equal(t, localExecutor{}, client)
+
+	var out strings.Builder
+	require.NoError(t, c.runSession(client, "printf %s "+shell.Quote("it's local"), &out, io.Discard, context.Background()))
+	assert.Equal(t, "it's local", out.String())
+
+	err = c.runSession(client, "exit 3", io.Discard, io.Discard, context.Background())
+	assert.Equal(t, 3, exitCode(err))
+}
+
+func TestLocalExecutor_CancelStopsProcessGroup(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "terminated")
+	// The subshell is started by the shell in the background, the signal has to reach it as well.
+	cmd := "(trap " + shell.Quote("echo > "+shell.Quote(marker)+"; exit") + " TERM; while :; do sleep 0.05; done) & echo started; wait"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := &syncBuffer{}
+	go func() {
+		for out.String() == "" {
+			time.Sleep(5 * time.Millisecond)
+		}
+		cancel()
+	}()
+
+	err := (&SSHClient{}).runSession(localExecutor{}, cmd, out, io.Discard, ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(marker)
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+}
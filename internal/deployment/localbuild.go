@@ -0,0 +1,203 @@
+package deployment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/shell"
+)
+
+const (
+	localBuildPhase = "build"
+
+	// transferReportInterval is how often the progress of an image transfer is reported.
+	transferReportInterval = 2 * time.Second
+)
+
+// localBuildStep builds the images of the compose project name on the goploy machine and streams them to the host.
+func localBuildStep(project config.Project, name string) step {
+	args := append(composeArgs(project), "-p", name)
+
+	return step{
+		name:    localBuildPhase,
+		command: fmt.Sprintf("docker compose %s build (local, in %s)", strings.Join(args, " "), project.LocalBuild.Path),
+		local: func(ctx context.Context, c *SSHClient, client executor, events EventSink) error {
+			return c.buildLocally(ctx, client, project, args, events)
+		},
+	}
+}
+
+// buildLocally runs `docker compose build` in the local build path and transfers the built images to the host.
+// args select the compose files and project.
+func (c *SSHClient) buildLocally(ctx context.Context, client executor, project config.Project, args []string, events EventSink) error {
+	dir := project.LocalBuild.Path
+
+	if err := runLocalCommand(ctx, dir, localBuildPhase, nil, events, "docker", slices.Concat([]string{"compose"}, args, []string{"build"})...); err != nil {
+		return fmt.Errorf("local build failed: %w", err)
+	}
+
+	var composeConfig bytes.Buffer
+	if err := runLocalCommand(ctx, dir, localBuildPhase, &composeConfig, events, "docker", slices.Concat([]string{"compose"}, args, []string{"config", "--format", "json"})...); err != nil {
+		return fmt.Errorf("failed to read compose config: %w", err)
+	}
+
+	images, err := builtImages(composeConfig.Bytes())
+	if err != nil {
+		return err
+	}
+
+	for _, image := range images {
+		if err := c.transferImage(ctx, client, project, image, events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runLocalCommand runs name on the goploy machine in dir. Its output is reported as log events of phase,
+// stdout is captured instead if given.
+func runLocalCommand(ctx context.Context, dir, phase string, stdout io.Writer, events EventSink, name string, args ...string) error {
+	stderr := newLogWriter(events, phase, StreamStderr)
+	defer stderr.Flush()
+	if stdout == nil {
+		w := newLogWriter(events, phase, StreamStdout)
+		defer w.Flush()
+		stdout = w
+	}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+// builtImages returns the images of the services that are built, from `docker compose config --format json`.
+// Services without an explicit image get the name compose assigns, <project>-<service>.
+func builtImages(data []byte) ([]string, error) {
+	var cfg struct {
+		Name     string `json:"name"`
+		Services map[string]struct {
+			Build json.RawMessage `json:"build"`
+			Image string          `json:"image"`
+		} `json:"services"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse compose config: %w", err)
+	}
+
+	var images []string
+	for name, svc := range cfg.Services {
+		if len(svc.Build) == 0 {
+			continue
+		}
+		image := svc.Image
+		if image == "" {
+			image = cfg.Name + "-" + name
+		}
+		images = append(images, image)
+	}
+	sort.Strings(images)
+
+	return images, nil
+}
+
+// transferImage pipes `docker save` of the local image into `docker load` (`podman load` on podman hosts) on the host.
+func (c *SSHClient) transferImage(ctx context.Context, client executor, project config.Project, image string, events EventSink) error {
+	stderr := newLogWriter(events, localBuildPhase, StreamStderr)
+	defer stderr.Flush()
+
+	cmd := exec.CommandContext(ctx, "docker", "save", image)
+	cmd.Stderr = stderr
+	saved, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to save image %s: %w", image, err)
+	}
+
+	loadErr := c.loadImage(ctx, client, project, image, saved, events)
+	if loadErr != nil {
+		// Unblock docker save if the remote side gave up early.
+		_, _ = io.Copy(io.Discard, saved)
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("failed to save image %s: %w", image, err)
+	}
+	return loadErr
+}
+
+// loadImage streams an image archive into the load command of the host's runtime, reporting the bytes transferred.
+func (c *SSHClient) loadImage(ctx context.Context, client executor, project config.Project, image string, archive io.Reader, events EventSink) error {
+	err := c.streamCommand(ctx, client, shell.InDir(project.Path, runtimeOf(project).engine+" load"), archive, localBuildPhase, events, func(n int64) {
+		emitMessage(events, localBuildPhase, "%s: %s transferred", image, formatBytes(n))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load image %s: %w", image, err)
+	}
+	return nil
+}
+
+// streamCommand runs remoteCommand with r as its stdin, its output is reported as log events of phase.
+// report is called with the bytes sent every transferReportInterval and once done.
+func (c *SSHClient) streamCommand(ctx context.Context, client executor, remoteCommand string, r io.Reader, phase string, events EventSink, report func(n int64)) error {
+	progress := &transferProgress{r: r, last: time.Now(), report: report}
+	stdout := newLogWriter(events, phase, StreamStdout)
+	stderr := newLogWriter(events, phase, StreamStderr)
+	defer stdout.Flush()
+	defer stderr.Flush()
+
+	proc, err := client.start(remoteCommand, progress, stdout, stderr)
+	if err != nil {
+		return err
+	}
+	defer proc.Close()
+
+	if err := waitForSession(ctx, proc); err != nil {
+		return err
+	}
+
+	report(progress.n)
+	return nil
+}
+
+// transferProgress counts the bytes read from r and reports them every transferReportInterval.
+type transferProgress struct {
+	r      io.Reader
+	n      int64
+	last   time.Time
+	report func(n int64)
+}
+
+func (p *transferProgress) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n += int64(n)
+	if time.Since(p.last) >= transferReportInterval {
+		p.last = time.Now()
+		p.report(p.n)
+	}
+	return n, err
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,105 @@
+package deployment
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuiltImages(t *testing.T) {
+	data := []byte(`{
+		"name": "api",
+		"services": {
+			"web": {"build": {"context": "."}},
+			"worker": {"build": {"context": "./worker"}, "image": "registry.example.com/worker:dev"},
+			"db": {"image": "postgres:16"}
+		}
+	}`)
+
+	images, err := builtImages(data)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api-web", "registry.example.com/worker:dev"}, images)
+
+	_, err = builtImages([]byte("not json"))
+	assert.Error(t, err)
+}
+
+func TestLoadImage(t *testing.T) {
+	var received syncBuffer
+	server := newTestSSHServer(t, func(cmd string, stdin io.Reader, stdout, _ io.Writer, _ <-chan string) int {
+		if !strings.HasSuffix(cmd, "docker load") {
+			return 1
+		}
+		io.Copy(&received, stdin)
+		io.WriteString(stdout, "Loaded image: api-web:latest\n")
+		return 0
+	})
+
+	client := server.connect(t)
+
+	sink := &recordingSink{}
+	c := &SSHClient{}
+	err := c.loadImage(context.Background(), client, config.Project{Path: "/srv/api"}, "api-web", strings.NewReader("image archive"), sink)
+	require.NoError(t, err)
+
+	assert.Equal(t, "image archive", received.String())
+	assert.Equal(t, `cd '/srv/api' && docker load`, server.executed()[0])
+
+	logs := sink.ofType(EventLog)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "Loaded image: api-web:latest", logs[0].Text)
+
+	messages := sink.ofType(EventMessage)
+	require.NotEmpty(t, messages)
+	assert.Equal(t, "api-web: 13 B transferred", messages[len(messages)-1].Text)
+}
+
+func TestDeploySteps_LocalBuild(t *testing.T) {
+	project := config.Project{Name: "api", Path: "/srv/api", LocalBuild: &config.LocalBuild{Path: "/home/dev/api"}}
+
+	steps := deploySteps(project, "")
+	var names []string
+	for _, s := range steps {
+		names = append(names, s.name)
+	}
+	assert.Equal(t, []string{"fetch", "pull", "image pull", "build", "up"}, names)
+
+	assert.Equal(t, "docker compose pull --ignore-buildable", steps[2].command)
+	assert.NotNil(t, steps[3].local)
+	assert.Equal(t, "docker compose -p api build (local, in /home/dev/api)", steps[3].command)
+	assert.Equal(t, "docker compose up -d", steps[4].command)
+
+	assert.EqualError(t, validateStrategy(config.Project{LocalBuild: &config.LocalBuild{}}, ""), "local_build requires a path")
+
+	// Only docker compose v2 can skip the built services.
+	project.Compose = &config.Compose{Runtime: config.RuntimeDockerCompose}
+	assert.Equal(t, "docker-compose pull --ignore-pull-failures", deploySteps(project, "")[2].command)
+	project.Compose.Runtime = config.RuntimePodman
+	assert.Equal(t, "podman compose pull", deploySteps(project, "")[2].command)
+}
+
+func TestPullCommand_LocalBuild(t *testing.T) {
+	project := config.Project{Name: "api", Path: "/srv/api", Compose: &config.Compose{}}
+	assert.Equal(t, "docker compose pull", pullCommand(project, "docker compose"))
+
+	project.LocalBuild = &config.LocalBuild{Path: "/home/dev/api"}
+	for runtime, want := range map[string]string{
+		config.RuntimeDocker:        "docker compose pull --ignore-buildable",
+		config.RuntimeDockerCompose: "docker-compose pull --ignore-pull-failures",
+		config.RuntimePodman:        "podman compose pull",
+	} {
+		project.Compose.Runtime = runtime
+		assert.Equal(t, want, pullCommand(project, composeCommand(project)), runtime)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512 B", formatBytes(512))
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+	assert.Equal(t, "250.0 MiB", formatBytes(250*1024*1024))
+}
@@ -0,0 +1,207 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/shell"
+)
+
+// remoteLockDir is created inside project.Path while an operation holds the project lock.
+const remoteLockDir = ".goploy.lock"
+
+// lockHeldExitCode is returned by the remote lock script if the lock is already taken (EX_TEMPFAIL).
+const lockHeldExitCode = 75
+
+// pathCreateExitCode is returned by remote scripts that can't create their directory (EX_CANTCREAT): the
+// missing project path of the lock script, the release directory of an upload.
+const pathCreateExitCode = 73
+
+// staleLockMinutes is the age (of its last refresh) after which a remote lock counts as left over by a goploy
+// process that died or lost its connection, it is taken over then.
+const staleLockMinutes = 5
+
+// lockRefreshInterval is how often the holder refreshes its remote locks, well within staleLockMinutes.
+var lockRefreshInterval = 30 * time.Second
+
+// LockError is returned when another deploy, restart or stop currently holds the project lock.
+type LockError struct {
+	Project string
+	Holder  string
+}
+
+func (e *LockError) Error() string {
+	return fmt.Sprintf("project %s is locked by %s", e.Project, e.Holder)
+}
+
+// projectLocks serializes operations on the same project within this goploy process.
+type projectLocks struct {
+	mu   sync.Mutex
+	held map[string]string
+}
+
+func (l *projectLocks) tryLock(project string, holder string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if current, ok := l.held[project]; ok {
+		return &LockError{Project: project, Holder: current}
+	}
+
+	if l.held == nil {
+		l.held = make(map[string]string)
+	}
+	l.held[project] = holder
+	return nil
+}
+
+func (l *projectLocks) unlock(project string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.held, project)
+}
+
+// lock acquires the project lock, first within this process and then on every host
+// (so separate goploy instances are serialized too). The remote locks are refreshed while they are held,
+// see staleLockMinutes. The returned func releases all of them, even if ctx has been cancelled meanwhile.
+func (c *SSHClient) lock(ctx context.Context, hosts []hostConn, operation string) (func(), error) {
+	unlock, _, err := c.acquireLock(ctx, hosts, operation, false)
+	return unlock, err
+}
+
+// lockCreatingPaths acquires the project lock like lock for operations that set the project up: the project
+// path, which holds the remote lock, is created on the hosts that don't have it yet. It also returns those
+// hosts, only the lock holder knows they still have to be bootstrapped.
+func (c *SSHClient) lockCreatingPaths(ctx context.Context, hosts []hostConn, operation string) (func(), map[string]bool, error) {
+	return c.acquireLock(ctx, hosts, operation, true)
+}
+
+func (c *SSHClient) acquireLock(ctx context.Context, hosts []hostConn, operation string, createPaths bool) (func(), map[string]bool, error) {
+	name := hosts[0].project.Name
+	holder := c.lockHolder(operation)
+
+	if err := c.locks.tryLock(name, holder); err != nil {
+		return nil, nil, err
+	}
+
+	var locked []hostConn
+	release := func() {
+		cleanupCtx, cancel := cleanupContext(ctx)
+		defer cancel()
+
+		for _, h := range locked {
+			// Best effort, a left over lock dir expires once it is no longer refreshed.
+			_ = c.runSession(h.client, shell.InDir(h.project.Path, "rm -rf "+remoteLockDir), io.Discard, io.Discard, cleanupCtx)
+		}
+		c.locks.unlock(name)
+	}
+
+	created := make(map[string]bool)
+	for _, h := range hosts {
+		pathCreated, err := c.lockRemote(ctx, h, holder, createPaths)
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		locked = append(locked, h)
+		if pathCreated {
+			created[h.project.Host] = true
+		}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.refreshLocks(locked, stop)
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		release()
+	}, created, nil
+}
+
+// refreshLocks touches the remote lock directories until stop is closed, so they don't expire while held.
+func (c *SSHClient) refreshLocks(hosts []hostConn, stop <-chan struct{}) {
+	ticker := time.NewTicker(lockRefreshInterval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for _, h := range hosts {
+				// Best effort, a failed refresh is retried on the next tick.
+				_ = c.runSession(h.client, shell.InDir(h.project.Path, "touch "+remoteLockDir), io.Discard, io.Discard, ctx)
+			}
+		}
+	}
+}
+
+// lockRemote creates the lock directory on a single host. A lock directory that was not refreshed for
+// staleLockMinutes is left over by a goploy process that died, it is removed first. With createPath a missing
+// project path is created in the same script, it reports whether it did.
+func (c *SSHClient) lockRemote(ctx context.Context, h hostConn, holder string, createPath bool) (bool, error) {
+	var b strings.Builder
+	path := shell.Quote(h.project.Path)
+	enter := "cd " + path
+	if createPath {
+		enter = fmt.Sprintf("if [ ! -d %[1]s ]; then mkdir -p %[1]s || exit %[2]d; echo created; fi && %[3]s", path, pathCreateExitCode, enter)
+	}
+	stale := fmt.Sprintf(`if [ -n "$(find %[1]s -maxdepth 0 -mmin +%[2]d 2>/dev/null)" ]; then mv %[1]s %[1]s.stale.$$ 2>/dev/null && rm -rf %[1]s.stale.$$ || true; fi`,
+		remoteLockDir, staleLockMinutes)
+	script := fmt.Sprintf("%s && { %s; } && if mkdir %s 2>/dev/null; then printf '%%s\\n' %s > %s/owner; else cat %s/owner 2>/dev/null || echo unknown; exit %d; fi",
+		enter, stale, remoteLockDir, shell.Quote(holder), remoteLockDir, remoteLockDir, lockHeldExitCode)
+
+	if err := c.runSession(h.client, script, &b, io.Discard, ctx); err != nil {
+		status, ok := exitStatus(err)
+		switch {
+		case ok && status == lockHeldExitCode:
+			// Another instance may have created the path too and taken the lock first.
+			current := strings.TrimPrefix(strings.TrimSpace(b.String()), "created\n")
+			return false, &LockError{Project: h.project.Name, Holder: current}
+		case ok && status == pathCreateExitCode:
+			return false, fmt.Errorf("failed to create %s on %s: %w", h.project.Path, h.project.Host, err)
+		}
+		return false, fmt.Errorf("failed to acquire remote lock on %s: %w", h.project.Host, err)
+	}
+
+	return strings.TrimSpace(b.String()) == "created", nil
+}
+
+// lockHolder describes who is holding a lock, e.g. "alice@laptop via tui (deploy) since 2024-01-01T12:00:00Z".
+func (c *SSHClient) lockHolder(operation string) string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	trigger := c.Trigger
+	if trigger == "" {
+		trigger = "goploy"
+	}
+
+	return fmt.Sprintf("%s@%s via %s (%s) since %s", name, hostname, trigger, operation, time.Now().UTC().Format(time.RFC3339))
+}
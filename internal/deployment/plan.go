@@ -0,0 +1,532 @@
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/shell"
+	"gopkg.in/yaml.v3"
+)
+
+const planPhase = "plan"
+
+// defaultComposeFiles are the files compose reads without -f, see config.Compose.
+var defaultComposeFiles = []string{
+	"compose.yaml", "compose.yml", "docker-compose.yml", "docker-compose.yaml",
+	"compose.override.yaml", "compose.override.yml", "docker-compose.override.yml", "docker-compose.override.yaml",
+}
+
+// Kinds of ServiceChange.
+const (
+	ServiceAdded   = "added"
+	ServiceRemoved = "removed"
+	// ServiceImage runs another image reference.
+	ServiceImage = "image"
+	// ServiceRebuild is built from a context with changed files.
+	ServiceRebuild = "rebuild"
+)
+
+// DeploymentPlan is what Deploy would change, see Plan.
+type DeploymentPlan struct {
+	Project string
+	Ref     string
+	Hosts   []HostPlan
+}
+
+// HostPlan is what Deploy would change on a single host.
+type HostPlan struct {
+	Host string
+	// Current is the deployed commit (the image tag of image projects), Target the one Deploy would deploy.
+	Current string
+	Target  string
+	// Commits are the commits Target adds to Current, newest first.
+	Commits []PlannedCommit
+	Files   []ChangedFile
+	// ConfigDiff is the diff of the compose files and the env file between Current and Target.
+	ConfigDiff string
+	Services   []ServiceChange
+	// Dirty lists the uncommitted changes to tracked files of the checkout, as printed by `git status --short`.
+	Dirty []string
+	Error string `json:",omitempty"`
+}
+
+// PlannedCommit is a commit a deployment would bring to a host.
+type PlannedCommit struct {
+	Commit  string
+	Author  string
+	Date    time.Time
+	Subject string
+}
+
+// ChangedFile is a file changed by a deployment, Status is its `git diff --name-status` letter (A, M, D, T).
+type ChangedFile struct {
+	Status string
+	Path   string
+}
+
+// ServiceChange is a compose service whose image would change.
+type ServiceChange struct {
+	Service string
+	Change  string
+	// From and To are the image references of the service, empty for services built from the checkout.
+	From string `json:",omitempty"`
+	To   string `json:",omitempty"`
+}
+
+// PlanRequestError is returned by Plan for projects or refs that cannot be planned at all,
+// before any host is contacted.
+type PlanRequestError struct {
+	Err error
+}
+
+func (e *PlanRequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PlanRequestError) Unwrap() error {
+	return e.Err
+}
+
+// composeService is the part of a service in `docker compose config` a plan compares.
+type composeService struct {
+	image string
+	// context is the build context relative to the project directory, "." for the project directory
+	// itself and empty for services without build or with a context outside of it.
+	context string
+}
+
+// Plan reports what Deploy would change on each host without deploying: the commits between the deployed
+// commit and ref, the changed files with the diff of the compose and env files, the services whose image
+// would change and uncommitted changes of the checkout. Image projects report the tag and the services only.
+// Hosts are fetched (the local repository for the bundle transport) under the project lock, nothing else is changed.
+// Upload projects cannot be planned.
+func (c *SSHClient) Plan(ctx context.Context, project config.Project, ref string) (DeploymentPlan, error) {
+	if err := validateStrategy(project, ref); err != nil {
+		return DeploymentPlan{}, &PlanRequestError{Err: err}
+	}
+	if uploadStrategy(project) {
+		return DeploymentPlan{}, &PlanRequestError{Err: errors.New("upload deployments cannot be planned, the local directory is deployed as is")}
+	}
+
+	ctx, cancel, wrapTimeout := withTimeout(ctx, deployTimeout(project), planPhase)
+	defer cancel()
+
+	hostList := project.HostList()
+	hosts := make([]HostPlan, len(hostList))
+	errs := make([]error, len(hostList))
+	conns := make([]*hostConn, len(hostList))
+	releases := make([]func(), len(hostList))
+	defer func() {
+		for _, release := range releases {
+			if release != nil {
+				release()
+			}
+		}
+	}()
+
+	// Unreachable hosts are reported in the plan, the others are planned.
+	var wg sync.WaitGroup
+	for i, host := range hostList {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := project.OnHost(host)
+			client, release, err := c.connect(ctx, p)
+			if err != nil {
+				errs[i] = fmt.Errorf("connection failed: %w", err)
+				return
+			}
+			releases[i] = release
+			conns[i] = &hostConn{project: c.resolveRuntime(ctx, client, p), client: client}
+		}()
+	}
+	wg.Wait()
+
+	var connected []hostConn
+	for _, h := range conns {
+		if h != nil {
+			connected = append(connected, *h)
+		}
+	}
+	if len(connected) == 0 {
+		for i, err := range errs {
+			errs[i] = forHost(project, hostList[i], wrapTimeout(err))
+		}
+		return DeploymentPlan{}, joinErrors(errs)
+	}
+
+	// Fetching writes to the checkout, a deployment must not run meanwhile.
+	unlock, err := c.lock(ctx, connected, planPhase)
+	if err != nil {
+		return DeploymentPlan{}, err
+	}
+	defer unlock()
+
+	if bundleTransport(project) {
+		if err := runLocalCommand(ctx, project.Git.LocalPath, planPhase, io.Discard, EventFunc(func(Event) {}), "git", "fetch", "--all", "--quiet"); err != nil {
+			return DeploymentPlan{}, wrapTimeout(fmt.Errorf("failed to fetch %s: %w", project.Git.LocalPath, err))
+		}
+	}
+
+	for i, h := range conns {
+		if h == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hosts[i], errs[i] = c.hostPlan(ctx, *h, ref)
+		}()
+	}
+	wg.Wait()
+
+	planned := 0
+	for i, err := range errs {
+		if err != nil {
+			err = wrapTimeout(err)
+			errs[i] = forHost(project, hostList[i], err)
+			hosts[i] = HostPlan{Host: hostList[i], Error: err.Error()}
+			continue
+		}
+		planned++
+	}
+	if planned == 0 {
+		return DeploymentPlan{}, joinErrors(errs)
+	}
+
+	return DeploymentPlan{Project: project.Name, Ref: ref, Hosts: hosts}, nil
+}
+
+func (c *SSHClient) hostPlan(ctx context.Context, h hostConn, ref string) (HostPlan, error) {
+	if imageStrategy(h.project) {
+		return c.planImage(ctx, h.client, h.project, ref)
+	}
+	return c.planGit(ctx, h.client, h.project, ref)
+}
+
+// planImage compares the services with the image tag set to ref.
+func (c *SSHClient) planImage(ctx context.Context, client executor, project config.Project, ref string) (HostPlan, error) {
+	plan := HostPlan{Host: project.Host, Current: c.headCommit(ctx, client, project), Target: ref}
+	if ref == "" || ref == plan.Current {
+		plan.Target = plan.Current
+		return plan, nil
+	}
+
+	dir := shell.Command("cd", project.Path)
+	current, err := c.composeServices(ctx, client, configCommand(project, dir, ""), nil)
+	if err != nil {
+		return HostPlan{}, err
+	}
+	// Variables of the environment take precedence over the env file.
+	target, err := c.composeServices(ctx, client, configCommand(project, dir, tagVariable(project)+"="+shell.Quote(ref)+" "), nil)
+	if err != nil {
+		return HostPlan{}, err
+	}
+
+	plan.Services = diffServices(current, target, nil)
+	return plan, nil
+}
+
+// planGit compares the checkout of the host with the commit Deploy would check out.
+func (c *SSHClient) planGit(ctx context.Context, client executor, project config.Project, ref string) (HostPlan, error) {
+	plan := HostPlan{Host: project.Host}
+	remote := c.remoteGit(client, project.Path)
+
+	status, err := remote(ctx, "status", "--short", "--untracked-files=no")
+	if err != nil {
+		return HostPlan{}, err
+	}
+	plan.Dirty = nonEmptyLines(status)
+
+	head, err := remote(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return HostPlan{}, err
+	}
+	plan.Current = strings.TrimSpace(head)
+
+	// The commits are listed from the repository the target is taken from.
+	git := remote
+	if bundleTransport(project) {
+		local := project.Git.LocalPath
+		git = localGit(local)
+		if plan.Target, _, err = resolveBundleTarget(ctx, local, ref, c.remoteBranch(ctx, client, project)); err != nil {
+			return HostPlan{}, err
+		}
+		if !localCommitExists(ctx, local, plan.Current) {
+			return HostPlan{}, fmt.Errorf("commit %s of the host is unknown in %s", plan.Current, local)
+		}
+	} else {
+		if _, err := remote(ctx, "fetch", "--all", "--quiet"); err != nil {
+			return HostPlan{}, err
+		}
+		if plan.Target, err = remoteTarget(ctx, remote, ref); err != nil {
+			return HostPlan{}, err
+		}
+	}
+
+	if plan.Target == plan.Current {
+		return plan, nil
+	}
+
+	log, err := git(ctx, "log", "--format=%H%x1f%an <%ae>%x1f%aI%x1f%s", plan.Current+".."+plan.Target)
+	if err != nil {
+		return HostPlan{}, err
+	}
+	if plan.Commits, err = parseCommits(log); err != nil {
+		return HostPlan{}, err
+	}
+
+	files, err := git(ctx, "diff", "--name-status", "--no-renames", "-z", plan.Current, plan.Target)
+	if err != nil {
+		return HostPlan{}, err
+	}
+	plan.Files = parseChangedFiles(files)
+
+	if plan.ConfigDiff, err = git(ctx, append([]string{"diff", plan.Current, plan.Target, "--"}, configFiles(project)...)...); err != nil {
+		return HostPlan{}, err
+	}
+
+	current, err := c.composeServices(ctx, client, configCommand(project, shell.Command("cd", project.Path), ""), nil)
+	if err != nil {
+		return HostPlan{}, err
+	}
+	target, err := c.targetServices(ctx, client, project, plan.Target)
+	if err != nil {
+		return HostPlan{}, err
+	}
+
+	plan.Services = diffServices(current, target, plan.Files)
+	return plan, nil
+}
+
+// targetServices reads the compose services of target from a temporary copy of its tree next to the
+// untracked files of the checkout, like env files, which compose reads too.
+func (c *SSHClient) targetServices(ctx context.Context, client executor, project config.Project, target string) (map[string]composeService, error) {
+	untracked := `git ls-files --others --directory | grep -v '/$' | while IFS= read -r f; do mkdir -p "$d/$(dirname "$f")" && cp -p "$f" "$d/$f"; done`
+	prepare := fmt.Sprintf(`d="$(mktemp -d)" && trap 'rm -rf "$d"' EXIT && cd %s && { %s; }`, shell.Quote(project.Path), untracked)
+
+	if !bundleTransport(project) {
+		prepare += " && " + shell.Command("git archive", target) + ` | tar -x -C "$d" && cd "$d"`
+		return c.composeServices(ctx, client, configCommand(project, prepare, ""), nil)
+	}
+
+	// The host doesn't have the target yet, its tree is streamed from the local repository.
+	archive := exec.CommandContext(ctx, "git", "archive", target)
+	archive.Dir = project.Git.LocalPath
+	tree, err := archive.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := archive.Start(); err != nil {
+		return nil, fmt.Errorf("failed to archive %s: %w", target, err)
+	}
+
+	services, err := c.composeServices(ctx, client, configCommand(project, prepare+` && tar -x -C "$d" && cd "$d"`, ""), tree)
+	if waitErr := archive.Wait(); err == nil && waitErr != nil {
+		err = fmt.Errorf("failed to archive %s: %w", target, waitErr)
+	}
+	return services, err
+}
+
+// configCommand prints the directory prepare changes into and the compose config of the project in it,
+// as JSON if the runtime can print it. env is prepended to the compose command, e.g. to set variables.
+func configCommand(project config.Project, prepare, env string) string {
+	format := ""
+	if runtimeOf(project).json {
+		format = " --format json"
+	}
+	return fmt.Sprintf("%s && pwd && %s%s -p %s config%s", prepare, env, composeBase(project), shell.Quote(composeProjectName(project)), format)
+}
+
+// composeServices runs a configCommand, with stdin as its input if set, and parses the services it prints.
+func (c *SSHClient) composeServices(ctx context.Context, client executor, cmd string, stdin io.Reader) (map[string]composeService, error) {
+	var stdout, stderr strings.Builder
+	proc, err := client.start(cmd, stdin, &stdout, &stderr)
+	if err != nil {
+		return nil, err
+	}
+	defer proc.Close()
+
+	if err := waitForSession(ctx, proc); err != nil {
+		return nil, fmt.Errorf("failed to read compose config: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseComposeServices(stdout.String())
+}
+
+// parseComposeServices parses the output of a configCommand, the directory followed by the compose config
+// in JSON or, for runtimes that print YAML only, in YAML.
+func parseComposeServices(output string) (map[string]composeService, error) {
+	dir, data, _ := strings.Cut(output, "\n")
+
+	var cfg struct {
+		Services map[string]struct {
+			Image string `json:"image" yaml:"image"`
+			Build *struct {
+				Context string `json:"context" yaml:"context"`
+			} `json:"build" yaml:"build"`
+		} `json:"services" yaml:"services"`
+	}
+	if strings.HasPrefix(strings.TrimSpace(data), "{") {
+		if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse compose config: %w", err)
+		}
+	} else {
+		if err := yaml.Unmarshal([]byte(data), &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse compose config: %w", err)
+		}
+		if cfg.Services == nil {
+			return nil, fmt.Errorf("failed to parse compose config: no services in %q", strings.TrimSpace(data))
+		}
+	}
+
+	services := make(map[string]composeService, len(cfg.Services))
+	for name, s := range cfg.Services {
+		service := composeService{image: s.Image}
+		if s.Build != nil {
+			switch {
+			case s.Build.Context == dir:
+				service.context = "."
+			case strings.HasPrefix(s.Build.Context, dir+"/"):
+				service.context = strings.TrimPrefix(s.Build.Context, dir+"/")
+			}
+		}
+		services[name] = service
+	}
+	return services, nil
+}
+
+// diffServices compares the services of two compose configs, services built from a context are
+// rebuilt if files in it changed.
+func diffServices(current, target map[string]composeService, files []ChangedFile) []ServiceChange {
+	var changes []ServiceChange
+	for name, t := range target {
+		cur, ok := current[name]
+		switch {
+		case !ok:
+			changes = append(changes, ServiceChange{Service: name, Change: ServiceAdded, To: t.image})
+		case cur.image != t.image:
+			changes = append(changes, ServiceChange{Service: name, Change: ServiceImage, From: cur.image, To: t.image})
+		case t.context != "" && slices.ContainsFunc(files, func(f ChangedFile) bool {
+			return t.context == "." || strings.HasPrefix(f.Path, t.context+"/")
+		}):
+			changes = append(changes, ServiceChange{Service: name, Change: ServiceRebuild, From: cur.image, To: t.image})
+		}
+	}
+	for name, cur := range current {
+		if _, ok := target[name]; !ok {
+			changes = append(changes, ServiceChange{Service: name, Change: ServiceRemoved, From: cur.image})
+		}
+	}
+
+	slices.SortFunc(changes, func(a, b ServiceChange) int { return strings.Compare(a.Service, b.Service) })
+	return changes
+}
+
+// gitOutput runs git with args in a repository and returns its output.
+type gitOutput func(ctx context.Context, args ...string) (string, error)
+
+func (c *SSHClient) remoteGit(client executor, dir string) gitOutput {
+	return func(ctx context.Context, args ...string) (string, error) {
+		var stdout, stderr strings.Builder
+		cmd := shell.InDir(dir, shell.Command("git", args...))
+		if err := c.runSession(client, cmd, &stdout, &stderr, ctx); err != nil {
+			return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+		}
+		return stdout.String(), nil
+	}
+}
+
+func localGit(dir string) gitOutput {
+	return func(ctx context.Context, args ...string) (string, error) {
+		var stdout strings.Builder
+		if err := runLocalCommand(ctx, dir, planPhase, &stdout, EventFunc(func(Event) {}), "git", args...); err != nil {
+			return "", fmt.Errorf("git %s failed in %s: %w", args[0], dir, err)
+		}
+		return stdout.String(), nil
+	}
+}
+
+// remoteTarget resolves the commit Deploy would check out on a fetched host: the upstream of the current
+// branch, or ref, preferring the remote-tracking branch origin/<ref> which `git pull` would bring it to.
+func remoteTarget(ctx context.Context, git gitOutput, ref string) (string, error) {
+	candidates := []string{"refs/remotes/origin/" + ref, ref}
+	if ref == "" {
+		candidates = []string{"@{upstream}"}
+	}
+
+	for _, candidate := range candidates {
+		if commit, err := git(ctx, "rev-parse", "--verify", "--quiet", candidate+"^{commit}"); err == nil {
+			return strings.TrimSpace(commit), nil
+		}
+	}
+
+	if ref == "" {
+		return "", errors.New("the checked out branch has no upstream, a ref is required")
+	}
+	return "", fmt.Errorf("ref %s not found on the host", ref)
+}
+
+// parseCommits parses `git log --format=%H%x1f%an <%ae>%x1f%aI%x1f%s`.
+func parseCommits(log string) ([]PlannedCommit, error) {
+	var commits []PlannedCommit
+	for _, line := range nonEmptyLines(log) {
+		fields := strings.SplitN(line, "\x1f", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected git log line %q", line)
+		}
+		date, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, PlannedCommit{Commit: fields[0], Author: fields[1], Date: date, Subject: fields[3]})
+	}
+	return commits, nil
+}
+
+// parseChangedFiles parses `git diff --name-status --no-renames -z`, NUL separated status and path pairs.
+func parseChangedFiles(output string) []ChangedFile {
+	fields := strings.Split(strings.TrimSuffix(output, "\x00"), "\x00")
+
+	var files []ChangedFile
+	for i := 0; i+1 < len(fields); i += 2 {
+		files = append(files, ChangedFile{Status: fields[i], Path: fields[i+1]})
+	}
+	return files
+}
+
+// configFiles are the compose files and the env file of the project, relative to its path.
+func configFiles(project config.Project) []string {
+	files := defaultComposeFiles
+	if project.Compose != nil && len(project.Compose.Files) > 0 {
+		files = project.Compose.Files
+	}
+
+	var relative []string
+	for _, f := range append(slices.Clone(files), imageEnvFile(project)) {
+		if !path.IsAbs(f) {
+			relative = append(relative, f)
+		}
+	}
+	return relative
+}
+
+func nonEmptyLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,225 @@
+package deployment
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// planHandler answers the commands of a git plan from aaa to bbb (origin/v2), targetConfig is the compose
+// config of the target.
+func planHandler(targetConfig string) testHandler {
+	currentConfig := `{"services": {
+		"api": {"build": {"context": "/srv/app/api"}},
+		"db": {"image": "postgres:15"},
+		"cache": {"image": "redis:7"}
+	}}`
+
+	return func(cmd string, _ io.Reader, stdout, _ io.Writer, _ <-chan string) int {
+		switch {
+		case strings.Contains(cmd, "config --format json"):
+			dir, config := "/srv/app", currentConfig
+			if strings.Contains(cmd, "git archive 'bbb'") {
+				dir, config = "/tmp/tmp.x1", targetConfig
+			}
+			io.WriteString(stdout, dir+"\n"+config)
+		case strings.HasSuffix(cmd, "git 'status' '--short' '--untracked-files=no'"):
+			io.WriteString(stdout, " M docker-compose.yml\n")
+		case strings.HasSuffix(cmd, "git 'rev-parse' 'HEAD'"):
+			io.WriteString(stdout, "aaa\n")
+		case strings.Contains(cmd, "git 'fetch'"):
+		case strings.HasSuffix(cmd, "'refs/remotes/origin/v2^{commit}'"):
+			io.WriteString(stdout, "bbb\n")
+		case strings.Contains(cmd, "'rev-parse' '--verify'"):
+			return 1
+		case strings.Contains(cmd, "git 'log'") && strings.HasSuffix(cmd, "'aaa..bbb'"):
+			io.WriteString(stdout, "bbb\x1fDev <dev@example.com>\x1f2026-10-01T12:00:00+02:00\x1fBump api\n"+
+				"ccc\x1fOps <ops@example.com>\x1f2026-09-30T09:30:00Z\x1fUse postgres 16\n")
+		case strings.Contains(cmd, "'--name-status'"):
+			io.WriteString(stdout, "M\x00api/main.go\x00M\x00docker-compose.yml\x00")
+		case strings.Contains(cmd, "git 'diff' 'aaa' 'bbb' '--'"):
+			io.WriteString(stdout, "-    image: postgres:15\n+    image: postgres:16\n")
+		default:
+			return 127
+		}
+		return 0
+	}
+}
+
+func TestPlanGit(t *testing.T) {
+	server := newTestSSHServer(t, planHandler(`{"services": {
+		"api": {"build": {"context": "/tmp/tmp.x1/api"}},
+		"db": {"image": "postgres:16"},
+		"worker": {"image": "app/worker:2"}
+	}}`))
+	client := server.connect(t)
+
+	project := config.Project{Name: "app", Host: "web1", Path: "/srv/app"}
+	plan, err := (&SSHClient{}).planGit(context.Background(), client, project, "v2")
+	require.NoError(t, err)
+
+	assert.Equal(t, "web1", plan.Host)
+	assert.Equal(t, "aaa", plan.Current)
+	assert.Equal(t, "bbb", plan.Target)
+	assert.Equal(t, []string{" M docker-compose.yml"}, plan.Dirty)
+	assert.Equal(t, []PlannedCommit{
+		{Commit: "bbb", Author: "Dev <dev@example.com>", Date: time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC), Subject: "Bump api"},
+		{Commit: "ccc", Author: "Ops <ops@example.com>", Date: time.Date(2026, 9, 30, 9, 30, 0, 0, time.UTC), Subject: "Use postgres 16"},
+	}, normalizeDates(plan.Commits))
+	assert.Equal(t, []ChangedFile{{Status: "M", Path: "api/main.go"}, {Status: "M", Path: "docker-compose.yml"}}, plan.Files)
+	assert.Contains(t, plan.ConfigDiff, "+    image: postgres:16")
+	assert.Equal(t, []ServiceChange{
+		{Service: "api", Change: ServiceRebuild},
+		{Service: "cache", Change: ServiceRemoved, From: "redis:7"},
+		{Service: "db", Change: ServiceImage, From: "postgres:15", To: "postgres:16"},
+		{Service: "worker", Change: ServiceAdded, To: "app/worker:2"},
+	}, plan.Services)
+
+	// The untracked files of the checkout are copied next to the target tree, the diff covers the compose and env files.
+	executed := strings.Join(server.executed(), "\n")
+	assert.Contains(t, executed, "git ls-files --others --directory")
+	assert.Contains(t, executed, "'docker-compose.yml' 'docker-compose.yaml'")
+	assert.Contains(t, executed, "'.env'")
+}
+
+func normalizeDates(commits []PlannedCommit) []PlannedCommit {
+	for i := range commits {
+		commits[i].Date = commits[i].Date.UTC()
+	}
+	return commits
+}
+
+func TestPlanGit_UpToDate(t *testing.T) {
+	server := newTestSSHServer(t, func(cmd string, _ io.Reader, stdout, _ io.Writer, _ <-chan string) int {
+		switch {
+		case strings.Contains(cmd, "'@{upstream}^{commit}'"), strings.HasSuffix(cmd, "git 'rev-parse' 'HEAD'"):
+			io.WriteString(stdout, "aaa\n")
+		case strings.Contains(cmd, "git 'status'"), strings.Contains(cmd, "git 'fetch'"):
+		default:
+			return 127
+		}
+		return 0
+	})
+	client := server.connect(t)
+
+	plan, err := (&SSHClient{}).planGit(context.Background(), client, config.Project{Host: "web1", Path: "/srv/app"}, "")
+	require.NoError(t, err)
+	assert.Equal(t, HostPlan{Host: "web1", Current: "aaa", Target: "aaa"}, plan)
+}
+
+func TestPlanGit_UnknownRef(t *testing.T) {
+	server := newTestSSHServer(t, planHandler(`{}`))
+	client := server.connect(t)
+
+	_, err := (&SSHClient{}).planGit(context.Background(), client, config.Project{Host: "web1", Path: "/srv/app"}, "v3")
+	assert.EqualError(t, err, "ref v3 not found on the host")
+}
+
+func TestPlanImage(t *testing.T) {
+	server := newTestSSHServer(t, func(cmd string, _ io.Reader, stdout, _ io.Writer, _ <-chan string) int {
+		switch {
+		case strings.Contains(cmd, "GOPLOY_IMAGE_TAG='v2' docker compose"):
+			io.WriteString(stdout, `/srv/app
+{"services": {"api": {"image": "registry/api:v2"}, "db": {"image": "postgres:16"}}}`)
+		case strings.Contains(cmd, "config --format json"):
+			io.WriteString(stdout, `/srv/app
+{"services": {"api": {"image": "registry/api:v1"}, "db": {"image": "postgres:16"}}}`)
+		case strings.Contains(cmd, "GOPLOY_IMAGE_TAG"):
+			io.WriteString(stdout, "v1\n")
+		default:
+			return 127
+		}
+		return 0
+	})
+	client := server.connect(t)
+
+	project := config.Project{Host: "web1", Path: "/srv/app", Strategy: config.StrategyImage}
+	plan, err := (&SSHClient{}).planImage(context.Background(), client, project, "v2")
+	require.NoError(t, err)
+
+	assert.Equal(t, "v1", plan.Current)
+	assert.Equal(t, "v2", plan.Target)
+	assert.Equal(t, []ServiceChange{{Service: "api", Change: ServiceImage, From: "registry/api:v1", To: "registry/api:v2"}}, plan.Services)
+}
+
+func TestPlan_Locks(t *testing.T) {
+	origin, shas := initRepo(t, 1)
+	fakeDocker(t)
+
+	dir := filepath.Join(t.TempDir(), "app")
+	out, err := exec.Command("git", "clone", "--quiet", origin, dir).CombinedOutput()
+	require.NoError(t, err, string(out))
+	project := config.Project{Name: "app", Host: config.LocalHost, Path: dir}
+
+	plan, err := NewSSHClient(nil).Plan(context.Background(), project, "")
+	require.NoError(t, err)
+	assert.Equal(t, []HostPlan{{Host: config.LocalHost, Current: shas[0], Target: shas[0]}}, plan.Hosts)
+	assert.NoDirExists(t, filepath.Join(dir, remoteLockDir))
+
+	// The checkout is not fetched while a deployment holds the lock.
+	require.NoError(t, os.Mkdir(filepath.Join(dir, remoteLockDir), 0o755))
+	_, err = NewSSHClient(nil).Plan(context.Background(), project, "")
+	var lockErr *LockError
+	assert.ErrorAs(t, err, &lockErr)
+}
+
+func TestPlan_RejectsUploads(t *testing.T) {
+	project := config.Project{Host: "web1", Path: "/srv/app", Strategy: config.StrategyUpload, Upload: &config.Upload{Path: "dist"}}
+	_, err := (&SSHClient{}).Plan(context.Background(), project, "")
+	assert.EqualError(t, err, "upload deployments cannot be planned, the local directory is deployed as is")
+	var requestErr *PlanRequestError
+	assert.ErrorAs(t, err, &requestErr)
+}
+
+func TestParseComposeServices(t *testing.T) {
+	services, err := parseComposeServices(`/srv/app
+{"services": {
+	"web": {"build": {"context": "/srv/app"}},
+	"api": {"image": "app/api", "build": {"context": "/srv/app/services/api"}},
+	"docs": {"build": {"context": "https://github.com/acme/docs.git"}},
+	"db": {"image": "postgres:16"}
+}}`)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]composeService{
+		"web":  {context: "."},
+		"api":  {image: "app/api", context: "services/api"},
+		"docs": {},
+		"db":   {image: "postgres:16"},
+	}, services)
+
+	_, err = parseComposeServices("/srv/app\nno such service: api")
+	assert.Error(t, err)
+
+	// docker-compose v1 and podman-compose print YAML.
+	services, err = parseComposeServices(`/srv/app
+services:
+  web:
+    build:
+      context: /srv/app/web
+  db:
+    image: postgres:16
+`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]composeService{"web": {context: "web"}, "db": {image: "postgres:16"}}, services)
+}
+
+func TestDiffServices_Rebuild(t *testing.T) {
+	services := map[string]composeService{"web": {context: "."}, "api": {context: "api"}, "apidocs": {context: "apidocs"}}
+	files := []ChangedFile{{Status: "M", Path: "api/main.go"}}
+
+	assert.Equal(t, []ServiceChange{
+		{Service: "api", Change: ServiceRebuild},
+		{Service: "web", Change: ServiceRebuild},
+	}, diffServices(services, services, files))
+	assert.Empty(t, diffServices(services, services, nil))
+}
@@ -0,0 +1,220 @@
+package deployment
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// poolIdleTimeout closes pooled connections that have not been used for this long.
+	poolIdleTimeout = 5 * time.Minute
+	// poolKeepaliveInterval is how often pooled connections are checked for liveness.
+	poolKeepaliveInterval = 30 * time.Second
+	// keepaliveTimeout bounds a single keepalive round trip, hung connections are closed.
+	keepaliveTimeout = 10 * time.Second
+)
+
+var errKeepaliveTimeout = errors.New("ssh keepalive timed out")
+
+// connPool shares authenticated SSH connections per endpoint (user@host:port).
+// Every command opens its own session on a pooled connection. Dropped connections
+// are removed from the pool, so the next caller transparently redials.
+type connPool struct {
+	mu      sync.Mutex
+	conns   map[string]*pooledConn
+	janitor bool
+}
+
+type pooledConn struct {
+	ready    chan struct{} // closed once dialing has finished
+	client   *ssh.Client
+	err      error
+	refs     int
+	lastUsed time.Time
+}
+
+// get returns a pooled connection for key, dialing a new one if needed.
+// The returned func must be called once the caller is done with the connection.
+func (p *connPool) get(key string, dial func() (*ssh.Client, error)) (*ssh.Client, func(), error) {
+	// A pooled connection may have died silently (e.g. after a network change),
+	// so a failed liveness check triggers one redial.
+	for attempt := 0; ; attempt++ {
+		conn, idle, err := p.acquire(key, dial)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if attempt > 0 || idle < poolKeepaliveInterval || keepalive(conn.client) == nil {
+			return conn.client, func() { p.release(conn) }, nil
+		}
+
+		p.discard(key, conn)
+		p.release(conn)
+	}
+}
+
+// acquire returns the pooled connection for key and how long it has been idle.
+func (p *connPool) acquire(key string, dial func() (*ssh.Client, error)) (*pooledConn, time.Duration, error) {
+	p.mu.Lock()
+	if p.conns == nil {
+		p.conns = make(map[string]*pooledConn)
+	}
+
+	conn, ok := p.conns[key]
+	if !ok {
+		conn = &pooledConn{ready: make(chan struct{}), lastUsed: time.Now()}
+		p.conns[key] = conn
+	}
+	conn.refs++
+	idle := time.Since(conn.lastUsed)
+	p.mu.Unlock()
+
+	if !ok {
+		conn.client, conn.err = dial()
+		close(conn.ready)
+
+		if conn.err == nil {
+			go p.watch(key, conn)
+			p.startJanitor()
+		}
+	}
+
+	<-conn.ready
+	if conn.err != nil {
+		p.discard(key, conn)
+		p.release(conn)
+		return nil, 0, conn.err
+	}
+
+	return conn, idle, nil
+}
+
+func (p *connPool) release(conn *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conn.refs--
+	conn.lastUsed = time.Now()
+}
+
+// discard removes conn from the pool and closes it.
+func (p *connPool) discard(key string, conn *pooledConn) {
+	p.mu.Lock()
+	if p.conns[key] == conn {
+		delete(p.conns, key)
+	}
+	p.mu.Unlock()
+
+	if conn.client != nil {
+		conn.client.Close()
+	}
+}
+
+// watch removes the connection from the pool once the remote side closed it.
+func (p *connPool) watch(key string, conn *pooledConn) {
+	_ = conn.client.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conns[key] == conn {
+		delete(p.conns, key)
+	}
+}
+
+func (p *connPool) startJanitor() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.janitor {
+		return
+	}
+	p.janitor = true
+	go p.runJanitor()
+}
+
+// runJanitor periodically closes idle connections and keeps the remaining ones alive.
+// It exits once the pool is empty and is restarted by the next dial.
+func (p *connPool) runJanitor() {
+	ticker := time.NewTicker(poolKeepaliveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		idle, active, empty := p.sweep()
+
+		for _, conn := range idle {
+			conn.client.Close()
+		}
+		for key, conn := range active {
+			if err := keepalive(conn.client); err != nil {
+				p.discard(key, conn)
+			}
+		}
+
+		if empty {
+			return
+		}
+	}
+}
+
+func (p *connPool) sweep() (idle []*pooledConn, active map[string]*pooledConn, empty bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	active = make(map[string]*pooledConn)
+	for key, conn := range p.conns {
+		select {
+		case <-conn.ready:
+		default:
+			continue // still dialing
+		}
+
+		if conn.refs == 0 && time.Since(conn.lastUsed) > poolIdleTimeout {
+			delete(p.conns, key)
+			idle = append(idle, conn)
+			continue
+		}
+		active[key] = conn
+	}
+
+	if len(p.conns) == 0 {
+		p.janitor = false
+		return idle, active, true
+	}
+	return idle, active, false
+}
+
+// close closes all pooled connections.
+func (p *connPool) close() {
+	p.mu.Lock()
+	conns := p.conns
+	p.conns = nil
+	p.mu.Unlock()
+
+	for _, conn := range conns {
+		<-conn.ready
+		if conn.client != nil {
+			conn.client.Close()
+		}
+	}
+}
+
+// keepalive sends an OpenSSH keepalive request and waits for the reply.
+func keepalive(client *ssh.Client) error {
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(keepaliveTimeout):
+		client.Close()
+		return errKeepaliveTimeout
+	}
+}
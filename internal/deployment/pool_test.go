@@ -0,0 +1,113 @@
+package deployment
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestConnPool_SharesConnections(t *testing.T) {
+	server := newTestSSHServer(t, func(cmd string, _ io.Reader, stdout, _ io.Writer, _ <-chan string) int {
+		io.WriteString(stdout, "ran "+cmd)
+		return 0
+	})
+
+	var pool connPool
+	defer pool.close()
+
+	first, releaseFirst, err := pool.get("test@host:22", server.dial)
+	require.NoError(t, err)
+	second, releaseSecond, err := pool.get("test@host:22", server.dial)
+	require.NoError(t, err)
+
+	assert.Same(t, first, second)
+	assert.Equal(t, 1, server.dialCount())
+
+	// Every command gets its own session on the shared connection
+	c := &SSHClient{}
+	var a, b strings.Builder
+	require.NoError(t, c.runSession(sshExecutor{first}, "echo a", &a, io.Discard, nil))
+	require.NoError(t, c.runSession(sshExecutor{second}, "echo b", &b, io.Discard, nil))
+	assert.Equal(t, "ran echo a", a.String())
+	assert.Equal(t, "ran echo b", b.String())
+	assert.Equal(t, []string{"echo a", "echo b"}, server.executed())
+
+	releaseFirst()
+	releaseSecond()
+
+	third, releaseThird, err := pool.get("test@host:22", server.dial)
+	require.NoError(t, err)
+	defer releaseThird()
+	assert.Same(t, first, third)
+	assert.Equal(t, 1, server.dialCount())
+}
+
+func TestConnPool_RedialsDroppedConnections(t *testing.T) {
+	server := newTestSSHServer(t, nil)
+
+	var pool connPool
+	defer pool.close()
+
+	first, release, err := pool.get("test@host:22", server.dial)
+	require.NoError(t, err)
+	release()
+
+	server.closeConnections()
+
+	// The watcher removes the dropped connection from the pool
+	require.Eventually(t, func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return len(pool.conns) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	second, release, err := pool.get("test@host:22", server.dial)
+	require.NoError(t, err)
+	defer release()
+
+	assert.NotSame(t, first, second)
+	assert.Equal(t, 2, server.dialCount())
+	assert.NoError(t, keepalive(second))
+}
+
+func TestConnPool_DialError(t *testing.T) {
+	var pool connPool
+
+	_, _, err := pool.get("test@unreachable:22", func() (*ssh.Client, error) {
+		return nil, assert.AnError
+	})
+	require.ErrorIs(t, err, assert.AnError)
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	assert.Empty(t, pool.conns)
+}
+
+func TestConnPool_SweepClosesIdleConnections(t *testing.T) {
+	server := newTestSSHServer(t, nil)
+
+	var pool connPool
+	defer pool.close()
+
+	_, release, err := pool.get("idle@host:22", server.dial)
+	require.NoError(t, err)
+	release()
+
+	_, releaseActive, err := pool.get("active@host:22", server.dial)
+	require.NoError(t, err)
+	defer releaseActive()
+
+	pool.mu.Lock()
+	pool.conns["idle@host:22"].lastUsed = time.Now().Add(-2 * poolIdleTimeout)
+	pool.mu.Unlock()
+
+	idle, active, empty := pool.sweep()
+	assert.Len(t, idle, 1)
+	assert.Contains(t, active, "active@host:22")
+	assert.False(t, empty)
+}
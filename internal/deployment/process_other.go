@@ -0,0 +1,33 @@
+//go:build !unix
+
+package deployment
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Process groups are only set up on unix, elsewhere the shell is signalled (killed) on its own.
+
+func processGroupAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+func terminalSessionAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// signalProcessGroup kills process for SIGKILL, other signals can't be sent.
+func signalProcessGroup(process *os.Process, sig ssh.Signal) error {
+	if sig != ssh.SIGKILL {
+		return fmt.Errorf("unsupported signal %s", sig)
+	}
+	return process.Kill()
+}
+
+func killProcessGroup(process *os.Process) error {
+	return process.Kill()
+}
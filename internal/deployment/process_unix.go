@@ -0,0 +1,42 @@
+//go:build unix
+
+package deployment
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var localSignals = map[ssh.Signal]syscall.Signal{
+	ssh.SIGHUP:  syscall.SIGHUP,
+	ssh.SIGINT:  syscall.SIGINT,
+	ssh.SIGKILL: syscall.SIGKILL,
+	ssh.SIGTERM: syscall.SIGTERM,
+}
+
+// processGroupAttr starts a process group of its own, signals reach the commands started by the shell too.
+func processGroupAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminalSessionAttr starts a session of its own with the pty as controlling terminal (stdin of the shell).
+func terminalSessionAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true, Setctty: true}
+}
+
+// signalProcessGroup sends sig to the process group led by process.
+func signalProcessGroup(process *os.Process, sig ssh.Signal) error {
+	s, ok := localSignals[sig]
+	if !ok {
+		return fmt.Errorf("unsupported signal %s", sig)
+	}
+	return syscall.Kill(-process.Pid, s)
+}
+
+// killProcessGroup kills the process group led by process.
+func killProcessGroup(process *os.Process) error {
+	return syscall.Kill(-process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,271 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/shell"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	provisionPhase = "provision"
+	hostKeyPhase   = "host key"
+
+	defaultDeployUser = "deploy"
+
+	// caddyAdmin is where Caddy serves its admin API by default, see config.CaddyConfig.AdminURL.
+	caddyAdmin = "http://localhost:2019"
+)
+
+// supportedOS are the /etc/os-release IDs Docker's install script supports.
+var supportedOS = []string{"ubuntu", "debian", "raspbian", "centos", "fedora", "rhel"}
+
+// userNamePattern matches portable user names, they are used unquoted in commands.
+var userNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// ProvisionOptions configures Provision.
+type ProvisionOptions struct {
+	// Host is the server, [user@]host[:port] or an ssh_config alias. Its user must be root or allowed to sudo without a password.
+	Host         string
+	IdentityFile string
+	// DeployUser is created and added to the docker group, defaults to deploy.
+	DeployUser string
+	// PublicKey is the authorized_keys line installed for DeployUser.
+	PublicKey string
+	// Caddy installs Caddy with its admin API on localhost.
+	Caddy bool
+}
+
+// provisionStep is an idempotent provisioning step, both commands run as root.
+// check exits zero if the step is done already, apply does it otherwise.
+type provisionStep struct {
+	name  string
+	check string
+	apply string
+}
+
+// Provision prepares a fresh server for goploy: it adds the host key to known_hosts, checks the OS, installs
+// Docker Engine and the compose plugin, creates the deploy user, adds it to the docker group and authorizes
+// the public key, optionally installs Caddy. Steps done already are reported as skipped, the first failing
+// step ends provisioning. The host key is trusted on first use, a changed key fails with a HostKeyMismatchError.
+func (c *SSHClient) Provision(ctx context.Context, opts ProvisionOptions, events EventSink) error {
+	if opts.DeployUser == "" {
+		opts.DeployUser = defaultDeployUser
+	}
+	if !userNamePattern.MatchString(opts.DeployUser) {
+		return fmt.Errorf("invalid user name %q", opts.DeployUser)
+	}
+	key, err := authorizedKey(opts.PublicKey)
+	if err != nil {
+		return err
+	}
+	opts.PublicKey = key
+
+	sshCfg, err := userSSHConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read ssh config: %w", err)
+	}
+	ep := resolveEndpoint(config.Project{Host: opts.Host, IdentityFile: opts.IdentityFile}, sshCfg)
+
+	start := time.Now()
+
+	client, err := c.dialProvision(ep, events)
+	if err == nil {
+		defer client.Close()
+		err = c.provisionHost(ctx, sshExecutor{client}, opts, events)
+	}
+
+	events.Emit(Event{Type: EventResult, Time: time.Now(), Phase: provisionPhase, Outcome: newOutcome(start, err)})
+
+	return err
+}
+
+// dialProvision connects to the server, adding its host key to known_hosts if it is unknown (trust on first use).
+// Jump hosts have to be known already.
+func (c *SSHClient) dialProvision(ep endpoint, events EventSink) (*ssh.Client, error) {
+	start := time.Now()
+	events.Emit(Event{Type: EventPhaseStarted, Time: start, Phase: hostKeyPhase, Text: ep.addr()})
+
+	var added ssh.PublicKey
+	client, err := dialChain(append(ep.jumps, ep.hop), func(h hop) (*ssh.ClientConfig, error) {
+		if h.addr() != ep.addr() || h.user != ep.user {
+			return clientConfig(h, strictUnknown)
+		}
+		return clientConfig(h, func(hostname string, key ssh.PublicKey) error {
+			if err := addKnownHost(h.knownHostsFiles[0], hostname, key); err != nil {
+				return err
+			}
+			added = key
+			return nil
+		})
+	})
+
+	outcome := newOutcome(start, err)
+	if err == nil && added != nil {
+		emitMessage(events, hostKeyPhase, "Added %s key %s to %s", added.Type(), ssh.FingerprintSHA256(added), ep.knownHostsFiles[0])
+	} else if err == nil {
+		outcome.Skipped = true
+	}
+	events.Emit(Event{Type: EventPhaseFinished, Time: time.Now(), Phase: hostKeyPhase, Outcome: outcome})
+
+	return client, err
+}
+
+// provisionHost checks the OS and runs the provisioning steps on a connected server.
+func (c *SSHClient) provisionHost(ctx context.Context, client executor, opts ProvisionOptions, events EventSink) error {
+	asRoot, err := c.rootCommand(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	if err := c.checkOS(ctx, client, events); err != nil {
+		return err
+	}
+
+	for _, s := range provisionSteps(opts) {
+		if err := c.runProvisionStep(ctx, client, asRoot, s, events); err != nil {
+			return fmt.Errorf("step %s failed: %w", s.name, err)
+		}
+	}
+	return nil
+}
+
+// rootCommand returns a func wrapping commands to run as root, through sudo unless the login user is root.
+func (c *SSHClient) rootCommand(ctx context.Context, client executor) (func(string) string, error) {
+	var b strings.Builder
+	if err := c.runSession(client, "id -u", &b, io.Discard, ctx); err != nil {
+		return nil, fmt.Errorf("failed to determine the remote user: %w", err)
+	}
+	if strings.TrimSpace(b.String()) == "0" {
+		return func(cmd string) string { return "sh -c " + shell.Quote(cmd) }, nil
+	}
+
+	if err := c.runSession(client, "sudo -n true", io.Discard, io.Discard, ctx); err != nil {
+		return nil, errors.New("provisioning requires root or passwordless sudo")
+	}
+	return func(cmd string) string { return "sudo -n sh -c " + shell.Quote(cmd) }, nil
+}
+
+// checkOS reports the distribution of the server, it fails for distributions Docker's install script doesn't support.
+func (c *SSHClient) checkOS(ctx context.Context, client executor, events EventSink) error {
+	start := time.Now()
+	events.Emit(Event{Type: EventPhaseStarted, Time: start, Phase: "os", Command: "cat /etc/os-release"})
+
+	var b strings.Builder
+	err := c.runSession(client, `. /etc/os-release && echo "$ID $VERSION_ID"`, &b, io.Discard, ctx)
+	if err == nil {
+		id, version, _ := strings.Cut(strings.TrimSpace(b.String()), " ")
+		emitMessage(events, "os", "%s %s", id, version)
+		if !slices.Contains(supportedOS, id) {
+			err = fmt.Errorf("unsupported OS %q, supported are %s", id, strings.Join(supportedOS, ", "))
+		}
+	}
+
+	events.Emit(Event{Type: EventPhaseFinished, Time: time.Now(), Phase: "os", Outcome: newOutcome(start, err)})
+	return err
+}
+
+// runProvisionStep applies a step unless its check passes, reporting it as a phase that is done, skipped or failed.
+func (c *SSHClient) runProvisionStep(ctx context.Context, client executor, asRoot func(string) string, s provisionStep, events EventSink) error {
+	start := time.Now()
+	events.Emit(Event{Type: EventPhaseStarted, Time: start, Phase: s.name, Command: s.apply})
+
+	err := c.runSession(client, asRoot(s.check), io.Discard, io.Discard, ctx)
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		events.Emit(Event{Type: EventPhaseFinished, Time: time.Now(), Phase: s.name, Outcome: &Outcome{Duration: time.Since(start), Skipped: true}})
+		return nil
+	case errors.As(err, &exitErr):
+		stdout := newLogWriter(events, s.name, StreamStdout)
+		stderr := newLogWriter(events, s.name, StreamStderr)
+		err = c.runSession(client, asRoot(s.apply), stdout, stderr, ctx)
+		stdout.Flush()
+		stderr.Flush()
+	}
+
+	events.Emit(Event{Type: EventPhaseFinished, Time: time.Now(), Phase: s.name, Outcome: newOutcome(start, err)})
+	return err
+}
+
+// provisionSteps are the steps after the OS check, in order.
+func provisionSteps(opts ProvisionOptions) []provisionStep {
+	user := opts.DeployUser
+	home := fmt.Sprintf(`h="$(getent passwd %s | cut -d: -f6)"`, user)
+	keys := `"$h/.ssh/authorized_keys"`
+
+	steps := []provisionStep{
+		{
+			name:  "docker",
+			check: "command -v docker",
+			// Docker's install script sets up its package repository and installs the engine and the compose plugin.
+			apply: "{ curl -fsSL https://get.docker.com || wget -qO- https://get.docker.com; } | sh && systemctl enable --now docker",
+		},
+		{
+			name:  "compose plugin",
+			check: "docker compose version",
+			apply: "if command -v apt-get; then apt-get update -q && apt-get install -y -q docker-compose-plugin; else dnf install -y docker-compose-plugin; fi",
+		},
+		{
+			name:  "deploy user",
+			check: "id -u " + user,
+			apply: fmt.Sprintf("useradd --create-home --shell /bin/bash %s", user),
+		},
+		{
+			name:  "docker group",
+			check: fmt.Sprintf("id -nG %s | tr ' ' '\\n' | grep -qx docker", user),
+			apply: fmt.Sprintf("usermod -aG docker %s", user),
+		},
+		{
+			name:  "authorized key",
+			check: fmt.Sprintf("%s && grep -qxF %s %s", home, shell.Quote(opts.PublicKey), keys),
+			apply: fmt.Sprintf(`%[1]s && install -d -m 700 -o %[2]s "$h/.ssh" && printf '%%s\n' %[3]s >> %[4]s && chown %[2]s: "$h/.ssh" %[4]s && chmod 600 %[4]s`,
+				home, user, shell.Quote(opts.PublicKey), keys),
+		},
+	}
+
+	if opts.Caddy {
+		steps = append(steps,
+			provisionStep{
+				name:  "caddy",
+				check: "command -v caddy",
+				apply: "if command -v apt-get; then " +
+					"apt-get install -y -q debian-keyring debian-archive-keyring apt-transport-https curl gpg && " +
+					"curl -1sLf https://dl.cloudsmith.io/public/caddy/stable/gpg.key | gpg --batch --yes --dearmor -o /usr/share/keyrings/caddy-stable-archive-keyring.gpg && " +
+					"curl -1sLf https://dl.cloudsmith.io/public/caddy/stable/debian.deb.txt > /etc/apt/sources.list.d/caddy-stable.list && " +
+					"apt-get update -q && apt-get install -y -q caddy; " +
+					"else dnf install -y 'dnf-command(copr)' && dnf copr enable -y @caddy/caddy && dnf install -y caddy; fi",
+			},
+			provisionStep{
+				name:  "caddy admin",
+				check: fmt.Sprintf("curl -fsS -o /dev/null %s/config/", caddyAdmin),
+				// Without an admin option in the Caddyfile, Caddy serves its admin API on localhost only.
+				apply: fmt.Sprintf("systemctl enable --now caddy && curl -fsS -o /dev/null --retry 5 --retry-connrefused %s/config/", caddyAdmin),
+			},
+		)
+	}
+
+	return steps
+}
+
+// authorizedKey validates a public key and returns it as a single authorized_keys line.
+func authorizedKey(publicKey string) (string, error) {
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	if comment != "" {
+		line += " " + comment
+	}
+	return line, nil
+}
@@ -0,0 +1,120 @@
+package deployment
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newPublicKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return key
+}
+
+// provisionHandler simulates an ubuntu server with docker installed, logged in as a sudo user.
+// Checks of the other steps fail, applies fail for the commands in failing.
+func provisionHandler(osRelease string, failing ...string) testHandler {
+	return func(cmd string, _ io.Reader, stdout, _ io.Writer, _ <-chan string) int {
+		switch {
+		case cmd == "id -u":
+			io.WriteString(stdout, "1000\n")
+		case strings.Contains(cmd, "/etc/os-release"):
+			io.WriteString(stdout, osRelease+"\n")
+		case cmd == "sudo -n sh -c 'command -v docker'":
+			return 0
+		case strings.HasPrefix(cmd, "sudo -n sh -c 'id -u "),
+			strings.Contains(cmd, "grep -q"),
+			strings.Contains(cmd, "docker compose version"),
+			strings.Contains(cmd, "command -v caddy"),
+			strings.HasPrefix(cmd, "sudo -n sh -c 'curl"):
+			return 1
+		}
+		for _, f := range failing {
+			if strings.Contains(cmd, f) {
+				return 1
+			}
+		}
+		return 0
+	}
+}
+
+func provisionOptions(t *testing.T) ProvisionOptions {
+	key, err := authorizedKey(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(newPublicKey(t)))) + " dev@laptop")
+	require.NoError(t, err)
+	return ProvisionOptions{Host: "web1", DeployUser: "deploy", PublicKey: key}
+}
+
+func TestProvisionHost(t *testing.T) {
+	server := newTestSSHServer(t, provisionHandler("ubuntu 24.04"))
+	client := server.connect(t)
+
+	sink := &recordingSink{}
+	require.NoError(t, (&SSHClient{}).provisionHost(context.Background(), client, provisionOptions(t), sink))
+
+	outcomes := map[string]*Outcome{}
+	for _, e := range sink.ofType(EventPhaseFinished) {
+		outcomes[e.Phase] = e.Outcome
+	}
+	assert.Len(t, outcomes, 6)
+	assert.True(t, outcomes["docker"].Skipped)
+	for _, phase := range []string{"os", "compose plugin", "deploy user", "docker group", "authorized key"} {
+		assert.False(t, outcomes[phase].Skipped, phase)
+		assert.Empty(t, outcomes[phase].Error, phase)
+	}
+
+	executed := strings.Join(server.executed(), "\n")
+	assert.Contains(t, executed, "sudo -n sh -c 'useradd --create-home --shell /bin/bash deploy'")
+	assert.Contains(t, executed, "sudo -n sh -c 'usermod -aG docker deploy'")
+	assert.Contains(t, executed, " dev@laptop")
+	assert.NotContains(t, executed, "get.docker.com")
+	assert.NotContains(t, executed, "caddy")
+}
+
+func TestProvisionHost_Failures(t *testing.T) {
+	server := newTestSSHServer(t, provisionHandler("ubuntu 24.04", "useradd"))
+	client := server.connect(t)
+
+	opts := provisionOptions(t)
+	opts.Caddy = true
+	err := (&SSHClient{}).provisionHost(context.Background(), client, opts, &recordingSink{})
+	assert.EqualError(t, err, "step deploy user failed: Process exited with status 1")
+	assert.NotContains(t, strings.Join(server.executed(), "\n"), "usermod")
+
+	server = newTestSSHServer(t, provisionHandler("alpine 3.20"))
+	client = server.connect(t)
+
+	err = (&SSHClient{}).provisionHost(context.Background(), client, provisionOptions(t), &recordingSink{})
+	assert.EqualError(t, err, `unsupported OS "alpine", supported are ubuntu, debian, raspbian, centos, fedora, rhel`)
+}
+
+func TestProvisionSteps_Caddy(t *testing.T) {
+	opts := provisionOptions(t)
+	opts.Caddy = true
+
+	var names []string
+	for _, s := range provisionSteps(opts) {
+		names = append(names, s.name)
+	}
+	assert.Equal(t, []string{"docker", "compose plugin", "deploy user", "docker group", "authorized key", "caddy", "caddy admin"}, names)
+}
+
+func TestProvision_InvalidOptions(t *testing.T) {
+	c := &SSHClient{}
+	assert.EqualError(t, c.Provision(context.Background(), ProvisionOptions{Host: "web1", DeployUser: "deploy; rm"}, &recordingSink{}), `invalid user name "deploy; rm"`)
+
+	err := c.Provision(context.Background(), ProvisionOptions{Host: "web1", PublicKey: "not a key"}, &recordingSink{})
+	assert.ErrorContains(t, err, "invalid public key")
+
+}
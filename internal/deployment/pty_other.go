@@ -0,0 +1,17 @@
+//go:build !linux
+
+package deployment
+
+import (
+	"errors"
+	"os"
+)
+
+// openPTY is only implemented on Linux, shells of local projects get the terminal of goploy elsewhere.
+func openPTY() (pty, tty *os.File, err error) {
+	return nil, nil, errors.ErrUnsupported
+}
+
+func setPTYSize(*os.File, int, int) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,122 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pmaojo/goploy/internal/config"
+)
+
+// hostConn is a connection to a single host of a project, project targets only that host.
+type hostConn struct {
+	project config.Project
+	client  executor
+}
+
+// connectHosts connects to every host of the project, in order, and resolves the runtime of each host.
+// The returned func releases all connections.
+func (c *SSHClient) connectHosts(ctx context.Context, project config.Project) ([]hostConn, func(), error) {
+	var hosts []hostConn
+	var releases []func()
+	releaseAll := func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+
+	for _, host := range project.HostList() {
+		p := project.OnHost(host)
+		client, release, err := c.connect(ctx, p)
+		if err != nil {
+			releaseAll()
+			return nil, nil, forHost(project, host, err)
+		}
+
+		hosts = append(hosts, hostConn{project: c.resolveRuntime(ctx, client, p), client: client})
+		releases = append(releases, release)
+	}
+
+	return hosts, releaseAll, nil
+}
+
+// rollout runs fn on the hosts in order, rollout.parallel of them at a time, and halts
+// once a host failed. Events of multi-host projects are tagged with the host.
+func (c *SSHClient) rollout(project config.Project, hosts []hostConn, events EventSink, fn func(h hostConn, events EventSink) error) error {
+	size := 1
+	if project.Rollout != nil && project.Rollout.Parallel > 1 {
+		size = project.Rollout.Parallel
+	}
+
+	for i := 0; i < len(hosts); i += size {
+		batch := hosts[i:min(i+size, len(hosts))]
+
+		errs := make([]error, len(batch))
+		var wg sync.WaitGroup
+		for j, h := range batch {
+			hostEvents := events
+			if len(hosts) > 1 {
+				hostEvents = withHost(events, h.project.Host)
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := fn(h, hostEvents); err != nil {
+					errs[j] = forHost(project, h.project.Host, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if err := joinErrors(errs); err != nil {
+			if rest := hosts[i+len(batch):]; len(rest) > 0 {
+				emitMessage(events, "", "Rollout halted, skipping %s", hostNames(rest))
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// withHost tags all events with host.
+func withHost(events EventSink, host string) EventSink {
+	return EventFunc(func(event Event) {
+		event.Host = host
+		events.Emit(event)
+	})
+}
+
+// forHost names the host in err for multi-host projects.
+func forHost(project config.Project, host string, err error) error {
+	if len(project.HostList()) < 2 {
+		return err
+	}
+	return fmt.Errorf("%s: %w", host, err)
+}
+
+// joinErrors joins the non-nil errors, a single error is returned as is.
+func joinErrors(errs []error) error {
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+
+	if len(failed) == 1 {
+		return failed[0]
+	}
+	return errors.Join(failed...)
+}
+
+func hostNames(hosts []hostConn) string {
+	names := make([]string, len(hosts))
+	for i, h := range hosts {
+		names[i] = h.project.Host
+	}
+	return strings.Join(names, ", ")
+}
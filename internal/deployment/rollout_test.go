@@ -0,0 +1,144 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testHosts(project config.Project) []hostConn {
+	var hosts []hostConn
+	for _, h := range project.HostList() {
+		hosts = append(hosts, hostConn{project: project.OnHost(h)})
+	}
+	return hosts
+}
+
+func TestRollout_HaltsOnFailure(t *testing.T) {
+	project := config.Project{Name: "api", Hosts: []string{"web1", "web2", "web3"}}
+	sink := &recordingSink{}
+
+	var deployed []string
+	c := &SSHClient{}
+	err := c.rollout(project, testHosts(project), sink, func(h hostConn, events EventSink) error {
+		deployed = append(deployed, h.project.Host)
+		events.Emit(Event{Type: EventPhaseStarted, Phase: "up"})
+		if h.project.Host == "web2" {
+			return errors.New("step up failed")
+		}
+		return nil
+	})
+
+	assert.EqualError(t, err, "web2: step up failed")
+	assert.Equal(t, []string{"web1", "web2"}, deployed)
+
+	started := sink.ofType(EventPhaseStarted)
+	require.Len(t, started, 2)
+	assert.Equal(t, "web1", started[0].Host)
+	assert.Equal(t, "web2", started[1].Host)
+
+	messages := sink.ofType(EventMessage)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "Rollout halted, skipping web3", messages[0].Text)
+}
+
+func TestRollout_Parallel(t *testing.T) {
+	project := config.Project{Name: "api", Hosts: []string{"web1", "web2", "web3"}, Rollout: &config.Rollout{Parallel: 2}}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	c := &SSHClient{}
+	err := c.rollout(project, testHosts(project), &recordingSink{}, func(h hostConn, _ EventSink) error {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, maxRunning)
+}
+
+func TestRollout_SingleHost(t *testing.T) {
+	project := config.Project{Name: "api", Host: "web1"}
+	sink := &recordingSink{}
+	failure := errors.New("step fetch failed")
+
+	c := &SSHClient{}
+	err := c.rollout(project, testHosts(project), sink, func(h hostConn, events EventSink) error {
+		events.Emit(Event{Type: EventPhaseStarted, Phase: "fetch"})
+		return failure
+	})
+
+	assert.Same(t, failure, err)
+	assert.Empty(t, sink.ofType(EventPhaseStarted)[0].Host)
+	assert.Empty(t, sink.ofType(EventMessage))
+}
+
+func TestAggregateStatus(t *testing.T) {
+	t1 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+
+	hosts := []HostStatus{
+		{Host: "web1", Branch: "main", Commit: "bbb", Status: "Healthy", LastDeployedAt: t2, Containers: []ContainerStatus{{Name: "web", State: "running"}}},
+		{Host: "web2", Branch: "main", Commit: "aaa", Status: "Healthy", LastDeployedAt: t1, Containers: []ContainerStatus{{Name: "web", State: "running"}}},
+		{Host: "web3", Status: "Unreachable", Error: "connection failed"},
+	}
+
+	status := aggregateStatus("api", hosts)
+	assert.Equal(t, "Partial", status.Status)
+	assert.Equal(t, "main", status.Branch)
+	assert.Equal(t, t2, status.LastDeployedAt)
+	assert.Len(t, status.Containers, 2)
+	require.Len(t, status.Hosts, 3)
+	assert.False(t, status.Hosts[0].Behind)
+	assert.True(t, status.Hosts[1].Behind)
+	assert.False(t, status.Hosts[2].Behind)
+
+	status = aggregateStatus("api", hosts[:1])
+	assert.Equal(t, "Healthy", status.Status)
+}
+
+func TestLock_ReleasesHostsOnConflict(t *testing.T) {
+	free := newTestSSHServer(t, nil)
+	held := newTestSSHServer(t, func(cmd string, _ io.Reader, stdout, _ io.Writer, _ <-chan string) int {
+		if strings.Contains(cmd, "mkdir") {
+			io.WriteString(stdout, "alice@laptop via tui (deploy)\n")
+			return lockHeldExitCode
+		}
+		return 0
+	})
+
+	var hosts []hostConn
+	for _, s := range []*testSSHServer{free, held} {
+		hosts = append(hosts, hostConn{project: config.Project{Name: "api", Host: s.addr, Path: "/srv/api"}, client: s.connect(t)})
+	}
+
+	c := &SSHClient{}
+	_, err := c.lock(context.Background(), hosts, "deploy")
+
+	var lockErr *LockError
+	require.ErrorAs(t, err, &lockErr)
+	assert.Equal(t, "alice@laptop via tui (deploy)", lockErr.Holder)
+
+	// The lock taken on the first host is given back, as is the in-process one.
+	executed := free.executed()
+	require.Len(t, executed, 2)
+	assert.Contains(t, executed[1], "rm -rf .goploy.lock")
+	require.NoError(t, c.locks.tryLock("api", "test"))
+}
@@ -0,0 +1,205 @@
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+)
+
+// containerRuntime is a compose CLI of the hosts, see config.Compose.
+type containerRuntime struct {
+	name    string
+	compose string // the compose command
+	engine  string // the container CLI compose runs on, for load and inspect
+	// json is set if `ps` and `config` print JSON with --format json. docker-compose v1 and podman-compose
+	// print tables and YAML only, their containers are inspected instead.
+	json bool
+	// ignoreBuildable is the `pull` flag for services that are built instead of pulled, see pullCommand.
+	ignoreBuildable string
+}
+
+var runtimes = map[string]containerRuntime{
+	config.RuntimeDocker:        {name: "docker compose v2", compose: "docker compose", engine: "docker", json: true, ignoreBuildable: "--ignore-buildable"},
+	config.RuntimeDockerCompose: {name: "docker-compose", compose: "docker-compose", engine: "docker", ignoreBuildable: "--ignore-pull-failures"},
+	config.RuntimePodman:        {name: "podman compose", compose: "podman compose", engine: "podman"},
+}
+
+// runtimeOf returns the configured (or resolved, see resolveRuntime) runtime of the project, docker by default.
+func runtimeOf(project config.Project) containerRuntime {
+	if project.Compose != nil {
+		if rt, ok := runtimes[project.Compose.Runtime]; ok {
+			return rt
+		}
+	}
+	return runtimes[config.RuntimeDocker]
+}
+
+// detectRuntimeCommand prints the runtime of the first compose CLI that works on the host, nothing if none does.
+const detectRuntimeCommand = "if docker compose version >/dev/null 2>&1; then echo docker; " +
+	"elif docker-compose version >/dev/null 2>&1; then echo docker-compose; " +
+	"elif podman compose version >/dev/null 2>&1; then echo podman; fi"
+
+// resolveRuntime returns the project with the runtime of its host set, unless one is configured. The runtime
+// detected on a host is remembered, hosts without any compose CLI keep the docker default and are probed again
+// next time. Swarm projects always run on docker.
+func (c *SSHClient) resolveRuntime(ctx context.Context, client executor, project config.Project) config.Project {
+	if swarmStrategy(project) || (project.Compose != nil && project.Compose.Runtime != "") {
+		return project
+	}
+
+	key := c.runtimeKey(project)
+	runtime, ok := c.runtimes.Load(key)
+	if !ok {
+		var b strings.Builder
+		if err := c.runSession(client, detectRuntimeCommand, &b, io.Discard, ctx); err != nil {
+			return project
+		}
+		name := strings.TrimSpace(b.String())
+		if _, known := runtimes[name]; !known {
+			return project
+		}
+		runtime, _ = c.runtimes.LoadOrStore(key, name)
+	}
+
+	// The compose settings are shared with the other hosts of the project.
+	compose := config.Compose{}
+	if project.Compose != nil {
+		compose = *project.Compose
+	}
+	compose.Runtime = runtime.(string)
+	project.Compose = &compose
+	return project
+}
+
+// runtimeKey identifies the host runtimes are detected on.
+func (c *SSHClient) runtimeKey(project config.Project) string {
+	if c.local || project.IsLocal() {
+		return config.LocalHost
+	}
+	return fmt.Sprintf("%s@%s:%s", project.User, project.Host, project.Port)
+}
+
+// psCommand prints the containers of the stack of compose for parseContainers, compose is the compose
+// command of the stack (see composeCommand), running on the runtime of the project.
+func psCommand(project config.Project, compose string) string {
+	rt := runtimeOf(project)
+	if rt.json {
+		return compose + " ps -a --format json"
+	}
+	return fmt.Sprintf(`ids=$(%s ps -q) && { test -z "$ids" || %s inspect $ids; }`, compose, rt.engine)
+}
+
+// pullCommand pulls the images of the stack of compose (see psCommand). The images of local_build projects are
+// built and loaded instead: docker compose v2 skips them, docker-compose v1 only knows to ignore the failures of
+// pulling them and podman-compose never pulls the images it builds.
+func pullCommand(project config.Project, compose string) string {
+	rt := runtimeOf(project)
+	if project.LocalBuild == nil || rt.ignoreBuildable == "" {
+		return compose + " pull"
+	}
+	return compose + " pull " + rt.ignoreBuildable
+}
+
+// rawContainer is a container as printed by `docker compose ps --format json`, by `podman ps --format json`
+// (podman compose with the podman-compose provider) or by `docker inspect` and `podman inspect`.
+type rawContainer struct {
+	Name  string          `json:"Name"`
+	Names json.RawMessage `json:"Names"` // podman ps: a list of names
+	// State is the state of ps, an object of inspect.
+	State     json.RawMessage `json:"State"`
+	Status    string          `json:"Status"`
+	CreatedAt string          `json:"CreatedAt"`
+	// Created is a unix time for podman ps, a timestamp for inspect.
+	Created  json.RawMessage `json:"Created"`
+	ExitCode int             `json:"ExitCode"`
+	Service  string          `json:"Service"`
+	Health   string          `json:"Health"`
+	// Labels are a map for podman ps, compose joins them into a string.
+	Labels json.RawMessage `json:"Labels"`
+	Config *struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+}
+
+type inspectState struct {
+	Status   string `json:"Status"`
+	ExitCode int    `json:"ExitCode"`
+	Health   *struct {
+		Status string `json:"Status"`
+	} `json:"Health"`
+}
+
+const composeServiceLabel = "com.docker.compose.service"
+
+// normalize converts the container to the ContainerStatus of `docker compose ps`.
+func (r rawContainer) normalize() ContainerStatus {
+	c := ContainerStatus{
+		Name:      strings.TrimPrefix(r.Name, "/"),
+		Status:    r.Status,
+		CreatedAt: r.CreatedAt,
+		ExitCode:  r.ExitCode,
+		Service:   r.Service,
+		Health:    r.Health,
+	}
+
+	if c.Name == "" {
+		var names []string
+		if json.Unmarshal(r.Names, &names) == nil && len(names) > 0 {
+			c.Name = names[0]
+		}
+	}
+
+	var state inspectState
+	switch {
+	case json.Unmarshal(r.State, &c.State) == nil:
+	case json.Unmarshal(r.State, &state) == nil:
+		c.State = state.Status
+		c.ExitCode = state.ExitCode
+		if state.Health != nil && c.Health == "" {
+			c.Health = state.Health.Status
+		}
+		// Inspect has no status text.
+		c.Status = c.State
+		if c.State == "exited" {
+			c.Status = fmt.Sprintf("exited (%d)", c.ExitCode)
+		}
+	}
+
+	// ps of podman and docker itself only mention the health in the status, e.g. "Up 2 hours (healthy)".
+	if c.Health == "" {
+		switch {
+		case strings.Contains(c.Status, "(unhealthy)"):
+			c.Health = "unhealthy"
+		case strings.Contains(c.Status, "(healthy)"):
+			c.Health = "healthy"
+		case strings.Contains(c.Status, "starting)"):
+			c.Health = "starting"
+		}
+	}
+
+	if _, err := parseDockerTime(c.CreatedAt); err != nil {
+		var unix int64
+		var timestamp string
+		switch {
+		case json.Unmarshal(r.Created, &unix) == nil:
+			c.CreatedAt = time.Unix(unix, 0).UTC().Format("2006-01-02 15:04:05 -0700 MST")
+		case json.Unmarshal(r.Created, &timestamp) == nil:
+			c.CreatedAt = timestamp
+		}
+	}
+
+	if c.Service == "" {
+		var labels map[string]string
+		if json.Unmarshal(r.Labels, &labels) != nil && r.Config != nil {
+			labels = r.Config.Labels
+		}
+		c.Service = labels[composeServiceLabel]
+	}
+
+	return c
+}
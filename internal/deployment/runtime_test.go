@@ -0,0 +1,126 @@
+package deployment
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeCommands(t *testing.T) {
+	project := config.Project{Path: "/srv/app", Compose: &config.Compose{Files: []string{"prod.yml"}, Runtime: config.RuntimeDockerCompose}}
+	assert.Equal(t, "docker-compose -f 'prod.yml'", composeCommand(project))
+	assert.Equal(t, `ids=$(docker-compose -f 'prod.yml' ps -q) && { test -z "$ids" || docker inspect $ids; }`, psCommand(project, composeCommand(project)))
+	assert.Equal(t, "cd && pwd && docker-compose -f 'prod.yml' -p 'app' config", configCommand(project, "cd", ""))
+
+	project.Compose.Runtime = config.RuntimePodman
+	assert.Equal(t, "podman compose -f 'prod.yml' up -d --build", upCommand(project, composeCommand(project)))
+	assert.Equal(t, []prerequisite{
+		{name: "podman", check: "command -v podman"},
+		{name: "podman compose", check: "podman compose version"},
+		{name: "git", check: "command -v git"},
+	}, prerequisites(project))
+
+	assert.Equal(t, "docker compose ps -a --format json", psCommand(config.Project{}, "docker compose"))
+}
+
+func TestParseContainers_Podman(t *testing.T) {
+	// podman ps --format json, as printed through podman-compose
+	containers := parseContainers(`[
+  {"Names": ["app_web_1"], "State": "running", "Status": "Up 2 hours (healthy)", "Created": 1792144800,
+   "CreatedAt": "2 hours ago", "ExitCode": 0, "Labels": {"com.docker.compose.service": "web", "io.podman.compose.project": "app"}},
+  {"Names": ["app_migrate_1"], "State": "exited", "Status": "Exited (0) 2 hours ago", "Created": 1792144800,
+   "ExitCode": 0, "Labels": {"com.docker.compose.service": "migrate"}}
+]`)
+
+	assert.Equal(t, []ContainerStatus{
+		{Name: "app_web_1", State: "running", Status: "Up 2 hours (healthy)", CreatedAt: "2026-10-16 10:00:00 +0000 UTC", Service: "web", Health: "healthy"},
+		{Name: "app_migrate_1", State: "exited", Status: "Exited (0) 2 hours ago", CreatedAt: "2026-10-16 10:00:00 +0000 UTC", Service: "migrate"},
+	}, containers)
+}
+
+func TestParseContainers_Inspect(t *testing.T) {
+	// docker inspect of the containers of docker-compose v1
+	containers := parseContainers(`[
+    {
+        "Name": "/app_web_1",
+        "Created": "2026-10-16T10:00:00.123456789Z",
+        "State": {"Status": "running", "ExitCode": 0, "Health": {"Status": "starting"}},
+        "Config": {"Labels": {"com.docker.compose.project": "app", "com.docker.compose.service": "web"}}
+    },
+    {
+        "Name": "/app_worker_1",
+        "Created": "2026-10-15T08:00:00Z",
+        "State": {"Status": "exited", "ExitCode": 137},
+        "Config": {"Labels": {"com.docker.compose.service": "worker"}}
+    }
+]`)
+
+	assert.Equal(t, []ContainerStatus{
+		{Name: "app_web_1", State: "running", Status: "running", CreatedAt: "2026-10-16T10:00:00.123456789Z", Service: "web", Health: "starting"},
+		{Name: "app_worker_1", State: "exited", Status: "exited (137)", CreatedAt: "2026-10-15T08:00:00Z", ExitCode: 137, Service: "worker"},
+	}, containers)
+
+	reason, _ := evaluateHealth(containers)
+	assert.Equal(t, "container app_web_1 is still starting", reason)
+}
+
+func TestResolveRuntime(t *testing.T) {
+	server := newTestSSHServer(t, func(cmd string, _ io.Reader, stdout, _ io.Writer, _ <-chan string) int {
+		if cmd == detectRuntimeCommand {
+			io.WriteString(stdout, "podman\n")
+		}
+		return 0
+	})
+	client := server.connect(t)
+
+	c := &SSHClient{}
+	compose := &config.Compose{ProjectName: "app"}
+	project := config.Project{Host: "web1", Compose: compose}
+
+	resolved := c.resolveRuntime(context.Background(), client, project)
+	assert.Equal(t, config.Compose{ProjectName: "app", Runtime: config.RuntimePodman}, *resolved.Compose)
+	assert.Empty(t, compose.Runtime, "the configured settings are left alone")
+
+	// Detected once per host, configured runtimes are not detected.
+	c.resolveRuntime(context.Background(), client, project)
+	c.resolveRuntime(context.Background(), client, config.Project{Host: "web1", Compose: &config.Compose{Runtime: config.RuntimeDocker}})
+	assert.Equal(t, []string{detectRuntimeCommand}, server.executed())
+
+	// Hosts without compose keep the default.
+	bare := newTestSSHServer(t, func(string, io.Reader, io.Writer, io.Writer, <-chan string) int { return 0 })
+	other := c.resolveRuntime(context.Background(), bare.connect(t), config.Project{Host: "web2"})
+	assert.Nil(t, other.Compose)
+}
+
+func TestLocalHost_DockerComposeV1(t *testing.T) {
+	bin := t.TempDir()
+	docker := `#!/bin/sh
+case "$*" in
+"compose version") exit 1 ;;
+"inspect c1 c2") echo '[{"Name": "/app_web_1", "Created": "2026-10-16T10:00:00Z", "State": {"Status": "running", "ExitCode": 0},
+  "Config": {"Labels": {"com.docker.compose.service": "web"}}}]' ;;
+esac
+`
+	compose := `#!/bin/sh
+case "$*" in
+"ps -q") echo c1; echo c2 ;;
+esac
+`
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "docker"), []byte(docker), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "docker-compose"), []byte(compose), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	project := config.Project{Name: "app", Host: config.LocalHost, Path: t.TempDir()}
+	status, err := NewSSHClient(nil).GetStatus(context.Background(), project)
+	require.NoError(t, err)
+	assert.Equal(t, "Healthy", status.Status)
+	assert.Equal(t, []ContainerStatus{
+		{Name: "app_web_1", State: "running", Status: "running", CreatedAt: "2026-10-16T10:00:00Z", Service: "web"},
+	}, status.Containers)
+}
@@ -0,0 +1,333 @@
+package deployment
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/pmaojo/goploy/internal/config"
+)
+
+// maxSSHConfigIncludeDepth guards against Include loops.
+const maxSSHConfigIncludeDepth = 16
+
+// sshConfig is the subset of an OpenSSH client config (ssh_config(5)) goploy understands:
+// Host blocks with HostName, User, Port, IdentityFile, ProxyJump and UserKnownHostsFile, and Include.
+// Match blocks are not supported and never apply.
+type sshConfig struct {
+	blocks []sshConfigBlock
+}
+
+type sshConfigBlock struct {
+	patterns []string // nil for Match blocks
+	options  []sshConfigOption
+}
+
+type sshConfigOption struct {
+	keyword string // lower case
+	args    []string
+}
+
+// sshHostConfig holds the options that apply to a host alias.
+type sshHostConfig struct {
+	HostName        string
+	User            string
+	Port            string
+	IdentityFiles   []string
+	ProxyJump       string
+	KnownHostsFiles []string
+}
+
+// userSSHConfig loads ~/.ssh/config, a missing file results in an empty config.
+func userSSHConfig() (*sshConfig, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return &sshConfig{}, nil
+	}
+	return loadSSHConfig(filepath.Join(home, ".ssh", "config"))
+}
+
+func loadSSHConfig(path string) (*sshConfig, error) {
+	cfg := &sshConfig{}
+	if err := cfg.parseFile(path, []string{"*"}, 0); err != nil {
+		if os.IsNotExist(err) {
+			return &sshConfig{}, nil
+		}
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *sshConfig) parseFile(path string, patterns []string, depth int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return c.parse(f, path, patterns, depth)
+}
+
+// parse reads config lines, options before the first Host line apply to patterns.
+func (c *sshConfig) parse(r io.Reader, name string, patterns []string, depth int) error {
+	c.blocks = append(c.blocks, sshConfigBlock{patterns: patterns})
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		keyword, args, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s line %d: %w", name, line, err)
+		}
+		if keyword == "" {
+			continue
+		}
+
+		current := &c.blocks[len(c.blocks)-1]
+
+		switch keyword {
+		case "host":
+			c.blocks = append(c.blocks, sshConfigBlock{patterns: args})
+		case "match":
+			c.blocks = append(c.blocks, sshConfigBlock{})
+		case "include":
+			if depth >= maxSSHConfigIncludeDepth {
+				return fmt.Errorf("%s line %d: too many nested includes", name, line)
+			}
+			blockPatterns := current.patterns
+			for _, arg := range args {
+				if err := c.include(arg, blockPatterns, depth+1); err != nil {
+					return fmt.Errorf("%s line %d: %w", name, line, err)
+				}
+			}
+			// The remaining lines still belong to the enclosing block.
+			c.blocks = append(c.blocks, sshConfigBlock{patterns: blockPatterns})
+		default:
+			if len(args) == 0 {
+				return fmt.Errorf("%s line %d: missing argument for %s", name, line, keyword)
+			}
+			current.options = append(current.options, sshConfigOption{keyword: keyword, args: args})
+		}
+	}
+
+	return scanner.Err()
+}
+
+// include parses all files matching pattern, relative paths are resolved against ~/.ssh.
+func (c *sshConfig) include(pattern string, patterns []string, depth int) error {
+	pattern = expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		if home, err := os.UserHomeDir(); err == nil {
+			pattern = filepath.Join(home, ".ssh", pattern)
+		}
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	for _, path := range matches {
+		if err := c.parseFile(path, patterns, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitSSHConfigLine returns the lower case keyword and its arguments, both empty for blank lines and comments.
+// Arguments may be double quoted, the keyword may be separated by "=".
+func splitSSHConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case (r == ' ' || r == '\t') && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quoted {
+		return "", nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return keyword, args, nil
+}
+
+// lookup collects the options for alias. Like OpenSSH the first value obtained wins,
+// except for IdentityFile which accumulates.
+func (c *sshConfig) lookup(alias string) sshHostConfig {
+	var hc sshHostConfig
+	if c == nil {
+		return hc
+	}
+
+	for _, block := range c.blocks {
+		if !matchHostPatterns(block.patterns, alias) {
+			continue
+		}
+
+		for _, opt := range block.options {
+			switch opt.keyword {
+			case "hostname":
+				if hc.HostName == "" {
+					hc.HostName = opt.args[0]
+				}
+			case "user":
+				if hc.User == "" {
+					hc.User = opt.args[0]
+				}
+			case "port":
+				if hc.Port == "" {
+					hc.Port = opt.args[0]
+				}
+			case "identityfile":
+				hc.IdentityFiles = append(hc.IdentityFiles, opt.args[0])
+			case "proxyjump":
+				if hc.ProxyJump == "" {
+					hc.ProxyJump = opt.args[0]
+				}
+			case "userknownhostsfile":
+				if hc.KnownHostsFiles == nil {
+					hc.KnownHostsFiles = opt.args
+				}
+			}
+		}
+	}
+
+	hc.HostName = strings.ReplaceAll(hc.HostName, "%h", alias)
+	return hc
+}
+
+// matchHostPatterns reports whether host matches any pattern and none of the negated ("!") ones.
+func matchHostPatterns(patterns []string, host string) bool {
+	matched := false
+	for _, p := range patterns {
+		for _, p := range strings.Split(p, ",") {
+			if negated := strings.HasPrefix(p, "!"); negated {
+				if matchPattern(p[1:], host) {
+					return false
+				}
+			} else if matchPattern(p, host) {
+				matched = true
+			}
+		}
+	}
+	return matched
+}
+
+// matchPattern matches s against a pattern supporting "*" and "?" wildcards.
+func matchPattern(pattern, s string) bool {
+	if pattern == "" {
+		return s == ""
+	}
+
+	switch pattern[0] {
+	case '*':
+		for i := 0; i <= len(s); i++ {
+			if matchPattern(pattern[1:], s[i:]) {
+				return true
+			}
+		}
+		return false
+	case '?':
+		return s != "" && matchPattern(pattern[1:], s[1:])
+	default:
+		return s != "" && strings.EqualFold(pattern[:1], s[:1]) && matchPattern(pattern[1:], s[1:])
+	}
+}
+
+// parseProxyJump splits an ssh_config ProxyJump value ("[user@]host[:port],...") into jump hosts.
+func parseProxyJump(value string) []config.JumpHost {
+	if value == "" || strings.EqualFold(value, "none") {
+		return nil
+	}
+
+	var jumps []config.JumpHost
+	for _, j := range strings.Split(value, ",") {
+		if j = strings.TrimSpace(j); j != "" {
+			jumps = append(jumps, config.JumpHost{Host: j})
+		}
+	}
+	return jumps
+}
+
+// expandSSHTokens expands "~" and the ssh_config tokens %d, %u, %h, %r, %p and %% for hop h.
+func expandSSHTokens(s string, h hop) string {
+	home, _ := os.UserHomeDir()
+	localUser := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		localUser = u.Username
+	}
+
+	s = expandHome(s)
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 'd':
+			b.WriteString(home)
+		case 'u':
+			b.WriteString(localUser)
+		case 'h':
+			b.WriteString(h.host)
+		case 'r':
+			b.WriteString(h.user)
+		case 'p':
+			b.WriteString(h.port)
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// expandHome replaces a leading "~/" with the home directory.
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return home + path[1:]
+		}
+	}
+	return path
+}
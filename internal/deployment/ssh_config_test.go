@@ -0,0 +1,179 @@
+package deployment
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSSHConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USER", "local")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(home, ".ssh"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".ssh", "config"), []byte(content), 0o600))
+	return home
+}
+
+func TestSSHConfig_Lookup(t *testing.T) {
+	home := writeSSHConfig(t, `
+# Global defaults come last, the first obtained value wins
+Host prod-api
+    HostName 10.0.1.20
+    User deploy
+    Port 2200
+    IdentityFile ~/.ssh/prod_key
+    ProxyJump ops@bastion.example.com:2222
+
+Host prod-* !prod-legacy
+    IdentityFile "~/.ssh/prod shared"
+    UserKnownHostsFile ~/.ssh/known_hosts_prod ~/.ssh/known_hosts
+
+Host internal
+    HostName %h.corp.example.com
+
+Match host prod-api
+    User ignored
+
+Host=*
+    User fallback
+    Port 22
+`)
+
+	cfg, err := userSSHConfig()
+	require.NoError(t, err)
+
+	prod := cfg.lookup("prod-api")
+	assert.Equal(t, "10.0.1.20", prod.HostName)
+	assert.Equal(t, "deploy", prod.User)
+	assert.Equal(t, "2200", prod.Port)
+	assert.Equal(t, []string{"~/.ssh/prod_key", "~/.ssh/prod shared"}, prod.IdentityFiles)
+	assert.Equal(t, "ops@bastion.example.com:2222", prod.ProxyJump)
+	assert.Equal(t, []string{"~/.ssh/known_hosts_prod", "~/.ssh/known_hosts"}, prod.KnownHostsFiles)
+
+	legacy := cfg.lookup("prod-legacy")
+	assert.Empty(t, legacy.HostName)
+	assert.Empty(t, legacy.IdentityFiles)
+	assert.Equal(t, "fallback", legacy.User)
+
+	assert.Equal(t, "internal.corp.example.com", cfg.lookup("internal").HostName)
+
+	ep := resolveEndpoint(config.Project{Host: "prod-api"}, cfg)
+	assert.Equal(t, "10.0.1.20", ep.host)
+	assert.Equal(t, "deploy", ep.user)
+	assert.Equal(t, "2200", ep.port)
+	assert.Equal(t, []string{filepath.Join(home, ".ssh/prod_key"), filepath.Join(home, ".ssh/prod shared")}, ep.identityFiles)
+	assert.Equal(t, []string{filepath.Join(home, ".ssh/known_hosts_prod"), filepath.Join(home, ".ssh/known_hosts")}, ep.knownHostsFiles)
+	require.Len(t, ep.jumps, 1)
+	assert.Equal(t, "ops@bastion.example.com:2222>deploy@10.0.1.20:2200", ep.key())
+}
+
+func TestSSHConfig_ExplicitValuesWin(t *testing.T) {
+	writeSSHConfig(t, `
+Host prod-api
+    HostName 10.0.1.20
+    User deploy
+    Port 2200
+    IdentityFile ~/.ssh/prod_key
+    ProxyJump bastion
+
+Host bastion
+    HostName bastion.example.com
+    User ops
+`)
+
+	cfg, err := userSSHConfig()
+	require.NoError(t, err)
+
+	ep := resolveEndpoint(config.Project{
+		Host:         "admin@prod-api",
+		Port:         "22",
+		IdentityFile: "/keys/explicit",
+	}, cfg)
+	assert.Equal(t, "admin@10.0.1.20:22", ep.hop.user+"@"+ep.addr())
+	assert.Equal(t, []string{"/keys/explicit"}, ep.identityFiles)
+
+	// Jump hosts from ssh_config are resolved through ssh_config as well
+	assert.Equal(t, "ops@bastion.example.com:22>admin@10.0.1.20:22", ep.key())
+
+	// An explicit proxy_jump replaces the one from ssh_config
+	ep = resolveEndpoint(config.Project{
+		Host:      "prod-api",
+		ProxyJump: []config.JumpHost{{Host: "jump@10.0.0.5"}},
+	}, cfg)
+	assert.Equal(t, "jump@10.0.0.5:22>deploy@10.0.1.20:2200", ep.key())
+}
+
+func TestSSHConfig_Include(t *testing.T) {
+	home := writeSSHConfig(t, `
+Host staging
+    Include conf.d/*.conf
+    Port 2222
+`)
+	require.NoError(t, os.MkdirAll(filepath.Join(home, ".ssh", "conf.d"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".ssh", "conf.d", "staging.conf"), []byte("HostName 10.0.2.30\nUser stage\n"), 0o600))
+
+	cfg, err := userSSHConfig()
+	require.NoError(t, err)
+
+	staging := cfg.lookup("staging")
+	assert.Equal(t, "10.0.2.30", staging.HostName)
+	assert.Equal(t, "stage", staging.User)
+	assert.Equal(t, "2222", staging.Port)
+
+	// Included options only apply within the enclosing Host block
+	assert.Empty(t, cfg.lookup("other").HostName)
+}
+
+func TestSSHConfig_Missing(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	cfg, err := userSSHConfig()
+	require.NoError(t, err)
+	assert.Equal(t, sshHostConfig{}, cfg.lookup("prod-api"))
+}
+
+func TestSSHConfig_Invalid(t *testing.T) {
+	writeSSHConfig(t, "Host prod-api\n    HostName \"10.0.1.20\n")
+
+	_, err := userSSHConfig()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2: unterminated quote")
+}
+
+func TestMatchHostPatterns(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		host     string
+		want     bool
+	}{
+		{[]string{"*"}, "anything", true},
+		{[]string{"prod-?"}, "prod-1", true},
+		{[]string{"prod-?"}, "prod-10", false},
+		{[]string{"*.example.com"}, "api.EXAMPLE.com", true},
+		{[]string{"web", "api"}, "api", true},
+		{[]string{"web,api"}, "api", true},
+		{[]string{"*", "!api"}, "api", false},
+		{[]string{"!api"}, "web", false},
+		{nil, "api", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchHostPatterns(tt.patterns, tt.host), "%v %s", tt.patterns, tt.host)
+	}
+}
+
+func TestExpandSSHTokens(t *testing.T) {
+	t.Setenv("HOME", "/home/local")
+
+	h := hop{host: "10.0.1.20", user: "deploy", port: "2200"}
+	assert.Equal(t, "/home/local/.ssh/id_deploy@10.0.1.20:2200", expandSSHTokens("~/.ssh/id_%r@%h:%p", h))
+	assert.Equal(t, "/home/local/known_hosts 100%", expandSSHTokens("%d/known_hosts 100%%", h))
+}
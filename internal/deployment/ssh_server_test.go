@@ -0,0 +1,293 @@
+package deployment
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// testHandler runs an "exec" request and returns its exit status. Signals sent by the client are delivered on signals.
+type testHandler func(cmd string, stdin io.Reader, stdout, stderr io.Writer, signals <-chan string) int
+
+// testSSHServer is a minimal in-process SSH server, "exec" requests are answered by handler.
+type testSSHServer struct {
+	addr     string
+	hostKey  ssh.Signer
+	listener net.Listener
+	handler  testHandler
+
+	mu       sync.Mutex
+	conns    []ssh.Conn
+	dials    int
+	commands []string
+	forwards []string
+	signals  []string
+}
+
+func newTestSSHServer(t *testing.T, handler testHandler) *testSSHServer {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create host key signer: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &testSSHServer{
+		addr:     listener.Addr().String(),
+		hostKey:  hostKey,
+		listener: listener,
+		handler:  handler,
+	}
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	go func() {
+		for {
+			nConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(nConn, config)
+		}
+	}()
+
+	t.Cleanup(func() {
+		listener.Close()
+		s.closeConnections()
+	})
+
+	return s
+}
+
+func (s *testSSHServer) serve(nConn net.Conn, config *ssh.ServerConfig) {
+	conn, chans, reqs, err := ssh.NewServerConn(nConn, config)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.conns = append(s.conns, conn)
+	s.dials++
+	s.mu.Unlock()
+
+	go func() {
+		for req := range reqs {
+			if req.WantReply {
+				_ = req.Reply(true, nil)
+			}
+		}
+	}()
+
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go s.serveSession(channel, requests)
+		case "direct-tcpip":
+			go s.forward(newChannel)
+		default:
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+// forward serves a "direct-tcpip" channel (used by jump hosts) by connecting to the requested address.
+func (s *testSSHServer) forward(newChannel ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, "invalid payload")
+		return
+	}
+
+	target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	s.mu.Lock()
+	s.forwards = append(s.forwards, target.RemoteAddr().String())
+	s.mu.Unlock()
+
+	go func() {
+		_, _ = io.Copy(target, channel)
+		target.Close()
+	}()
+	_, _ = io.Copy(channel, target)
+	channel.Close()
+}
+
+func (s *testSSHServer) serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	signals := make(chan string, 8)
+	exited := make(chan struct{})
+
+	for {
+		select {
+		case <-exited:
+			return
+		case req, ok := <-requests:
+			if !ok {
+				return
+			}
+
+			switch req.Type {
+			case "exec":
+				var payload struct{ Command string }
+				if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+					_ = req.Reply(false, nil)
+					return
+				}
+				_ = req.Reply(true, nil)
+
+				s.mu.Lock()
+				s.commands = append(s.commands, payload.Command)
+				s.mu.Unlock()
+
+				go func() {
+					defer close(exited)
+
+					code := 0
+					if s.handler != nil {
+						code = s.handler(payload.Command, channel, channel, channel.Stderr(), signals)
+					}
+
+					status := make([]byte, 4)
+					binary.BigEndian.PutUint32(status, uint32(code))
+					_, _ = channel.SendRequest("exit-status", false, status)
+				}()
+			case "signal":
+				var payload struct{ Signal string }
+				if err := ssh.Unmarshal(req.Payload, &payload); err == nil {
+					s.mu.Lock()
+					s.signals = append(s.signals, payload.Signal)
+					s.mu.Unlock()
+
+					select {
+					case signals <- payload.Signal:
+					default:
+					}
+				}
+			default:
+				if req.WantReply {
+					_ = req.Reply(true, nil)
+				}
+			}
+		}
+	}
+}
+
+// dial connects to the test server, without client authentication.
+func (s *testSSHServer) dial() (*ssh.Client, error) {
+	return ssh.Dial("tcp", s.addr, s.clientConfig())
+}
+
+// connect dials the server, the connection is closed when the test finishes.
+func (s *testSSHServer) connect(t *testing.T) executor {
+	client, err := s.dial()
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return sshExecutor{client}
+}
+
+func (s *testSSHServer) clientConfig() *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.FixedHostKey(s.hostKey.PublicKey()),
+	}
+}
+
+// hop returns the server address as a hop for dialChain.
+func (s *testSSHServer) hop() hop {
+	host, port, _ := net.SplitHostPort(s.addr)
+	return hop{host: host, user: "test", port: port}
+}
+
+func (s *testSSHServer) dialCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials
+}
+
+func (s *testSSHServer) executed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *testSSHServer) signalled() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.signals...)
+}
+
+func (s *testSSHServer) forwarded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.forwards...)
+}
+
+// closeConnections drops all client connections from the server side.
+func (s *testSSHServer) closeConnections() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use, e.g. as session output read by the test.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
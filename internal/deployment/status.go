@@ -6,19 +6,37 @@ import (
 
 // ContainerStatus represents the status of a single container.
 type ContainerStatus struct {
-	Name      string    `json:"Name"`
-	State     string    `json:"State"` // e.g., "running", "exited"
-	Status    string    `json:"Status"` // e.g., "Up 2 hours", "Exited (0) 5 seconds ago"
-	CreatedAt string    `json:"CreatedAt"` // Raw timestamp string
-    ExitCode  int       `json:"ExitCode"`
-    Service   string    `json:"Service"`
+	Name      string `json:"Name"`
+	State     string `json:"State"`     // e.g., "running", "exited"
+	Status    string `json:"Status"`    // e.g., "Up 2 hours", "Exited (0) 5 seconds ago"
+	CreatedAt string `json:"CreatedAt"` // Raw timestamp string
+	ExitCode  int    `json:"ExitCode"`
+	Service   string `json:"Service"`
+	Health    string `json:"Health"` // "healthy", "unhealthy", "starting" or empty without healthcheck
+	// Replicas are the running/desired tasks of swarm services, e.g. "2/3".
+	Replicas string `json:"Replicas,omitempty"`
 }
 
 // ProjectStatus represents the aggregated status of the project.
+// For multi-host projects Branch is the one of the first host, Containers are those of all hosts.
 type ProjectStatus struct {
-	Name            string
-	Branch          string
-	LastDeployedAt  time.Time
-	Status          string // "Healthy", "Partial", "Down"
-	Containers      []ContainerStatus
+	Name           string
+	Branch         string
+	LastDeployedAt time.Time
+	Status         string // "Healthy", "Partial", "Down"
+	Containers     []ContainerStatus
+	Hosts          []HostStatus
+}
+
+// HostStatus is the status of the project on a single host.
+type HostStatus struct {
+	Host           string
+	Branch         string
+	Commit         string
+	LastDeployedAt time.Time
+	Status         string // "Healthy", "Partial", "Down" or "Unreachable"
+	// Behind is set if the host runs a different commit than the first host, e.g. after a halted rollout.
+	Behind     bool
+	Error      string `json:",omitempty"`
+	Containers []ContainerStatus
 }
@@ -0,0 +1,113 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/shell"
+)
+
+// step is a single remote command of a deployment pipeline, run inside dir (the project's working directory by default).
+type step struct {
+	name    string
+	command string
+	dir     string
+	// local replaces the remote command for steps run on the goploy machine, command only describes it then.
+	local func(ctx context.Context, c *SSHClient, client executor, events EventSink) error
+}
+
+// deploySteps builds the deployment pipeline: update the source (or image tag), pull images, run the
+// pre-deploy hooks and bring the containers up. Post-deploy hooks are run separately,
+// once the project passed its health check.
+func deploySteps(project config.Project, ref string) []step {
+	return append(sourceSteps(project, ref), composeSteps(project, project.Hooks, composeCommand(project), composeProjectName(project))...)
+}
+
+// composeSteps pulls (or locally builds) images, runs the pre-deploy hooks (if any) and brings the containers up
+// (or deploys the stack of swarm projects), compose is the docker compose command to use and name its compose project.
+func composeSteps(project config.Project, hooks *config.HooksConfig, compose, name string) []step {
+	var steps []step
+	switch {
+	case swarmStrategy(project):
+		// The nodes pull the images of their tasks, see stackDeployCommand.
+	case project.LocalBuild != nil:
+		steps = append(steps,
+			step{name: "image pull", command: pullCommand(project, compose)},
+			localBuildStep(project, name),
+		)
+	default:
+		steps = append(steps, step{name: "image pull", command: pullCommand(project, compose)})
+	}
+
+	if hooks != nil {
+		steps = append(steps, hookSteps("pre_deploy", hooks.PreDeploy, compose)...)
+	}
+
+	return append(steps, step{name: "up", command: upCommand(project, compose)})
+}
+
+// hookSteps turns the configured hooks of a phase into pipeline steps, service hooks are run with compose.
+func hookSteps(phase string, hooks []config.HookStep, compose string) []step {
+	steps := make([]step, 0, len(hooks))
+	for i, h := range hooks {
+		command := h.Run
+		if h.Service != "" {
+			command = shell.Command(compose+" run --rm", h.Service) + " " + h.Run
+		}
+
+		steps = append(steps, step{
+			name:    fmt.Sprintf("%s %d/%d", phase, i+1, len(hooks)),
+			command: command,
+		})
+	}
+	return steps
+}
+
+// runSteps runs the steps one after another, reporting each one as a separate phase.
+// It stops at the first failing step, each step is bounded by timeouts.step.
+func (c *SSHClient) runSteps(ctx context.Context, client executor, project config.Project, steps []step, events EventSink) error {
+	for _, s := range steps {
+		events.Emit(Event{Type: EventPhaseStarted, Time: time.Now(), Phase: s.name, Command: s.command})
+
+		start := time.Now()
+		var err error
+		if s.local != nil {
+			err = c.runLocal(ctx, client, project, s, events)
+		} else {
+			dir := s.dir
+			if dir == "" {
+				dir = workDir(project)
+			}
+			remoteCommand := shell.InDir(dir, s.command)
+			err = c.runStep(ctx, client, project, s.name, remoteCommand, events)
+		}
+
+		events.Emit(Event{Type: EventPhaseFinished, Time: time.Now(), Phase: s.name, Outcome: newOutcome(start, err)})
+		if err != nil {
+			return fmt.Errorf("step %s failed: %w", s.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *SSHClient) runStep(ctx context.Context, client executor, project config.Project, phase string, remoteCommand string, events EventSink) error {
+	ctx, cancel, wrapTimeout := withTimeout(ctx, stepTimeout(project), "step")
+	defer cancel()
+
+	stdout := newLogWriter(events, phase, StreamStdout)
+	stderr := newLogWriter(events, phase, StreamStderr)
+	defer stdout.Flush()
+	defer stderr.Flush()
+
+	return wrapTimeout(c.runSession(client, remoteCommand, stdout, stderr, ctx))
+}
+
+func (c *SSHClient) runLocal(ctx context.Context, client executor, project config.Project, s step, events EventSink) error {
+	ctx, cancel, wrapTimeout := withTimeout(ctx, stepTimeout(project), "step")
+	defer cancel()
+
+	return wrapTimeout(s.local(ctx, c, client, events))
+}
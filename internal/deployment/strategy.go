@@ -0,0 +1,247 @@
+package deployment
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/shell"
+)
+
+const (
+	defaultTagVariable = "GOPLOY_IMAGE_TAG"
+	defaultEnvFile     = ".env"
+)
+
+// imageTagPattern matches valid Docker image tags.
+var imageTagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// validateStrategy checks the deployment strategy of the project and the ref to deploy, an image tag for image projects.
+func validateStrategy(project config.Project, ref string) error {
+	if project.LocalBuild != nil && project.LocalBuild.Path == "" {
+		return errors.New("local_build requires a path")
+	}
+
+	if err := validateGit(project); err != nil {
+		return err
+	}
+
+	switch project.Strategy {
+	case "", config.StrategyGit:
+	case config.StrategyImage:
+		if project.LocalBuild != nil {
+			return errors.New("local_build cannot be combined with the image strategy")
+		}
+	case config.StrategyUpload:
+		if project.Upload == nil || project.Upload.Path == "" {
+			return errors.New("the upload strategy requires upload.path")
+		}
+	case config.StrategySwarm:
+		if project.LocalBuild != nil {
+			return errors.New("local_build cannot be combined with the swarm strategy, the nodes pull their images")
+		}
+		if project.BlueGreen != nil {
+			return errors.New("blue_green cannot be combined with the swarm strategy, swarm updates services itself")
+		}
+		if project.Compose != nil && project.Compose.Runtime != "" && project.Compose.Runtime != config.RuntimeDocker {
+			return fmt.Errorf("the swarm strategy requires the docker runtime, not %s", project.Compose.Runtime)
+		}
+	default:
+		return fmt.Errorf("unknown deployment strategy %q", project.Strategy)
+	}
+
+	return ValidateRef(project, ref)
+}
+
+// ValidateRef checks a ref to deploy: a git ref name or commit for git projects, an image tag for image
+// projects. Upload projects take no ref, the empty ref deploys the current branch or tag.
+func ValidateRef(project config.Project, ref string) error {
+	if ref == "" {
+		return nil
+	}
+
+	switch project.Strategy {
+	case config.StrategyImage:
+		if !imageTagPattern.MatchString(ref) {
+			return fmt.Errorf("invalid image tag %q", ref)
+		}
+		return nil
+	case config.StrategyUpload:
+		return errors.New("upload deployments take no ref, the local directory is deployed as is")
+	default:
+		return validateRefName(ref)
+	}
+}
+
+// validateRefName checks ref against the rules of `git check-ref-format --allow-onelevel`.
+// Refs starting with a dash are rejected as well, git would take them for options.
+func validateRefName(ref string) error {
+	if problem := refNameProblem(ref); problem != "" {
+		return fmt.Errorf("invalid ref %q: %s", ref, problem)
+	}
+	return nil
+}
+
+func refNameProblem(ref string) string {
+	switch {
+	case ref == "", ref == "@":
+		return "not a ref name"
+	case strings.HasPrefix(ref, "-"):
+		return "it starts with a dash"
+	case strings.HasPrefix(ref, "/"), strings.HasSuffix(ref, "/"), strings.Contains(ref, "//"):
+		return "it has an empty path component"
+	case strings.HasSuffix(ref, "."):
+		return "it ends with a dot"
+	case strings.Contains(ref, ".."):
+		return "it contains .."
+	case strings.Contains(ref, "@{"):
+		return "it contains @{"
+	}
+
+	if i := strings.IndexFunc(ref, func(r rune) bool {
+		return r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r)
+	}); i >= 0 {
+		return fmt.Sprintf("it contains %q", ref[i])
+	}
+
+	for _, component := range strings.Split(ref, "/") {
+		if strings.HasPrefix(component, ".") {
+			return "a path component starts with a dot"
+		}
+		if strings.HasSuffix(component, ".lock") {
+			return "a path component ends with .lock"
+		}
+	}
+	return ""
+}
+
+// validateGit checks the git transport, bundles are pushed for the git checkouts of the git and swarm strategies only.
+func validateGit(project config.Project) error {
+	if project.Git == nil {
+		return nil
+	}
+
+	switch project.Git.Transport {
+	case "", config.GitTransportFetch:
+		return nil
+	case config.GitTransportBundle:
+		if imageStrategy(project) || uploadStrategy(project) {
+			return fmt.Errorf("the bundle transport cannot be combined with the %s strategy", project.Strategy)
+		}
+		if project.Git.LocalPath == "" {
+			return errors.New("the bundle transport requires git.local_path")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown git transport %q", project.Git.Transport)
+	}
+}
+
+func imageStrategy(project config.Project) bool {
+	return project.Strategy == config.StrategyImage
+}
+
+// sourceSteps updates what the containers are built or pulled from: the git checkout (to ref if given),
+// the image tag of image projects (kept if ref is empty) or a new release of upload projects.
+// Hosts of the bundle transport receive the missing commits from the local repository instead of fetching.
+func sourceSteps(project config.Project, ref string) []step {
+	if uploadStrategy(project) {
+		return releaseSteps(project, newReleaseID())
+	}
+	if imageStrategy(project) {
+		if ref == "" {
+			return nil
+		}
+		return []step{versionStep(project, ref)}
+	}
+	if bundleTransport(project) {
+		return []step{bundleStep(project, ref)}
+	}
+
+	steps := []step{
+		{name: "fetch", command: "git fetch --all"},
+	}
+
+	if ref != "" {
+		// Checkout specific ref
+		steps = append(steps, step{name: "checkout", command: shell.Command("git checkout", ref)})
+	}
+
+	return append(steps, step{name: "pull", command: "git pull"})
+}
+
+// versionStep switches the project to a version as reported by headCommit: a commit,
+// the image tag of image projects or the release of upload projects.
+func versionStep(project config.Project, version string) step {
+	if uploadStrategy(project) {
+		return switchReleaseStep(project, version)
+	}
+	if !imageStrategy(project) {
+		return step{name: "checkout", command: checkoutCommitCommand(version)}
+	}
+
+	file := shell.Quote(imageEnvFile(project))
+	variable := tagVariable(project)
+	return step{
+		name: "tag",
+		// Rewritten in place rather than moved, the env file keeps its permissions.
+		command: fmt.Sprintf("touch %[1]s && { grep -v %[2]s %[1]s; echo %[3]s; } > %[1]s.goploy && cat %[1]s.goploy > %[1]s && rm %[1]s.goploy",
+			file, shell.Quote("^"+variable+"="), shell.Quote(variable+"="+version)),
+	}
+}
+
+// checkoutCommitCommand checks out commit on the current branch, which is reset to it: HEAD stays on the branch
+// and later deployments without ref pull it as usual. Detached checkouts just move to commit.
+func checkoutCommitCommand(commit string) string {
+	return fmt.Sprintf(`if branch=$(git symbolic-ref --short -q HEAD); then git checkout -B "$branch" %[1]s; else git checkout %[1]s; fi`, shell.Quote(commit))
+}
+
+// versionCommand prints the deployed version: the checked out commit, the image tag of image projects
+// or the current release of upload projects.
+func versionCommand(project config.Project) string {
+	if uploadStrategy(project) {
+		return fmt.Sprintf("basename \"$(readlink %s)\"", shell.Quote(path.Join(project.Path, currentLink)))
+	}
+	if !imageStrategy(project) {
+		return "git rev-parse HEAD"
+	}
+	return fmt.Sprintf("sed -n %s %s | tail -n 1", shell.Quote("s/^"+tagVariable(project)+"=//p"), shell.Quote(imageEnvFile(project)))
+}
+
+// upCommand brings the containers up, rebuilding images from the checkout unless the project deploys
+// prebuilt or locally built images. Swarm projects deploy their stack instead.
+func upCommand(project config.Project, compose string) string {
+	if swarmStrategy(project) {
+		return stackDeployCommand(project)
+	}
+	if imageStrategy(project) || project.LocalBuild != nil {
+		return compose + " up -d"
+	}
+	return compose + " up -d --build"
+}
+
+// workDir is the directory compose and hook commands run in, the current release of upload projects.
+func workDir(project config.Project) string {
+	if uploadStrategy(project) {
+		return path.Join(project.Path, currentLink)
+	}
+	return project.Path
+}
+
+func tagVariable(project config.Project) string {
+	if project.Image != nil && project.Image.TagVariable != "" {
+		return project.Image.TagVariable
+	}
+	return defaultTagVariable
+}
+
+// imageEnvFile is the env file the image tag is written to, the one compose reads variables from.
+func imageEnvFile(project config.Project) string {
+	if project.Compose != nil && project.Compose.EnvFile != "" {
+		return project.Compose.EnvFile
+	}
+	return defaultEnvFile
+}
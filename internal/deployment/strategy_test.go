@@ -0,0 +1,119 @@
+package deployment
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/shell"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateStrategy(t *testing.T) {
+	assert.NoError(t, validateStrategy(config.Project{}, "main"))
+	assert.NoError(t, validateStrategy(config.Project{Strategy: config.StrategyGit}, "main"))
+	assert.NoError(t, validateStrategy(config.Project{Strategy: config.StrategyImage}, "v1.4.2"))
+	assert.NoError(t, validateStrategy(config.Project{Strategy: config.StrategyImage}, ""))
+
+	assert.EqualError(t, validateStrategy(config.Project{Strategy: config.StrategyImage}, "v1; rm -rf /"), `invalid image tag "v1; rm -rf /"`)
+	assert.EqualError(t, validateStrategy(config.Project{Strategy: "rsync"}, ""), `unknown deployment strategy "rsync"`)
+	assert.EqualError(t, validateStrategy(config.Project{}, "main; rm -rf ~"), `invalid ref "main; rm -rf ~": it contains ' '`)
+}
+
+func TestValidateRef(t *testing.T) {
+	for _, ref := range []string{"main", "v1.2.0", "feature/login", "release-2024.05", "6a801c0391b1acd5cdd068d12ce3886164348b30", "a;b", "$(id)"} {
+		assert.NoError(t, ValidateRef(config.Project{}, ref), ref)
+	}
+
+	for ref, problem := range map[string]string{
+		"@":             "not a ref name",
+		"--upload-pack": "it starts with a dash",
+		"feature//x":    "it has an empty path component",
+		"main/":         "it has an empty path component",
+		"v1.":           "it ends with a dot",
+		"main..dev":     "it contains ..",
+		"main@{1}":      "it contains @{",
+		"HEAD~1":        `it contains '~'`,
+		"a\nb":          `it contains '\n'`,
+		".hidden":       "a path component starts with a dot",
+		"refs/x.lock":   "a path component ends with .lock",
+	} {
+		assert.EqualError(t, ValidateRef(config.Project{}, ref), "invalid ref "+strconv.Quote(ref)+": "+problem)
+	}
+}
+
+// FuzzValidateRef checks the ref rules against git and that no ref accepted for a deployment can escape
+// the checkout command: a fake git run by sh receives it as its only argument after checkout.
+func FuzzValidateRef(f *testing.F) {
+	git, err := exec.LookPath("git")
+	if err != nil {
+		f.Skip("git not available")
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		f.Skip("sh not available")
+	}
+
+	bin := f.TempDir()
+	require.NoError(f, os.WriteFile(filepath.Join(bin, "git"), []byte("#!/bin/sh\nprintf '%s\\0' \"$@\"\n"), 0o755))
+
+	for _, seed := range []string{"main", "v1.2.0", "feature/x", "a;rm -rf /", "$(id)", "`id`", "it's", "a\"b", "x&&y", "a|b", "-n", "HEAD~1", "a\nb", ".x", "x.lock", "@"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, ref string) {
+		if strings.ContainsRune(ref, 0) {
+			t.Skip("NUL bytes cannot be passed to a command")
+		}
+
+		err := ValidateRef(config.Project{}, ref)
+		if ref != "" && !strings.HasPrefix(ref, "-") {
+			gitErr := exec.Command(git, "check-ref-format", "--allow-onelevel", ref).Run()
+			assert.Equal(t, gitErr == nil, err == nil, "git check-ref-format %q: %v, ValidateRef: %v", ref, gitErr, err)
+		}
+		if err != nil || ref == "" {
+			return
+		}
+
+		checkout := deploySteps(config.Project{}, ref)[1].command
+		cmd := exec.Command(sh, "-c", shell.InDir(bin, checkout))
+		cmd.Env = []string{"PATH=" + bin + ":/usr/bin:/bin"}
+		out, err := cmd.Output()
+		require.NoError(t, err, checkout)
+		assert.Equal(t, "checkout\x00"+ref+"\x00", string(out), checkout)
+	})
+}
+
+func TestDeploySteps_Image(t *testing.T) {
+	commands := func(steps []step) []string {
+		var out []string
+		for _, s := range steps {
+			out = append(out, s.command)
+		}
+		return out
+	}
+
+	project := config.Project{Name: "api", Path: "/srv/api", Strategy: config.StrategyImage}
+	assert.Equal(t, []string{
+		"docker compose pull",
+		"docker compose up -d",
+	}, commands(deploySteps(project, "")))
+
+	project.Image = &config.ImageConfig{TagVariable: "API_TAG"}
+	project.Compose = &config.Compose{EnvFile: ".env.production"}
+	steps := deploySteps(project, "v1.4.2")
+	assert.Equal(t, "tag", steps[0].name)
+	assert.Equal(t, []string{
+		`touch '.env.production' && { grep -v '^API_TAG=' '.env.production'; echo 'API_TAG=v1.4.2'; } > '.env.production'.goploy && cat '.env.production'.goploy > '.env.production' && rm '.env.production'.goploy`,
+		"docker compose --env-file '.env.production' pull",
+		"docker compose --env-file '.env.production' up -d",
+	}, commands(steps))
+
+	assert.Equal(t, `sed -n 's/^API_TAG=//p' '.env.production' | tail -n 1`, versionCommand(project))
+	assert.Equal(t, "git rev-parse HEAD", versionCommand(config.Project{}))
+}
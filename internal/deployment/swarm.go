@@ -0,0 +1,176 @@
+package deployment
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/shell"
+)
+
+const (
+	// defaultStackFile is deployed if the project names no compose.files, `docker stack deploy` has no default.
+	defaultStackFile = "docker-compose.yml"
+
+	swarmTasksMarker   = "---TASKS---"
+	swarmUpdatedMarker = "---UPDATED---"
+)
+
+func swarmStrategy(project config.Project) bool {
+	return project.Strategy == config.StrategySwarm
+}
+
+// stackName is the name of the swarm stack of the project, the name of its compose project.
+func stackName(project config.Project) string {
+	return composeProjectName(project)
+}
+
+// stackDeployCommand deploys the compose files of the project as its stack. The nodes receive the
+// registry credentials of the manager to pull the images of their tasks.
+func stackDeployCommand(project config.Project) string {
+	files := []string{defaultStackFile}
+	if project.Compose != nil && len(project.Compose.Files) > 0 {
+		files = project.Compose.Files
+	}
+
+	args := []string{"docker stack deploy --with-registry-auth"}
+	for _, f := range files {
+		args = append(args, "-c", shell.Quote(f))
+	}
+	return strings.Join(append(args, shell.Quote(stackName(project))), " ")
+}
+
+// listServices assigns the names of the services of the stack (with --filter if filter is set) to $services.
+func listServices(project config.Project, filter string) string {
+	list := "docker stack services"
+	if filter != "" {
+		list += " --filter " + shell.Quote(filter)
+	}
+	return "services=$(" + list + " --format '{{.Name}}' " + shell.Quote(stackName(project)) + ")"
+}
+
+// swarmLogsCommand follows the logs of all services of the stack. `docker service logs` takes a single
+// service, they are followed in the background and stopped together with the shell.
+func swarmLogsCommand(project config.Project) string {
+	return "trap 'trap - TERM; kill 0' TERM; " + listServices(project, "") +
+		` && for service in $services; do docker service logs -f "$service" & done; wait`
+}
+
+// swarmRestartCommand restarts every task of the stack, rolling like an update.
+func swarmRestartCommand(project config.Project) string {
+	return listServices(project, "") + ` && for service in $services; do docker service update --force "$service" || exit; done`
+}
+
+// swarmStopCommand scales the replicated services of the stack to 0, global services cannot be scaled.
+// The next deployment restores the replicas of the compose files.
+func swarmStopCommand(project config.Project) string {
+	return listServices(project, "mode=replicated") + ` && for service in $services; do docker service scale "$service=0" || exit; done`
+}
+
+// swarmShellCommand opens a shell in a container of service running on the manager, Swarm has no exec for
+// tasks on other nodes.
+func swarmShellCommand(project config.Project, service string) string {
+	label := shell.Quote("label=com.docker.swarm.service.name=" + stackName(project) + "_" + service)
+	missing := shell.Quote("no task of " + service + " runs on this node")
+	return fmt.Sprintf(`container=$(docker ps -q --filter %s | head -n 1) && { test -n "$container" || { echo %s >&2; exit 1; }; } && docker exec -it "$container" /bin/sh`, label, missing)
+}
+
+// swarmStatusCommand prints the services of the stack, their tasks that should be running and when each
+// service was last updated, for parseSwarmServices.
+func swarmStatusCommand(project config.Project) string {
+	stack := shell.Quote(stackName(project))
+	return strings.Join([]string{
+		"services=$(docker stack services -q " + stack + ")",
+		"docker stack services --format json " + stack,
+		"echo " + shell.Quote(swarmTasksMarker),
+		`{ test -z "$services" || docker service ps --no-trunc --format json --filter desired-state=running $services; }`,
+		"echo " + shell.Quote(swarmUpdatedMarker),
+		`{ test -z "$services" || docker service inspect --format '{{.Spec.Name}} {{.UpdatedAt}}' $services; }`,
+	}, " && ")
+}
+
+type swarmService struct {
+	Name     string `json:"Name"`
+	Replicas string `json:"Replicas"` // e.g. "2/3" or "1/1 (max 1 per node)"
+}
+
+type swarmTask struct {
+	Name         string `json:"Name"` // <service>.<slot or node>
+	CurrentState string `json:"CurrentState"`
+	Error        string `json:"Error"`
+}
+
+// parseSwarmServices parses the output of swarmStatusCommand into a ContainerStatus per service of the stack.
+// Services run once all replicas do, tasks only count as running once their healthcheck passed. Status names
+// the replicas and the state of a task that is not running yet, CreatedAt is the time of the last update.
+func parseSwarmServices(project config.Project, output string) []ContainerStatus {
+	servicesOutput, rest, _ := strings.Cut(output, swarmTasksMarker)
+	tasksOutput, updatedOutput, _ := strings.Cut(rest, swarmUpdatedMarker)
+
+	// Task states of services that are not running explain why.
+	problems := make(map[string]string)
+	for _, task := range jsonLines[swarmTask](tasksOutput) {
+		service := task.Name
+		if i := strings.LastIndex(service, "."); i >= 0 {
+			service = service[:i]
+		}
+		if _, seen := problems[service]; seen || strings.HasPrefix(task.CurrentState, "Running") {
+			continue
+		}
+		problem := fmt.Sprintf("%s %s", task.Name, task.CurrentState)
+		if task.Error != "" {
+			problem += ": " + task.Error
+		}
+		problems[service] = problem
+	}
+
+	updated := make(map[string]string)
+	for _, line := range strings.Split(updatedOutput, "\n") {
+		if name, at, ok := strings.Cut(strings.TrimSpace(line), " "); ok {
+			updated[name] = at
+		}
+	}
+
+	var containers []ContainerStatus
+	for _, s := range jsonLines[swarmService](servicesOutput) {
+		var running, desired int
+		fmt.Sscanf(s.Replicas, "%d/%d", &running, &desired)
+
+		state := "converging"
+		switch {
+		case desired == 0:
+			state = "stopped"
+		case running >= desired:
+			state = "running"
+		}
+
+		status := s.Replicas + " replicas"
+		if problem := problems[s.Name]; problem != "" {
+			status += ", " + problem
+		}
+
+		containers = append(containers, ContainerStatus{
+			Name:      s.Name,
+			State:     state,
+			Status:    status,
+			CreatedAt: updated[s.Name],
+			Service:   strings.TrimPrefix(s.Name, stackName(project)+"_"),
+			Replicas:  s.Replicas,
+		})
+	}
+	return containers
+}
+
+// jsonLines decodes the lines of `--format json` output, skipping those that are not JSON objects
+// (e.g. messages of docker on stderr).
+func jsonLines[T any](output string) []T {
+	var values []T
+	for _, line := range strings.Split(output, "\n") {
+		var v T
+		if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &v); err == nil {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,132 @@
+package deployment
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/shell"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeploySteps_Swarm(t *testing.T) {
+	commands := func(steps []step) []string {
+		var out []string
+		for _, s := range steps {
+			out = append(out, s.command)
+		}
+		return out
+	}
+
+	project := config.Project{Name: "api", Path: "/srv/api", Strategy: config.StrategySwarm}
+	assert.Equal(t, []string{
+		"git fetch --all",
+		"git checkout 'v2'",
+		"git pull",
+		"docker stack deploy --with-registry-auth -c 'docker-compose.yml' 'api'",
+	}, commands(deploySteps(project, "v2")))
+
+	project.Compose = &config.Compose{Files: []string{"stack.yml", "stack.prod.yml"}, ProjectName: "shop"}
+	assert.Equal(t, "docker stack deploy --with-registry-auth -c 'stack.yml' -c 'stack.prod.yml' 'shop'", upCommand(project, composeCommand(project)))
+
+	assert.NoError(t, validateStrategy(project, "main"))
+	project.BlueGreen = &config.BlueGreen{}
+	assert.EqualError(t, validateStrategy(project, ""), "blue_green cannot be combined with the swarm strategy, swarm updates services itself")
+}
+
+func TestParseSwarmServices(t *testing.T) {
+	project := config.Project{Path: "/srv/app"}
+	containers := parseSwarmServices(project, `{"ID":"s1","Mode":"replicated","Name":"app_web","Replicas":"3/3"}
+{"ID":"s2","Mode":"replicated","Name":"app_worker","Replicas":"1/2 (max 1 per node)"}
+{"ID":"s3","Mode":"replicated","Name":"app_cron","Replicas":"0/0"}
+---TASKS---
+{"Name":"app_web.1","CurrentState":"Running 2 hours ago","Error":""}
+{"Name":"app_worker.1","CurrentState":"Running 2 hours ago","Error":""}
+{"Name":"app_worker.2","CurrentState":"Pending 5 minutes ago","Error":"no suitable node (max replicas per node limit exceed)"}
+---UPDATED---
+app_web 2026-10-16 10:00:00.123456789 +0000 UTC
+app_worker 2026-10-15 08:30:00 +0000 UTC
+`)
+
+	assert.Equal(t, []ContainerStatus{
+		{Name: "app_web", State: "running", Status: "3/3 replicas", CreatedAt: "2026-10-16 10:00:00.123456789 +0000 UTC", Service: "web", Replicas: "3/3"},
+		{Name: "app_worker", State: "converging", Status: "1/2 (max 1 per node) replicas, app_worker.2 Pending 5 minutes ago: no suitable node (max replicas per node limit exceed)",
+			CreatedAt: "2026-10-15 08:30:00 +0000 UTC", Service: "worker", Replicas: "1/2 (max 1 per node)"},
+		{Name: "app_cron", State: "stopped", Status: "0/0 replicas", Service: "cron", Replicas: "0/0"},
+	}, containers)
+
+	reason, _ := evaluateHealth(containers)
+	assert.Equal(t, "container app_worker is converging (1/2 (max 1 per node) replicas, app_worker.2 Pending 5 minutes ago: no suitable node (max replicas per node limit exceed))", reason)
+
+	// Nothing deployed yet, docker only complains on stderr.
+	assert.Empty(t, parseSwarmServices(project, "Nothing found in stack: app\n---TASKS---\n---UPDATED---\n"))
+}
+
+// fakeSwarm puts a docker on PATH that logs its arguments and answers the stack and service commands
+// for a stack app with the services app_web (running) and app_worker (waiting for a node). It returns the log file.
+func fakeSwarm(t *testing.T) string {
+	bin := t.TempDir()
+	log := filepath.Join(bin, "docker.log")
+	script := `#!/bin/sh
+echo "$*" >> ` + shell.Quote(log) + `
+case "$*" in
+"stack services -q app") echo s1; echo s2 ;;
+"stack services --format json app")
+	echo '{"ID":"s1","Mode":"replicated","Name":"app_web","Replicas":"1/1"}'
+	echo '{"ID":"s2","Mode":"global","Name":"app_worker","Replicas":"0/1"}' ;;
+"stack services --format {{.Name}} app") echo app_web; echo app_worker ;;
+"stack services --filter mode=replicated --format {{.Name}} app") echo app_web ;;
+"service ps "*) echo '{"Name":"app_worker.x1","CurrentState":"Pending 1 second ago","Error":"no suitable node"}' ;;
+"service inspect "*) echo "app_web 2026-10-16 10:00:00.5 +0000 UTC"; echo "app_worker 2026-10-16 09:00:00 +0000 UTC" ;;
+"service logs -f "*) echo "$4 | started"; exec sleep 600 ;;
+esac
+`
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func TestSwarmCommands(t *testing.T) {
+	docker := fakeSwarm(t)
+	c := NewSSHClient(nil)
+	project := config.Project{Name: "app", Host: config.LocalHost, Path: filepath.Join(t.TempDir(), "app"), Strategy: config.StrategySwarm}
+	require.NoError(t, os.Mkdir(project.Path, 0o755))
+
+	status, err := c.GetStatus(context.Background(), project)
+	require.NoError(t, err)
+	assert.Equal(t, "Partial", status.Status)
+	assert.Equal(t, time.Date(2026, 10, 16, 10, 0, 0, 500000000, time.UTC), status.LastDeployedAt.UTC())
+	require.Len(t, status.Containers, 2)
+	assert.Equal(t, "0/1 replicas, app_worker.x1 Pending 1 second ago: no suitable node", status.Containers[1].Status)
+
+	require.NoError(t, c.Restart(context.Background(), project, &syncBuffer{}))
+	require.NoError(t, c.Stop(context.Background(), project, &syncBuffer{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	logs := &syncBuffer{}
+	go func() {
+		for !strings.Contains(logs.String(), "app_web | started") || !strings.Contains(logs.String(), "app_worker | started") {
+			time.Sleep(5 * time.Millisecond)
+		}
+		cancel()
+	}()
+	assert.ErrorIs(t, c.StreamLogs(ctx, project, logs), context.Canceled)
+
+	log, err := os.ReadFile(docker)
+	require.NoError(t, err)
+	commands := strings.Split(strings.TrimSpace(string(log)), "\n")
+	assert.Equal(t, []string{
+		"stack services --format {{.Name}} app",
+		"service update --force app_web",
+		"service update --force app_worker",
+		"stack services --filter mode=replicated --format {{.Name}} app",
+		"service scale app_web=0",
+		"stack services --format {{.Name}} app",
+	}, commands[4:10])
+	assert.ElementsMatch(t, []string{"service logs -f app_web", "service logs -f app_worker"}, commands[10:])
+}
@@ -0,0 +1,58 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+)
+
+const (
+	// cleanupTimeout bounds wrapping up after an operation, e.g. releasing locks once ctx was cancelled.
+	cleanupTimeout = 30 * time.Second
+	// sessionStopTimeout is how long a remote command gets to exit after being signalled before its session is closed.
+	sessionStopTimeout = 5 * time.Second
+)
+
+// withTimeout bounds ctx by timeout, a zero timeout leaves ctx unchanged.
+// The returned wrap func marks errors caused by this timeout (not by the parent ctx) as "<what> timed out after <timeout>".
+func withTimeout(ctx context.Context, timeout time.Duration, what string) (context.Context, context.CancelFunc, func(error) error) {
+	if timeout <= 0 {
+		return ctx, func() {}, func(err error) error { return err }
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithTimeout(parent, timeout)
+
+	wrap := func(err error) error {
+		if err == nil || parent.Err() != nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return err
+		}
+		return fmt.Errorf("%s timed out after %s: %w", what, timeout, err)
+	}
+
+	return ctx, cancel, wrap
+}
+
+// deployTimeout returns the configured timeout of a whole deployment, zero if unlimited.
+func deployTimeout(project config.Project) time.Duration {
+	if project.Timeouts == nil {
+		return 0
+	}
+	return project.Timeouts.Deploy
+}
+
+// stepTimeout returns the configured timeout of a single step, zero if unlimited.
+func stepTimeout(project config.Project) time.Duration {
+	if project.Timeouts == nil {
+		return 0
+	}
+	return project.Timeouts.Step
+}
+
+// cleanupContext returns a context for wrapping up an operation that is not cancelled together with ctx.
+func cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+}
@@ -0,0 +1,271 @@
+package deployment
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/pmaojo/goploy/internal/shell"
+)
+
+const (
+	// releasesDir holds the uploaded releases inside the project path, currentLink points at the live one.
+	releasesDir = "releases"
+	currentLink = "current"
+
+	// ignoreFile lists the files of the upload directory that are not uploaded.
+	ignoreFile = ".goployignore"
+
+	defaultKeepReleases = 5
+
+	uploadPhase = "upload"
+)
+
+func uploadStrategy(project config.Project) bool {
+	return project.Strategy == config.StrategyUpload
+}
+
+// newReleaseID names a new release after the current time in nanoseconds, releases sort by age.
+func newReleaseID() string {
+	return time.Now().UTC().Format("20060102150405.000000000")
+}
+
+// releaseSteps uploads the local directory as release id, validates its compose configuration,
+// prunes old releases and switches the current symlink to it. A release that failed to upload or
+// validate is removed again, pruning would count it as one of the newest releases otherwise.
+func releaseSteps(project config.Project, id string) []step {
+	release := path.Join(releasesDir, id)
+	validate := inPlaceCompose(project) + " config -q"
+	validateDir := path.Join(project.Path, release)
+
+	return []step{
+		{
+			name:    uploadPhase,
+			command: fmt.Sprintf("tar -x -C %s (from %s)", release, project.Upload.Path),
+			dir:     project.Path,
+			local: func(ctx context.Context, c *SSHClient, client executor, events EventSink) error {
+				err := c.uploadRelease(ctx, client, project, release, events)
+				// A release dir that existed already belongs to another upload.
+				if status, ok := exitStatus(err); err != nil && (!ok || status != pathCreateExitCode) {
+					c.discardRelease(ctx, client, project, release)
+				}
+				return err
+			},
+		},
+		{
+			name:    "validate",
+			command: validate,
+			dir:     validateDir,
+			local: func(ctx context.Context, c *SSHClient, client executor, events EventSink) error {
+				err := c.runStep(ctx, client, project, "validate", shell.InDir(validateDir, validate), events)
+				if err != nil {
+					c.discardRelease(ctx, client, project, release)
+				}
+				return err
+			},
+		},
+		{name: "prune", command: pruneCommand(project), dir: project.Path},
+		switchReleaseStep(project, id),
+	}
+}
+
+// switchReleaseStep atomically points the current symlink at release id.
+func switchReleaseStep(project config.Project, id string) step {
+	release := shell.Quote(path.Join(releasesDir, id))
+	return step{
+		name: "release",
+		command: fmt.Sprintf("test -d %[1]s && ln -sfn %[1]s %[2]s.goploy && mv -Tf %[2]s.goploy %[2]s",
+			release, currentLink),
+		dir: project.Path,
+	}
+}
+
+// pruneCommand removes all but the newest upload.keep releases, the live release is always kept.
+func pruneCommand(project config.Project) string {
+	keep := project.Upload.Keep
+	if keep <= 0 {
+		keep = defaultKeepReleases
+	}
+
+	// The new release is already among the newest, the live one is kept even if it is older.
+	return fmt.Sprintf("ls -1 %[1]s | sort -r | tail -n +%[2]d | grep -vx \"$(basename \"$(readlink %[3]s)\")\" | sed 's|^|%[1]s/|' | xargs -r rm -rf",
+		releasesDir, keep+1, currentLink)
+}
+
+// uploadRelease streams the upload directory as tar archive into the release directory on the host.
+func (c *SSHClient) uploadRelease(ctx context.Context, client executor, project config.Project, release string, events EventSink) error {
+	root := project.Upload.Path
+	rules, err := loadIgnoreRules(filepath.Join(root, ignoreFile))
+	if err != nil {
+		return err
+	}
+
+	archive, w := io.Pipe()
+	defer archive.Close()
+	go func() {
+		w.CloseWithError(writeTar(w, root, rules))
+	}()
+
+	// The release dir must be new, see pathCreateExitCode.
+	remoteCommand := shell.InDir(project.Path, fmt.Sprintf("mkdir -p %s && { %s || exit %d; } && %s",
+		releasesDir, shell.Command("mkdir", release), pathCreateExitCode, shell.Command("tar -x -C", release)))
+	err = c.streamCommand(ctx, client, remoteCommand, archive, uploadPhase, events, func(n int64) {
+		emitMessage(events, uploadPhase, "%s uploaded", formatBytes(n))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload release: %w", err)
+	}
+	return nil
+}
+
+// discardRelease removes a release that failed to upload or validate. Best effort, even if ctx has been cancelled.
+func (c *SSHClient) discardRelease(ctx context.Context, client executor, project config.Project, release string) {
+	cleanupCtx, cancel := cleanupContext(ctx)
+	defer cancel()
+
+	_ = c.runSession(client, shell.InDir(project.Path, shell.Command("rm -rf", release)), io.Discard, io.Discard, cleanupCtx)
+}
+
+// writeTar writes the directory tree below root as tar archive to w, skipping ignored paths.
+func writeTar(w io.Writer, root string, rules ignoreRules) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if rules.match(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var link string
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		case !info.Mode().IsRegular() && !info.IsDir():
+			// Sockets, devices and pipes can't be uploaded.
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// ignoreRules are the patterns of a .goployignore file. Patterns without a slash match the name
+// of a file or directory anywhere, others match the path relative to the upload directory.
+// A trailing slash restricts a pattern to directories. .git is always ignored.
+type ignoreRules []ignorePattern
+
+type ignorePattern struct {
+	pattern  string
+	anchored bool
+	dirOnly  bool
+}
+
+// loadIgnoreRules reads the ignore file, a missing file ignores .git only.
+func loadIgnoreRules(file string) (ignoreRules, error) {
+	rules := ignoreRules{{pattern: ".git"}}
+
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p := ignorePattern{}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		p.anchored = strings.Contains(line, "/")
+		p.pattern = strings.TrimPrefix(line, "/")
+
+		if _, err := path.Match(p.pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in %s: %w", line, file, err)
+		}
+		rules = append(rules, p)
+	}
+
+	return rules, scanner.Err()
+}
+
+// match reports whether the slash-separated path rel is ignored.
+func (r ignoreRules) match(rel string, isDir bool) bool {
+	for _, p := range r {
+		if p.dirOnly && !isDir {
+			continue
+		}
+
+		name := path.Base(rel)
+		if p.anchored {
+			name = rel
+		}
+		if ok, _ := path.Match(p.pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
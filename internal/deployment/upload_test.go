@@ -0,0 +1,150 @@
+package deployment
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pmaojo/goploy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeUploadDir creates a local upload directory with the given files, content is the file name.
+func writeUploadDir(t *testing.T, files ...string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for _, f := range files {
+		p := filepath.Join(root, filepath.FromSlash(f))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(f), 0o644))
+	}
+	return root
+}
+
+func tarNames(t *testing.T, r io.Reader) []string {
+	t.Helper()
+
+	var names []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	root := writeUploadDir(t, ignoreFile)
+	require.NoError(t, os.WriteFile(filepath.Join(root, ignoreFile), []byte("# comment\n*.log\nnode_modules/\n/tmp/cache\n"), 0o644))
+
+	rules, err := loadIgnoreRules(filepath.Join(root, ignoreFile))
+	require.NoError(t, err)
+
+	assert.True(t, rules.match(".git", true))
+	assert.True(t, rules.match("app.log", false))
+	assert.True(t, rules.match("logs/app.log", false))
+	assert.True(t, rules.match("web/node_modules", true))
+	assert.False(t, rules.match("node_modules", false))
+	assert.True(t, rules.match("tmp/cache", true))
+	assert.False(t, rules.match("web/tmp/cache", true))
+	assert.False(t, rules.match("docker-compose.yml", false))
+
+	rules, err = loadIgnoreRules(filepath.Join(root, "missing"))
+	require.NoError(t, err)
+	assert.Len(t, rules, 1)
+}
+
+func TestWriteTar(t *testing.T) {
+	root := writeUploadDir(t, "docker-compose.yml", "app/main.go", "app/debug.log", ".git/HEAD")
+	rules := ignoreRules{{pattern: ".git"}, {pattern: "*.log"}}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeTar(&buf, root, rules))
+
+	assert.Equal(t, []string{"app/", "app/main.go", "docker-compose.yml"}, tarNames(t, &buf))
+}
+
+func TestUploadRelease(t *testing.T) {
+	var received syncBuffer
+	server := newTestSSHServer(t, func(cmd string, stdin io.Reader, _, _ io.Writer, _ <-chan string) int {
+		io.Copy(&received, stdin)
+		return 0
+	})
+
+	client := server.connect(t)
+
+	project := config.Project{Path: "/srv/api", Strategy: config.StrategyUpload, Upload: &config.Upload{Path: writeUploadDir(t, "docker-compose.yml")}}
+	sink := &recordingSink{}
+
+	c := &SSHClient{}
+	require.NoError(t, c.uploadRelease(context.Background(), client, project, "releases/20240501120000", sink))
+
+	assert.Equal(t, `cd '/srv/api' && mkdir -p releases && { mkdir 'releases/20240501120000' || exit 73; } && tar -x -C 'releases/20240501120000'`, server.executed()[0])
+	assert.Equal(t, []string{"docker-compose.yml"}, tarNames(t, strings.NewReader(received.String())))
+
+	messages := sink.ofType(EventMessage)
+	require.NotEmpty(t, messages)
+	assert.True(t, strings.HasSuffix(messages[len(messages)-1].Text, " uploaded"))
+}
+
+func TestReleaseSteps_DiscardFailedRelease(t *testing.T) {
+	bin := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "docker"), []byte("#!/bin/sh\necho 'invalid compose file' >&2\nexit 1\n"), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	project := config.Project{Name: "api", Host: config.LocalHost, Path: t.TempDir(), Strategy: config.StrategyUpload,
+		Upload: &config.Upload{Path: writeUploadDir(t, "docker-compose.yml")}}
+	id := newReleaseID()
+	assert.Regexp(t, `^\d{14}\.\d{9}$`, id)
+	release := filepath.Join(project.Path, releasesDir, id)
+
+	// upload and validate
+	steps := releaseSteps(project, id)[:2]
+	c := &SSHClient{}
+	err := c.runSteps(context.Background(), localExecutor{}, project, steps, &recordingSink{})
+	require.ErrorContains(t, err, "step validate failed")
+	assert.NoDirExists(t, release)
+
+	// The release dir of another upload is left alone.
+	require.NoError(t, os.MkdirAll(release, 0o755))
+	err = c.runSteps(context.Background(), localExecutor{}, project, steps, &recordingSink{})
+	require.ErrorContains(t, err, "step upload failed")
+	assert.DirExists(t, release)
+}
+
+func TestReleaseSteps(t *testing.T) {
+	project := config.Project{Path: "/srv/api", Strategy: config.StrategyUpload, Upload: &config.Upload{Path: "/home/dev/api", Keep: 3}}
+
+	steps := deploySteps(project, "")
+	var names []string
+	for _, s := range steps {
+		names = append(names, s.name)
+	}
+	assert.Equal(t, []string{"upload", "validate", "prune", "release", "image pull", "up"}, names)
+
+	assert.NotNil(t, steps[0].local)
+	assert.Equal(t, "/srv/api", steps[0].dir)
+	assert.True(t, strings.HasPrefix(steps[1].dir, "/srv/api/releases/"))
+	assert.Equal(t, "docker compose -p 'api' config -q", steps[1].command)
+	assert.Contains(t, steps[2].command, "tail -n +4")
+	assert.Equal(t, "docker compose -p 'api' up -d --build", steps[5].command)
+	assert.Empty(t, steps[5].dir)
+	assert.Equal(t, "/srv/api/current", workDir(project))
+
+	rollback := versionStep(project, "20240501120000")
+	assert.Equal(t, "test -d 'releases/20240501120000' && ln -sfn 'releases/20240501120000' current.goploy && mv -Tf current.goploy current", rollback.command)
+	assert.Equal(t, "/srv/api", rollback.dir)
+
+	assert.EqualError(t, validateStrategy(project, "main"), "upload deployments take no ref, the local directory is deployed as is")
+	assert.EqualError(t, validateStrategy(config.Project{Strategy: config.StrategyUpload}, ""), "the upload strategy requires upload.path")
+}
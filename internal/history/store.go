@@ -0,0 +1,224 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pmaojo/goploy/internal/config"
+)
+
+// DefaultDir is used when goploy.yaml does not configure a history_dir.
+const DefaultDir = ".goploy/history"
+
+// Trigger values identify which interface started a deployment.
+const (
+	TriggerTUI = "tui"
+	TriggerAPI = "api"
+)
+
+// Status values of a recorded deployment.
+const (
+	StatusRunning = "running"
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+// Action values of a recorded deployment.
+const (
+	ActionDeploy   = "deploy"
+	ActionRollback = "rollback"
+)
+
+// ErrNotFound is returned when a deployment record does not exist.
+var ErrNotFound = errors.New("deployment not found")
+
+// ErrNoRollbackTarget is returned when there is no earlier successful deployment to roll back to.
+var ErrNoRollbackTarget = errors.New("no previous successful deployment to roll back to")
+
+// Deployment is a single recorded deployment run.
+type Deployment struct {
+	ID           string    `json:"id"`
+	Project      string    `json:"project"`
+	Action       string    `json:"action"`
+	Ref          string    `json:"ref"`
+	CommitBefore string    `json:"commit_before"`
+	CommitAfter  string    `json:"commit_after"`
+	TriggeredBy  string    `json:"triggered_by"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	Status       string    `json:"status"`
+	ExitCode     int       `json:"exit_code"`
+	Error        string    `json:"error,omitempty"`
+	Output       string    `json:"output,omitempty"`
+}
+
+// Store persists deployment records as JSON files, one directory per project named after its slug (see config.Slug).
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewStore creates a Store rooted at dir. The directory is created lazily on first write.
+func NewStore(dir string) *Store {
+	if dir == "" {
+		dir = DefaultDir
+	}
+
+	return &Store{dir: dir}
+}
+
+// Save writes the deployment record, assigning an ID if it does not have one yet.
+// Saving an existing record overwrites it, which is how running deployments are finalized.
+func (s *Store) Save(d *Deployment) error {
+	if d.ID == "" {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return fmt.Errorf("failed to generate deployment id: %w", err)
+		}
+		d.ID = id.String()
+	}
+
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode deployment: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	projectDir := filepath.Join(s.dir, config.Slug(d.Project))
+	if err := os.MkdirAll(projectDir, 0o750); err != nil {
+		return fmt.Errorf("failed to create history dir: %w", err)
+	}
+
+	// Write to a temp file first so readers never see a partially written record.
+	tmp, err := os.CreateTemp(projectDir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create history file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(projectDir, d.ID+".json")); err != nil {
+		return fmt.Errorf("failed to store history file: %w", err)
+	}
+
+	return nil
+}
+
+// List returns all recorded deployments of a project, newest first.
+func (s *Store) List(project string) ([]Deployment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	projectDir := filepath.Join(s.dir, config.Slug(project))
+	entries, err := os.ReadDir(projectDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []Deployment{}, nil
+		}
+		return nil, fmt.Errorf("failed to read history dir: %w", err)
+	}
+
+	deployments := make([]Deployment, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		d, err := readDeployment(filepath.Join(projectDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, d)
+	}
+
+	sort.Slice(deployments, func(i, j int) bool {
+		if deployments[i].StartedAt.Equal(deployments[j].StartedAt) {
+			return deployments[i].ID > deployments[j].ID
+		}
+		return deployments[i].StartedAt.After(deployments[j].StartedAt)
+	})
+
+	return deployments, nil
+}
+
+// Get returns a single deployment of a project by its ID.
+func (s *Store) Get(project string, id string) (Deployment, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return Deployment{}, ErrNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, err := readDeployment(filepath.Join(s.dir, config.Slug(project), id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return Deployment{}, ErrNotFound
+	}
+	return d, err
+}
+
+// RollbackTarget returns the most recent successful deployment of a project that resulted
+// in a different commit than the one deployed last. Rollbacks are not targets themselves, and
+// deployments a later rollback moved away from are skipped, so consecutive rollbacks keep going back.
+func (s *Store) RollbackTarget(project string) (Deployment, error) {
+	deployments, err := s.List(project)
+	if err != nil {
+		return Deployment{}, err
+	}
+
+	current := ""
+	for _, d := range deployments {
+		if d.CommitAfter != "" {
+			current = d.CommitAfter
+			break
+		}
+	}
+
+	rolledBack := make(map[string]bool)
+	for _, d := range deployments {
+		if d.Status != StatusSuccess || d.CommitAfter == "" {
+			continue
+		}
+		if d.Action == ActionRollback {
+			if d.CommitBefore != "" {
+				rolledBack[d.CommitBefore] = true
+			}
+			continue
+		}
+		if d.CommitAfter != current && !rolledBack[d.CommitAfter] {
+			return d, nil
+		}
+	}
+
+	return Deployment{}, ErrNoRollbackTarget
+}
+
+func readDeployment(path string) (Deployment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Deployment{}, err
+	}
+
+	var d Deployment
+	if err := json.Unmarshal(data, &d); err != nil {
+		return Deployment{}, fmt.Errorf("failed to decode history file %s: %w", path, err)
+	}
+	return d, nil
+}
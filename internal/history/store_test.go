@@ -0,0 +1,143 @@
+package history_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_SaveListGet(t *testing.T) {
+	store := history.NewStore(t.TempDir())
+
+	first := &history.Deployment{
+		Project:   "Marketing Site",
+		Ref:       "main",
+		StartedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Status:    history.StatusRunning,
+	}
+	require.NoError(t, store.Save(first))
+	require.NotEmpty(t, first.ID)
+
+	first.Status = history.StatusSuccess
+	first.CommitAfter = "abc123"
+	require.NoError(t, store.Save(first))
+
+	second := &history.Deployment{
+		Project:   "Marketing Site",
+		StartedAt: time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC),
+		Status:    history.StatusFailure,
+	}
+	require.NoError(t, store.Save(second))
+
+	deployments, err := store.List("Marketing Site")
+	require.NoError(t, err)
+	require.Len(t, deployments, 2)
+	assert.Equal(t, second.ID, deployments[0].ID)
+	assert.Equal(t, first.ID, deployments[1].ID)
+	assert.Equal(t, history.StatusSuccess, deployments[1].Status)
+
+	got, err := store.Get("Marketing Site", first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", got.CommitAfter)
+	assert.Equal(t, "main", got.Ref)
+}
+
+func TestStore_EmptyAndMissing(t *testing.T) {
+	store := history.NewStore(t.TempDir())
+
+	deployments, err := store.List("unknown")
+	require.NoError(t, err)
+	assert.Empty(t, deployments)
+
+	_, err = store.Get("unknown", "does-not-exist")
+	assert.ErrorIs(t, err, history.ErrNotFound)
+
+	_, err = store.Get("unknown", "../../etc/passwd")
+	assert.ErrorIs(t, err, history.ErrNotFound)
+}
+
+func TestStore_RollbackTarget(t *testing.T) {
+	store := history.NewStore(t.TempDir())
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	_, err := store.RollbackTarget("api")
+	require.ErrorIs(t, err, history.ErrNoRollbackTarget)
+
+	save := func(offset time.Duration, status string, commit string) {
+		t.Helper()
+		require.NoError(t, store.Save(&history.Deployment{
+			Project:     "api",
+			StartedAt:   start.Add(offset),
+			Status:      status,
+			CommitAfter: commit,
+		}))
+	}
+
+	save(0, history.StatusSuccess, "aaa")
+	save(time.Hour, history.StatusSuccess, "bbb")
+
+	target, err := store.RollbackTarget("api")
+	require.NoError(t, err)
+	assert.Equal(t, "aaa", target.CommitAfter)
+
+	// A failed deploy leaves "ccc" checked out, the last good state is "bbb"
+	save(2*time.Hour, history.StatusFailure, "ccc")
+
+	target, err = store.RollbackTarget("api")
+	require.NoError(t, err)
+	assert.Equal(t, "bbb", target.CommitAfter)
+
+	// Once rolled back to "bbb", the next rollback goes further back
+	save(3*time.Hour, history.StatusSuccess, "bbb")
+
+	target, err = store.RollbackTarget("api")
+	require.NoError(t, err)
+	assert.Equal(t, "aaa", target.CommitAfter)
+}
+
+func TestStore_RollbackTarget_Consecutive(t *testing.T) {
+	store := history.NewStore(t.TempDir())
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	save := func(offset time.Duration, action string, before string, after string) {
+		t.Helper()
+		require.NoError(t, store.Save(&history.Deployment{
+			Project:      "api",
+			Action:       action,
+			StartedAt:    start.Add(offset),
+			Status:       history.StatusSuccess,
+			CommitBefore: before,
+			CommitAfter:  after,
+		}))
+	}
+
+	save(0, history.ActionDeploy, "", "aaa")
+	save(time.Hour, history.ActionDeploy, "aaa", "bbb")
+	save(2*time.Hour, history.ActionDeploy, "bbb", "ccc")
+
+	// The first rollback leaves the bad "ccc" for "bbb"
+	target, err := store.RollbackTarget("api")
+	require.NoError(t, err)
+	assert.Equal(t, "bbb", target.CommitAfter)
+	save(3*time.Hour, history.ActionRollback, "ccc", "bbb")
+
+	// The second one keeps going back instead of returning to "ccc"
+	target, err = store.RollbackTarget("api")
+	require.NoError(t, err)
+	assert.Equal(t, "aaa", target.CommitAfter)
+	save(4*time.Hour, history.ActionRollback, "bbb", "aaa")
+
+	_, err = store.RollbackTarget("api")
+	assert.ErrorIs(t, err, history.ErrNoRollbackTarget)
+
+	// Deploying "ccc" again makes it a target once more
+	save(5*time.Hour, history.ActionDeploy, "aaa", "ccc")
+	save(6*time.Hour, history.ActionDeploy, "ccc", "ddd")
+
+	target, err = store.RollbackTarget("api")
+	require.NoError(t, err)
+	assert.Equal(t, "ccc", target.CommitAfter)
+}
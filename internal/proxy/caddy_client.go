@@ -9,7 +9,6 @@ import (
 	"io"
 	"net/http"
 	"strings"
-	"unicode"
 
 	"github.com/pmaojo/goploy/internal/config"
 )
@@ -63,6 +62,18 @@ func (c *CaddyClient) ConfigureDomains(ctx context.Context, project config.Proje
 	}
 
 	routeID := routeIDFromProject(project.Name)
+
+	// Blue/green deployments move the upstream between colors, keep the live one.
+	if project.BlueGreen != nil {
+		current, err := c.currentUpstream(ctx, baseURL, routeID)
+		if err != nil {
+			return err
+		}
+		if current != "" {
+			upstream = current
+		}
+	}
+
 	route := buildRoutePayload(routeID, domains, upstream)
 	routeURL := fmt.Sprintf("%s/config/apps/http/servers/%s/routes/%s", baseURL, serverName, routeID)
 
@@ -86,6 +97,55 @@ func (c *CaddyClient) ConfigureDomains(ctx context.Context, project config.Proje
 	return nil
 }
 
+// SwitchUpstream points the project's route at upstream. Caddy applies the change atomically,
+// in-flight requests are finished by the previous upstream.
+func (c *CaddyClient) SwitchUpstream(ctx context.Context, project config.Project, upstream string) error {
+	if project.Caddy == nil {
+		return errors.New("caddy configuration missing on project")
+	}
+
+	baseURL := strings.TrimSuffix(project.Caddy.AdminURL, "/")
+	if baseURL == "" {
+		return errors.New("caddy admin_url is required")
+	}
+
+	routeID := routeIDFromProject(project.Name)
+	err := c.sendJSON(ctx, http.MethodPatch, upstreamsURL(baseURL, routeID), []caddyUpstream{{Dial: upstream}})
+	if errors.Is(err, errNotFound) {
+		return fmt.Errorf("caddy route %s not found, configure the project domains first", routeID)
+	}
+	return err
+}
+
+// currentUpstream returns the upstream the route currently proxies to, empty if the route does not exist yet.
+func (c *CaddyClient) currentUpstream(ctx context.Context, baseURL, routeID string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstreamsURL(baseURL, routeID), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// The route does not exist yet.
+	if resp.StatusCode != http.StatusOK {
+		return "", nil
+	}
+
+	var upstreams []caddyUpstream
+	if err := json.NewDecoder(resp.Body).Decode(&upstreams); err != nil || len(upstreams) == 0 {
+		return "", nil
+	}
+	return upstreams[0].Dial, nil
+}
+
+func upstreamsURL(baseURL, routeID string) string {
+	return fmt.Sprintf("%s/id/%s/handle/0/upstreams", baseURL, routeID)
+}
+
 type caddyRoute struct {
 	ID       string        `json:"@id"`
 	Match    []caddyMatch  `json:"match"`
@@ -125,12 +185,16 @@ func buildRoutePayload(routeID string, domains []string, upstream string) caddyR
 }
 
 func (c *CaddyClient) putJSON(ctx context.Context, url string, payload any) error {
+	return c.sendJSON(ctx, http.MethodPut, url, payload)
+}
+
+func (c *CaddyClient) sendJSON(ctx context.Context, method, url string, payload any) error {
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(payload); err != nil {
 		return err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, &buf)
+	req, err := http.NewRequestWithContext(ctx, method, url, &buf)
 	if err != nil {
 		return err
 	}
@@ -155,20 +219,5 @@ func (c *CaddyClient) putJSON(ctx context.Context, url string, payload any) erro
 }
 
 func routeIDFromProject(name string) string {
-	lower := strings.ToLower(name)
-	var b strings.Builder
-	for _, r := range lower {
-		switch {
-		case unicode.IsLetter(r) || unicode.IsDigit(r):
-			b.WriteRune(r)
-		case r == ' ' || r == '_' || r == '-':
-			b.WriteRune('-')
-		}
-	}
-
-	cleaned := strings.Trim(b.String(), "-")
-	if cleaned == "" {
-		cleaned = "project"
-	}
-	return "goploy-" + cleaned
+	return "goploy-" + config.Slug(name)
 }
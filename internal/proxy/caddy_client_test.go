@@ -107,3 +107,59 @@ func TestCaddyClient_CreatesServerWhenMissing(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, int32(3), atomic.LoadInt32(&requestCount))
 }
+
+func TestCaddyClient_SwitchUpstream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPatch, r.Method)
+		require.Equal(t, "/id/goploy-project-alpha/handle/0/upstreams", r.URL.Path)
+
+		var upstreams []struct {
+			Dial string `json:"dial"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&upstreams))
+		require.Len(t, upstreams, 1)
+		require.Equal(t, "localhost:8002", upstreams[0].Dial)
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	project := config.Project{Name: "Project Alpha", Caddy: &config.CaddyConfig{AdminURL: srv.URL}}
+	require.NoError(t, NewCaddyClient(nil).SwitchUpstream(context.Background(), project, "localhost:8002"))
+}
+
+func TestCaddyClient_SwitchUpstreamRouteMissing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	project := config.Project{Name: "Project Alpha", Caddy: &config.CaddyConfig{AdminURL: srv.URL}}
+	err := NewCaddyClient(nil).SwitchUpstream(context.Background(), project, "localhost:8002")
+	require.EqualError(t, err, "caddy route goploy-project-alpha not found, configure the project domains first")
+}
+
+func TestCaddyClient_ConfigureDomainsKeepsLiveColor(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			require.Equal(t, "/id/goploy-project-alpha/handle/0/upstreams", r.URL.Path)
+			_, _ = w.Write([]byte(`[{"dial":"localhost:8002"}]`))
+		case http.MethodPut:
+			var payload routePayload
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			require.Equal(t, "localhost:8002", payload.Handle[0].Upstreams[0].Dial)
+			w.WriteHeader(http.StatusOK)
+		default:
+			t.Fatalf("unexpected %s request", r.Method)
+		}
+	}))
+	defer srv.Close()
+
+	project := config.Project{
+		Name:      "Project Alpha",
+		Caddy:     &config.CaddyConfig{AdminURL: srv.URL, Upstream: "localhost:3000"},
+		BlueGreen: &config.BlueGreen{BluePort: 8001, GreenPort: 8002},
+	}
+	require.NoError(t, NewCaddyClient(nil).ConfigureDomains(context.Background(), project, []string{"alpha.example.com"}))
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/pmaojo/goploy/internal/config"
 	"github.com/pmaojo/goploy/internal/deployment"
+	"github.com/pmaojo/goploy/internal/shell"
 )
 
 // NginxClient handles Nginx configuration via SSH.
@@ -56,26 +57,26 @@ func (n *NginxClient) ConfigureDomains(ctx context.Context, project config.Proje
 	remoteFinalPath := fmt.Sprintf("%s/%s", configPath, confName)
 
 	// 1. Upload config to temp path
-	if err := n.controller.UploadFile(project, []byte(configContent), remoteTempPath); err != nil {
+	if err := n.controller.UploadFile(ctx, project, []byte(configContent), remoteTempPath); err != nil {
 		return fmt.Errorf("failed to upload nginx config: %w", err)
 	}
 
 	// 2. Move to final path (using sudo if needed)
-	moveCmd := fmt.Sprintf("sudo mv %s %s", remoteTempPath, remoteFinalPath)
-	if err := n.controller.RunCommand(project, moveCmd); err != nil {
+	moveCmd := shell.Command("sudo mv", remoteTempPath, remoteFinalPath)
+	if err := n.controller.RunCommand(ctx, project, moveCmd); err != nil {
 		return fmt.Errorf("failed to move config file (ensure passwordless sudo is configured for the deploy user): %w", err)
 	}
 
 	// 3. Symlink if sites-enabled path is not set to "-" (explicit disable)
 	if sitesEnabledPath != "-" {
-		linkCmd := fmt.Sprintf("sudo ln -sf %s %s/%s", remoteFinalPath, sitesEnabledPath, confName)
-		if err := n.controller.RunCommand(project, linkCmd); err != nil {
+		linkCmd := shell.Command("sudo ln -sf", remoteFinalPath, sitesEnabledPath+"/"+confName)
+		if err := n.controller.RunCommand(ctx, project, linkCmd); err != nil {
 			return fmt.Errorf("failed to symlink config to %s: %w", sitesEnabledPath, err)
 		}
 	}
 
 	// 4. Test config
-	if err := n.controller.RunCommand(project, "sudo nginx -t"); err != nil {
+	if err := n.controller.RunCommand(ctx, project, "sudo nginx -t"); err != nil {
 		return fmt.Errorf("nginx config test failed: %w", err)
 	}
 
@@ -84,7 +85,7 @@ func (n *NginxClient) ConfigureDomains(ctx context.Context, project config.Proje
 	if reloadCmd == "" {
 		reloadCmd = "sudo systemctl reload nginx"
 	}
-	if err := n.controller.RunCommand(project, reloadCmd); err != nil {
+	if err := n.controller.RunCommand(ctx, project, reloadCmd); err != nil {
 		return fmt.Errorf("failed to reload nginx: %w", err)
 	}
 
@@ -16,45 +16,56 @@ type MockController struct {
 	mock.Mock
 }
 
-func (m *MockController) Deploy(project config.Project, output io.Writer, ref string) error {
+func (m *MockController) Deploy(ctx context.Context, project config.Project, events deployment.EventSink, ref string) error {
 	// args := m.Called(project, output, ref)
 	// return args.Error(0)
 	return nil
 }
 
+func (m *MockController) Init(ctx context.Context, project config.Project, events deployment.EventSink) error {
+	return nil
+}
+
+func (m *MockController) Plan(ctx context.Context, project config.Project, ref string) (deployment.DeploymentPlan, error) {
+	return deployment.DeploymentPlan{}, nil
+}
+
+func (m *MockController) Rollback(ctx context.Context, project config.Project, events deployment.EventSink) error {
+	return nil
+}
+
 func (m *MockController) StreamLogs(ctx context.Context, project config.Project, output io.Writer) error {
 	// args := m.Called(ctx, project, output)
 	// return args.Error(0)
 	return nil
 }
 
-func (m *MockController) Restart(project config.Project, output io.Writer) error {
+func (m *MockController) Restart(ctx context.Context, project config.Project, output io.Writer) error {
 	return nil
 }
-func (m *MockController) Stop(project config.Project, output io.Writer) error {
+func (m *MockController) Stop(ctx context.Context, project config.Project, output io.Writer) error {
 	return nil
 }
-func (m *MockController) ListServices(project config.Project) ([]string, error) {
+func (m *MockController) ListServices(ctx context.Context, project config.Project) ([]string, error) {
 	return nil, nil
 }
-func (m *MockController) RunShell(project config.Project, service string) error {
+func (m *MockController) RunShell(ctx context.Context, project config.Project, service string) error {
 	return nil
 }
 func (m *MockController) GetStatus(ctx context.Context, project config.Project) (deployment.ProjectStatus, error) {
 	return deployment.ProjectStatus{}, nil
 }
 
-func (m *MockController) UploadFile(project config.Project, content []byte, remotePath string) error {
+func (m *MockController) UploadFile(ctx context.Context, project config.Project, content []byte, remotePath string) error {
 	args := m.Called(project, content, remotePath)
 	return args.Error(0)
 }
 
-func (m *MockController) RunCommand(project config.Project, cmd string) error {
+func (m *MockController) RunCommand(ctx context.Context, project config.Project, cmd string) error {
 	args := m.Called(project, cmd)
 	return args.Error(0)
 }
 
-
 func TestNginxClient_ConfigureDomains(t *testing.T) {
 	mockCtrl := new(MockController)
 	client := NewNginxClient(mockCtrl)
@@ -72,10 +83,10 @@ func TestNginxClient_ConfigureDomains(t *testing.T) {
 	mockCtrl.On("UploadFile", project, mock.Anything, "/tmp/test_project.nginx.conf").Return(nil)
 
 	// 2. Move file
-	mockCtrl.On("RunCommand", project, "sudo mv /tmp/test_project.nginx.conf /etc/nginx/sites-available/test_project").Return(nil)
+	mockCtrl.On("RunCommand", project, "sudo mv '/tmp/test_project.nginx.conf' '/etc/nginx/sites-available/test_project'").Return(nil)
 
 	// 3. Symlink
-	mockCtrl.On("RunCommand", project, "sudo ln -sf /etc/nginx/sites-available/test_project /etc/nginx/sites-enabled/test_project").Return(nil)
+	mockCtrl.On("RunCommand", project, "sudo ln -sf '/etc/nginx/sites-available/test_project' '/etc/nginx/sites-enabled/test_project'").Return(nil)
 
 	// 4. Test config
 	mockCtrl.On("RunCommand", project, "sudo nginx -t").Return(nil)
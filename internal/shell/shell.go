@@ -0,0 +1,31 @@
+// Package shell builds the command lines run by the POSIX shell of remote hosts.
+//
+// Every value that is not part of the program itself (refs, service names, paths) is passed
+// through Quote, the shell then sees it as exactly one literal word.
+package shell
+
+import "strings"
+
+// Quote quotes s as a single POSIX shell word, nothing in it is expanded or interpreted.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Command returns the command line running program with args, every argument is quoted.
+// program is trusted and taken as is, like "git checkout" or "docker compose -f 'prod.yml'".
+func Command(program string, args ...string) string {
+	if len(args) == 0 {
+		return program
+	}
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = Quote(arg)
+	}
+	return program + " " + strings.Join(quoted, " ")
+}
+
+// InDir returns the command line running command in dir.
+func InDir(dir, command string) string {
+	return Command("cd", dir) + " && " + command
+}
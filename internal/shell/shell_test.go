@@ -0,0 +1,57 @@
+package shell
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuote(t *testing.T) {
+	assert.Equal(t, "'/var/www/app'", Quote("/var/www/app"))
+	assert.Equal(t, `'it'\''s'`, Quote("it's"))
+	assert.Equal(t, "''", Quote(""))
+}
+
+func TestCommand(t *testing.T) {
+	assert.Equal(t, "git pull", Command("git pull"))
+	assert.Equal(t, "git checkout 'v1.2.0'", Command("git checkout", "v1.2.0"))
+	assert.Equal(t, `sudo mv '/tmp/a b' '/etc/$(id)'`, Command("sudo mv", "/tmp/a b", "/etc/$(id)"))
+	assert.Equal(t, "cd '/srv/app' && git pull", InDir("/srv/app", "git pull"))
+}
+
+// FuzzCommand runs the built command lines with sh: whatever the arguments, printf receives
+// each of them unchanged as a single argument and nothing else is run.
+func FuzzCommand(f *testing.F) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		f.Skip("sh not available")
+	}
+
+	for _, seed := range [][2]string{
+		{"main", ""},
+		{"v1; rm -rf /", "$(id)"},
+		{"`id`", "a'b\"c"},
+		{"'", `\'`},
+		{"$HOME", "${IFS}x"},
+		{"a\nb", "*"},
+		{"-n", "&& echo injected #"},
+		{"~root", "a|b>c<d"},
+	} {
+		f.Add(seed[0], seed[1])
+	}
+
+	f.Fuzz(func(t *testing.T, a, b string) {
+		if strings.ContainsRune(a+b, 0) {
+			t.Skip("NUL bytes cannot be passed to a shell")
+		}
+
+		// printf prints the arguments terminated by NUL bytes, they cannot be part of an argument.
+		cmd := InDir("/", Command(`printf '%s\0'`, a, b))
+		out, err := exec.Command(sh, "-c", cmd).Output()
+		require.NoError(t, err, cmd)
+		assert.Equal(t, a+"\x00"+b+"\x00", string(out), cmd)
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/pmaojo/goploy/internal/api/router"
 	"github.com/pmaojo/goploy/internal/config"
 	"github.com/pmaojo/goploy/internal/deployment"
+	"github.com/pmaojo/goploy/internal/history"
 )
 
 // WithTestServer returns a fully configured server (using the default server config).
@@ -49,7 +50,9 @@ func execClosureNewTestServer(ctx context.Context, t *testing.T, serverConfig co
 	// But looking at NewServer, it just assigns it.
 	var mockController deployment.Controller = deployment.NewSSHClient(nil)
 
-	s := api.NewServer(serverConfig, mockGoployConfig, mockMailer, mockController)
+	mockHistory := history.NewStore(t.TempDir())
+
+	s := api.NewServer(serverConfig, mockGoployConfig, mockMailer, mockController, mockHistory)
 
 	err := router.Init(s)
 	if err != nil {
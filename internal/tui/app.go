@@ -2,6 +2,7 @@ package tui
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/pmaojo/goploy/internal/config"
 	"github.com/pmaojo/goploy/internal/deployment"
+	"github.com/pmaojo/goploy/internal/history"
 	"github.com/pmaojo/goploy/internal/proxy"
 )
 
@@ -24,6 +26,12 @@ type App struct {
 	ProjectList        *tview.List
 	Controller         deployment.Controller
 	DomainConfigurator proxy.Configurator
+	// History is used to preview rollback targets, optional.
+	History *history.Store
+
+	// ctx is cancelled when the TUI exits, interrupting running remote commands.
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	// State for managing running tasks
 	logCancelCtx context.Context
@@ -33,7 +41,20 @@ type App struct {
 }
 
 func NewApp(cfg *config.GoployConfig) *App {
-	return NewAppWithDependencies(cfg, deployment.NewSSHClient(nil), proxy.NewCaddyClient(nil))
+	store := history.NewStore(cfg.HistoryDir)
+
+	caddy := proxy.NewCaddyClient(nil)
+
+	controller := deployment.NewSSHClient(nil)
+	controller.History = store
+	controller.Trigger = history.TriggerTUI
+	controller.Upstreams = caddy
+
+	app := NewAppWithDependencies(cfg, controller, caddy)
+	app.History = store
+	controller.HostKeyPrompt = app.confirmHostKey
+
+	return app
 }
 
 // NewAppWithDependencies allows injecting collaborators for testing.
@@ -45,6 +66,7 @@ func NewAppWithDependencies(cfg *config.GoployConfig, controller deployment.Cont
 		Controller:         controller,
 		DomainConfigurator: domainConfigurator,
 	}
+	app.ctx, app.cancel = context.WithCancel(context.Background())
 
 	// Initialize the UI
 	app.setupUI()
@@ -72,6 +94,8 @@ func (a *App) setupUI() {
 	// Create the project list
 	a.ProjectList = NewProjectList(a.Config.Projects, &ProjectListHandlers{
 		OnDeploy:           func(p config.Project) { a.handleDeployment(p) },
+		OnPlan:             func(p config.Project) { a.handlePlan(p) },
+		OnRollback:         func(p config.Project) { a.handleRollback(p) },
 		OnLogs:             func(p config.Project) { a.handleLogs(p) },
 		OnRestart:          func(p config.Project) { a.handleRestart(p) },
 		OnStop:             func(p config.Project) { a.handleStop(p) },
@@ -107,7 +131,16 @@ func (a *App) setupUI() {
 }
 
 func (a *App) Run() error {
-	return a.TviewApp.Run()
+	err := a.TviewApp.Run()
+
+	a.cancel()
+
+	// Close pooled SSH connections of the controller, if any.
+	if closer, ok := a.Controller.(io.Closer); ok {
+		closer.Close()
+	}
+
+	return err
 }
 
 func (a *App) getWriter() io.Writer {
@@ -188,6 +221,12 @@ func (a *App) updateStatus(ctx context.Context, project config.Project) {
 		fmt.Fprintf(a.DetailsView, "[green]Branch:[white] %s\n", status.Branch)
 		fmt.Fprintf(a.DetailsView, "[green]Last Deployed:[white] %s\n", status.LastDeployedAt.Format("2006-01-02 15:04:05"))
 		fmt.Fprintf(a.DetailsView, "[green]Status:[white] %s\n", status.Status)
+		if len(status.Hosts) > 1 {
+			fmt.Fprintf(a.DetailsView, "\n[yellow]Hosts:[white]\n")
+			for _, h := range status.Hosts {
+				fmt.Fprintf(a.DetailsView, "- %s\n", formatHostStatus(h))
+			}
+		}
 		fmt.Fprintf(a.DetailsView, "\n[yellow]Containers:[white]\n")
 		for _, c := range status.Containers {
 			stateColor := "red"
@@ -210,6 +249,41 @@ func (a *App) updateStatus(ctx context.Context, project config.Project) {
 	})
 }
 
+// formatHostStatus summarizes a host of a multi-host project for the details view.
+func formatHostStatus(h deployment.HostStatus) string {
+	if h.Error != "" {
+		return fmt.Sprintf("%s: [red]%s[white] (%s)", h.Host, h.Status, h.Error)
+	}
+
+	color := "green"
+	switch {
+	case h.Behind:
+		color = "yellow"
+	case h.Status != "Healthy":
+		color = "red"
+	}
+
+	line := fmt.Sprintf("%s: [%s]%s[white] · %s", h.Host, color, h.Status, shortCommit(h.Commit))
+	if h.Behind {
+		line += " [yellow](behind)[white]"
+	}
+	return line
+}
+
+// reportResult writes the outcome of a background operation to the log view.
+// Lock conflicts call out who is currently holding the project.
+func reportResult(writer io.Writer, action string, err error) {
+	var lockErr *deployment.LockError
+	switch {
+	case errors.As(err, &lockErr):
+		fmt.Fprintf(writer, "[red]%s refused: %s is locked by %s[white]\n", action, lockErr.Project, lockErr.Holder)
+	case err != nil:
+		fmt.Fprintf(writer, "[red]%s failed: %v[white]\n", action, err)
+	default:
+		fmt.Fprintf(writer, "[green]%s finished successfully.[white]\n", action)
+	}
+}
+
 func timeSince(t time.Time) string {
 	if t.IsZero() {
 		return "Never"
@@ -233,16 +307,129 @@ func (a *App) handleDeployment(project config.Project) {
 
 	go func() {
 		writer := a.getWriter()
+		events := newProgressSink(a.TviewApp, a.LogView, writer, "Deployment Logs (FR4)")
 		// TUI deployment doesn't specify ref currently (uses default)
-		err := a.Controller.Deploy(project, writer, "")
-		if err != nil {
-			fmt.Fprintf(writer, "[red]Deployment failed: %v[white]\n", err)
-		} else {
-			fmt.Fprintf(writer, "[green]Deployment finished successfully.[white]\n")
-		}
+		err := a.Controller.Deploy(a.ctx, project, events, "")
+		reportResult(writer, "Deployment", err)
+	}()
+}
+
+// handlePlan previews what a deployment would change, the preview can be confirmed into the deployment.
+func (a *App) handlePlan(project config.Project) {
+	a.cancelPreviousTask()
+	a.LogView.SetTitle("Deployment Plan")
+	a.LogView.Clear()
+	fmt.Fprintf(a.LogView, "[yellow]Planning deployment for %s...[white]\n", project.Name)
+
+	go func() {
+		plan, err := a.Controller.Plan(a.ctx, project, "")
+		a.TviewApp.QueueUpdateDraw(func() {
+			if err != nil {
+				fmt.Fprintf(a.LogView, "[red]Plan failed: %v[white]\n", err)
+				return
+			}
+			fmt.Fprint(a.LogView, formatPlan(plan))
+			a.showPlanModal(project, plan)
+		})
 	}()
 }
 
+func (a *App) showPlanModal(project config.Project, plan deployment.DeploymentPlan) {
+	modal := tview.NewModal().
+		SetText(planSummary(project.Name, plan)).
+		AddButtons([]string{"Deploy", "Cancel"}).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			a.Pages.RemovePage("plan_modal")
+			if buttonLabel == "Deploy" {
+				a.handleDeployment(project)
+			}
+		})
+
+	a.Pages.AddPage("plan_modal", modal, true, true)
+	a.TviewApp.SetFocus(modal)
+}
+
+func (a *App) handleRollback(project config.Project) {
+	a.cancelPreviousTask()
+	a.LogView.SetTitle("Rollback")
+	a.LogView.Clear()
+
+	if a.History == nil {
+		fmt.Fprintf(a.LogView, "[red]Rollback requires a deployment history.[white]\n")
+		return
+	}
+
+	target, err := a.History.RollbackTarget(project.Name)
+	if err != nil {
+		fmt.Fprintf(a.LogView, "[red]Cannot roll back %s: %v[white]\n", project.Name, err)
+		return
+	}
+
+	text := fmt.Sprintf("Roll back %s to commit %s?\n\nDeployed %s (ref: %s, by %s)",
+		project.Name, shortCommit(target.CommitAfter), target.StartedAt.Local().Format("2006-01-02 15:04:05"), target.Ref, target.TriggeredBy)
+
+	modal := tview.NewModal().
+		SetText(text).
+		AddButtons([]string{"Rollback", "Cancel"}).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			a.Pages.RemovePage("rollback_modal")
+			if buttonLabel != "Rollback" {
+				return
+			}
+
+			fmt.Fprintf(a.LogView, "[yellow]Rolling back %s to %s...[white]\n", project.Name, shortCommit(target.CommitAfter))
+
+			go func() {
+				writer := a.getWriter()
+				events := newProgressSink(a.TviewApp, a.LogView, writer, "Rollback")
+				err := a.Controller.Rollback(a.ctx, project, events)
+				reportResult(writer, "Rollback", err)
+			}()
+		})
+
+	a.Pages.AddPage("rollback_modal", modal, true, true)
+	a.TviewApp.SetFocus(modal)
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+// confirmHostKey asks whether to trust the key of a host contacted for the first time by a project
+// with the tofu host key policy. It runs on the deployment goroutine and blocks until answered.
+func (a *App) confirmHostKey(ctx context.Context, host, keyType, fingerprint string) bool {
+	answer := make(chan bool, 1)
+
+	a.TviewApp.QueueUpdateDraw(func() {
+		text := fmt.Sprintf("The authenticity of %s can't be established.\n\n%s key fingerprint:\n%s\n\nTrust it and add it to known_hosts?",
+			host, keyType, fingerprint)
+
+		modal := tview.NewModal().
+			SetText(text).
+			AddButtons([]string{"Trust", "Cancel"}).
+			SetDoneFunc(func(_ int, buttonLabel string) {
+				a.Pages.RemovePage("host_key_modal")
+				answer <- buttonLabel == "Trust"
+			})
+
+		a.Pages.AddPage("host_key_modal", modal, true, true)
+		a.TviewApp.SetFocus(modal)
+	})
+
+	select {
+	case trusted := <-answer:
+		return trusted
+	case <-ctx.Done():
+		a.TviewApp.QueueUpdateDraw(func() {
+			a.Pages.RemovePage("host_key_modal")
+		})
+		return false
+	}
+}
+
 func (a *App) handleLogs(project config.Project) {
 	a.cancelPreviousTask()
 	a.LogView.SetTitle("Monitoring Logs (FR5) - Press any other action to stop")
@@ -280,12 +467,8 @@ func (a *App) handleRestart(project config.Project) {
 
 	go func() {
 		writer := a.getWriter()
-		err := a.Controller.Restart(project, writer)
-		if err != nil {
-			fmt.Fprintf(writer, "[red]Restart failed: %v[white]\n", err)
-		} else {
-			fmt.Fprintf(writer, "[green]Restart finished successfully.[white]\n")
-		}
+		err := a.Controller.Restart(a.ctx, project, writer)
+		reportResult(writer, "Restart", err)
 	}()
 }
 
@@ -297,12 +480,8 @@ func (a *App) handleStop(project config.Project) {
 
 	go func() {
 		writer := a.getWriter()
-		err := a.Controller.Stop(project, writer)
-		if err != nil {
-			fmt.Fprintf(writer, "[red]Stop failed: %v[white]\n", err)
-		} else {
-			fmt.Fprintf(writer, "[green]Stop finished successfully.[white]\n")
-		}
+		err := a.Controller.Stop(a.ctx, project, writer)
+		reportResult(writer, "Stop", err)
 	}()
 }
 
@@ -321,7 +500,7 @@ func (a *App) handleShell(project config.Project) {
 
 	// Run fetching in goroutine
 	go func() {
-		services, err := a.Controller.ListServices(project)
+		services, err := a.Controller.ListServices(a.ctx, project)
 		if err != nil {
 			a.TviewApp.QueueUpdateDraw(func() {
 				fmt.Fprintf(a.LogView, "[red]Failed to fetch services: %v[white]\n", err)
@@ -356,7 +535,7 @@ func (a *App) showServiceSelectionModal(project config.Project, services []strin
 
 			// Suspend and Run Shell
 			a.TviewApp.Suspend(func() {
-				err := a.Controller.RunShell(project, s)
+				err := a.Controller.RunShell(a.ctx, project, s)
 				if err != nil {
 					// We are suspended, so we can print to stdout/stderr,
 					// but better to log it when we return.
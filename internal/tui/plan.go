@@ -0,0 +1,102 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pmaojo/goploy/internal/deployment"
+	"github.com/rivo/tview"
+)
+
+// formatPlan renders a deployment plan for the log view, with the diff of the compose and env files.
+func formatPlan(plan deployment.DeploymentPlan) string {
+	var b strings.Builder
+
+	for _, h := range plan.Hosts {
+		if h.Error != "" {
+			fmt.Fprintf(&b, "[red]%s: %s[white]\n", h.Host, tview.Escape(h.Error))
+			continue
+		}
+		if h.Current == h.Target {
+			fmt.Fprintf(&b, "[green]%s: up to date at %s[white]\n", h.Host, shortCommit(h.Current))
+		} else {
+			fmt.Fprintf(&b, "[yellow]%s: %s -> %s[white]\n", h.Host, shortCommit(h.Current), shortCommit(h.Target))
+		}
+
+		if len(h.Dirty) > 0 {
+			fmt.Fprintf(&b, "  [red]Uncommitted changes on the host:[white]\n")
+			for _, line := range h.Dirty {
+				fmt.Fprintf(&b, "    %s\n", tview.Escape(line))
+			}
+		}
+		if len(h.Commits) > 0 {
+			fmt.Fprintf(&b, "  %d commits:\n", len(h.Commits))
+			for _, c := range h.Commits {
+				fmt.Fprintf(&b, "    %s %s (%s, %s)\n", shortCommit(c.Commit), tview.Escape(c.Subject), tview.Escape(c.Author), c.Date.Format("2006-01-02"))
+			}
+		}
+		if len(h.Files) > 0 {
+			fmt.Fprintf(&b, "  %d files changed:\n", len(h.Files))
+			for _, f := range h.Files {
+				fmt.Fprintf(&b, "    %s %s\n", f.Status, tview.Escape(f.Path))
+			}
+		}
+		if len(h.Services) > 0 {
+			fmt.Fprintf(&b, "  Services:\n")
+			for _, s := range h.Services {
+				fmt.Fprintf(&b, "    %s\n", tview.Escape(formatServiceChange(s)))
+			}
+		}
+		if h.ConfigDiff != "" {
+			fmt.Fprintf(&b, "  Compose and env changes:\n")
+			for _, line := range strings.Split(strings.TrimSuffix(h.ConfigDiff, "\n"), "\n") {
+				color := "white"
+				switch {
+				case strings.HasPrefix(line, "+"):
+					color = "green"
+				case strings.HasPrefix(line, "-"):
+					color = "red"
+				}
+				fmt.Fprintf(&b, "    [%s]%s[white]\n", color, tview.Escape(line))
+			}
+		}
+	}
+
+	return b.String()
+}
+
+func formatServiceChange(s deployment.ServiceChange) string {
+	switch s.Change {
+	case deployment.ServiceImage:
+		return fmt.Sprintf("%s: %s -> %s", s.Service, s.From, s.To)
+	case deployment.ServiceAdded, deployment.ServiceRemoved:
+		image := s.To + s.From
+		if image == "" {
+			return fmt.Sprintf("%s: %s", s.Service, s.Change)
+		}
+		return fmt.Sprintf("%s: %s (%s)", s.Service, s.Change, image)
+	default:
+		return fmt.Sprintf("%s: %s", s.Service, s.Change)
+	}
+}
+
+// planSummary is the confirmation text of a plan, a line per host.
+func planSummary(projectName string, plan deployment.DeploymentPlan) string {
+	lines := []string{fmt.Sprintf("Deploy %s?", projectName), ""}
+	for _, h := range plan.Hosts {
+		var line string
+		switch {
+		case h.Error != "":
+			line = fmt.Sprintf("%s: not planned, %s", h.Host, h.Error)
+		case h.Current == h.Target:
+			line = fmt.Sprintf("%s: up to date", h.Host)
+		default:
+			line = fmt.Sprintf("%s: %d commits, %d services changing", h.Host, len(h.Commits), len(h.Services))
+		}
+		if len(h.Dirty) > 0 {
+			line += ", uncommitted changes!"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(append(lines, "", "See the log for details."), "\n")
+}
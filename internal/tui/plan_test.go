@@ -0,0 +1,56 @@
+package tui
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/deployment"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatPlan(t *testing.T) {
+	plan := deployment.DeploymentPlan{Project: "api", Hosts: []deployment.HostPlan{
+		{
+			Host:    "web1",
+			Current: "aaaaaaaaaaaaaaaa",
+			Target:  "bbbbbbbbbbbbbbbb",
+			Dirty:   []string{" M docker-compose.yml"},
+			Commits: []deployment.PlannedCommit{
+				{Commit: "bbbbbbbbbbbbbbbb", Author: "Dev <dev@example.com>", Date: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC), Subject: "[api] Bump"},
+			},
+			Files:      []deployment.ChangedFile{{Status: "M", Path: "docker-compose.yml"}},
+			ConfigDiff: "-    image: postgres:15\n+    image: postgres:16\n",
+			Services: []deployment.ServiceChange{
+				{Service: "db", Change: deployment.ServiceImage, From: "postgres:15", To: "postgres:16"},
+				{Service: "worker", Change: deployment.ServiceAdded},
+			},
+		},
+		{Host: "web2", Current: "aaaaaaaaaaaaaaaa", Target: "aaaaaaaaaaaaaaaa"},
+		{Host: "web3", Error: "connection failed: timeout"},
+	}}
+
+	assert.Equal(t, `[yellow]web1: aaaaaaaaaaaa -> bbbbbbbbbbbb[white]
+  [red]Uncommitted changes on the host:[white]
+     M docker-compose.yml
+  1 commits:
+    bbbbbbbbbbbb [api[] Bump (Dev <dev@example.com>, 2026-10-01)
+  1 files changed:
+    M docker-compose.yml
+  Services:
+    db: postgres:15 -> postgres:16
+    worker: added
+  Compose and env changes:
+    [red]-    image: postgres:15[white]
+    [green]+    image: postgres:16[white]
+[green]web2: up to date at aaaaaaaaaaaa[white]
+[red]web3: connection failed: timeout[white]
+`, formatPlan(plan))
+
+	assert.Equal(t, `Deploy api?
+
+web1: 1 commits, 2 services changing, uncommitted changes!
+web2: up to date
+web3: not planned, connection failed: timeout
+
+See the log for details.`, planSummary("api", plan))
+}
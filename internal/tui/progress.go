@@ -0,0 +1,95 @@
+package tui
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/deployment"
+	"github.com/rivo/tview"
+)
+
+// progress tracks the phases of a running deployment for the log view title.
+type progress struct {
+	title   string
+	current string
+	done    int
+	failed  string
+	result  *deployment.Outcome
+}
+
+func (p *progress) apply(event deployment.Event) {
+	switch event.Type {
+	case deployment.EventPhaseStarted:
+		p.current = phaseName(event)
+	case deployment.EventPhaseFinished:
+		p.current = ""
+		if event.Outcome != nil && event.Outcome.Error != "" {
+			p.failed = phaseName(event)
+		} else {
+			p.done++
+		}
+	case deployment.EventResult:
+		p.result = event.Outcome
+	}
+}
+
+// phaseName names the phase of event, including the host for multi-host projects.
+func phaseName(event deployment.Event) string {
+	if event.Host != "" {
+		return event.Host + " " + event.Phase
+	}
+	return event.Phase
+}
+
+func (p *progress) String() string {
+	switch {
+	case p.result != nil && p.result.Error != "":
+		return fmt.Sprintf("%s - failed after %s", p.title, p.result.Duration.Round(time.Second))
+	case p.result != nil:
+		return fmt.Sprintf("%s - finished in %s", p.title, p.result.Duration.Round(time.Second))
+	case p.failed != "":
+		return fmt.Sprintf("%s - %s failed", p.title, p.failed)
+	case p.current != "":
+		return fmt.Sprintf("%s - %s (%d steps done)", p.title, p.current, p.done)
+	default:
+		return p.title
+	}
+}
+
+// progressSink renders deployment events as text into the log view and shows the running phase in its title.
+type progressSink struct {
+	app  *tview.Application
+	view *tview.TextView
+	text *deployment.TextSink
+
+	mu       sync.Mutex
+	progress progress
+}
+
+func newProgressSink(app *tview.Application, view *tview.TextView, writer io.Writer, title string) *progressSink {
+	return &progressSink{
+		app:      app,
+		view:     view,
+		text:     deployment.NewTextSink(writer),
+		progress: progress{title: title},
+	}
+}
+
+func (s *progressSink) Emit(event deployment.Event) {
+	s.text.Emit(event)
+
+	if event.Type == deployment.EventLog || event.Type == deployment.EventMessage {
+		return
+	}
+
+	s.mu.Lock()
+	s.progress.apply(event)
+	title := s.progress.String()
+	s.mu.Unlock()
+
+	s.app.QueueUpdateDraw(func() {
+		s.view.SetTitle(title)
+	})
+}
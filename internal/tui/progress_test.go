@@ -0,0 +1,27 @@
+package tui
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pmaojo/goploy/internal/deployment"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgress(t *testing.T) {
+	p := progress{title: "Deployment"}
+	assert.Equal(t, "Deployment", p.String())
+
+	p.apply(deployment.Event{Type: deployment.EventPhaseStarted, Phase: "fetch"})
+	assert.Equal(t, "Deployment - fetch (0 steps done)", p.String())
+
+	p.apply(deployment.Event{Type: deployment.EventPhaseFinished, Phase: "fetch", Outcome: &deployment.Outcome{}})
+	p.apply(deployment.Event{Type: deployment.EventPhaseStarted, Phase: "image pull"})
+	assert.Equal(t, "Deployment - image pull (1 steps done)", p.String())
+
+	p.apply(deployment.Event{Type: deployment.EventPhaseFinished, Phase: "image pull", Outcome: &deployment.Outcome{ExitCode: 1, Error: "exit status 1"}})
+	assert.Equal(t, "Deployment - image pull failed", p.String())
+
+	p.apply(deployment.Event{Type: deployment.EventResult, Phase: "deploy", Outcome: &deployment.Outcome{ExitCode: 1, Duration: 3 * time.Second, Error: "step image pull failed"}})
+	assert.Equal(t, "Deployment - failed after 3s", p.String())
+}
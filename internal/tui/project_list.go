@@ -9,6 +9,8 @@ import (
 
 type ProjectListHandlers struct {
 	OnDeploy           func(config.Project)
+	OnPlan             func(config.Project)
+	OnRollback         func(config.Project)
 	OnLogs             func(config.Project)
 	OnRestart          func(config.Project)
 	OnStop             func(config.Project)
@@ -52,6 +54,16 @@ func NewProjectList(projects []config.Project, handlers *ProjectListHandlers) *t
 				handlers.OnDeploy(p)
 			}
 			return nil
+		case 'p', 'P': // Plan
+			if handlers.OnPlan != nil {
+				handlers.OnPlan(p)
+			}
+			return nil
+		case 'b', 'B': // Rollback
+			if handlers.OnRollback != nil {
+				handlers.OnRollback(p)
+			}
+			return nil
 		case 'c', 'C': // Configure Domains
 			if handlers.OnConfigureDomains != nil {
 				handlers.OnConfigureDomains(p)
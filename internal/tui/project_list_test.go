@@ -69,3 +69,37 @@ func TestNewProjectList_ConfiguresDomainsShortcut(t *testing.T) {
 	_ = capture(uppercase)
 	assert.Equal(t, "Project A", configuredProject.Name)
 }
+
+func TestNewProjectList_RollbackShortcut(t *testing.T) {
+	projects := []config.Project{
+		{Name: "Project A", Host: "host1", Path: "/path/a"},
+	}
+
+	var rolledBack config.Project
+	list := NewProjectList(projects, &ProjectListHandlers{
+		OnRollback: func(p config.Project) {
+			rolledBack = p
+		},
+	})
+
+	returned := list.GetInputCapture()(tcell.NewEventKey(tcell.KeyRune, 'b', 0))
+	assert.Nil(t, returned)
+	assert.Equal(t, "Project A", rolledBack.Name)
+}
+
+func TestNewProjectList_PlanShortcut(t *testing.T) {
+	projects := []config.Project{
+		{Name: "Project A", Host: "host1", Path: "/path/a"},
+	}
+
+	var planned config.Project
+	list := NewProjectList(projects, &ProjectListHandlers{
+		OnPlan: func(p config.Project) {
+			planned = p
+		},
+	})
+
+	returned := list.GetInputCapture()(tcell.NewEventKey(tcell.KeyRune, 'p', 0))
+	assert.Nil(t, returned)
+	assert.Equal(t, "Project A", planned.Name)
+}